@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"llm-agent/pkg/models"
+)
+
+// fileConfig is the format of the file passed with -config. Generation parameters use the
+// JSON names of models.ModelConfig, e.g. {"model": "ollama", "num_ctx": 8192, "top_k": 40}.
+type fileConfig struct {
+	Model     string          `json:"model,omitempty"` // claude, chatgpt, gemini, mistral, ollama or router
+	Redaction redactionConfig `json:"redaction"`
+	models.ModelConfig
+}
+
+// generationFlags holds the command line flags for generation parameters
+type generationFlags struct {
+	maxTokens        *int
+	temperature      *float64
+	topP             *float64
+	topK             *int
+	stop             *string
+	seed             *int
+	presencePenalty  *float64
+	frequencyPenalty *float64
+	numCtx           *int
+	keepAlive        *string
+	thinkingBudget   *int
+	contextWindow    *int
+	baseURL          *string
+}
+
+// registerGenerationFlags defines the generation parameter flags
+func registerGenerationFlags() *generationFlags {
+	return &generationFlags{
+		maxTokens:        flag.Int("max-tokens", 4096, "Maximum number of tokens to generate per response"),
+		temperature:      flag.Float64("temperature", 0.7, "Sampling temperature"),
+		topP:             flag.Float64("top-p", 0, "Nucleus sampling probability mass (0 uses the provider default)"),
+		topK:             flag.Int("top-k", 0, "Sample from the k most likely tokens (claude, ollama; 0 uses the provider default)"),
+		stop:             flag.String("stop", "", "Comma-separated stop sequences"),
+		seed:             flag.Int("seed", 0, "Random seed for reproducible sampling (chatgpt, ollama)"),
+		presencePenalty:  flag.Float64("presence-penalty", 0, "Presence penalty (chatgpt, ollama)"),
+		frequencyPenalty: flag.Float64("frequency-penalty", 0, "Frequency penalty (chatgpt, ollama)"),
+		numCtx:           flag.Int("num-ctx", 0, "Ollama context length in tokens (0 uses the model default)"),
+		keepAlive:        flag.String("keep-alive", "", "How long Ollama keeps the model loaded, e.g. 10m"),
+		thinkingBudget:   flag.Int("thinking-budget", 0, "Reasoning budget in tokens: Claude thinking budget, OpenAI reasoning effort, Ollama think on/off (0 disables)"),
+		contextWindow:    flag.Int("context-window", 0, "Context window size in tokens used for history compaction (0 uses the model default)"),
+		baseURL:          flag.String("base-url", "", "API address of the model provider, e.g. a remote Ollama server (defaults to the provider's)"),
+	}
+}
+
+// loadConfig builds the model configuration from the defaults, the optional config file and
+// the flags set on the command line, in increasing order of precedence
+func loadConfig(path string, gen *generationFlags) (fileConfig, error) {
+	config := fileConfig{
+		ModelConfig: models.ModelConfig{
+			MaxTokens:   *gen.maxTokens,
+			Temperature: *gen.temperature,
+		},
+	}
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return config, fmt.Errorf("failed to read config file: %w", err)
+		}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return config, fmt.Errorf("failed to parse config file: %w", err)
+		}
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "model":
+			config.Model = f.Value.String()
+		case "max-tokens":
+			config.MaxTokens = *gen.maxTokens
+		case "temperature":
+			config.Temperature = *gen.temperature
+		case "top-p":
+			config.TopP = *gen.topP
+		case "top-k":
+			config.TopK = *gen.topK
+		case "stop":
+			config.StopSequences = nil
+			for _, stop := range strings.Split(*gen.stop, ",") {
+				if stop != "" {
+					config.StopSequences = append(config.StopSequences, stop)
+				}
+			}
+		case "seed":
+			seed := *gen.seed
+			config.Seed = &seed
+		case "presence-penalty":
+			config.PresencePenalty = *gen.presencePenalty
+		case "frequency-penalty":
+			config.FrequencyPenalty = *gen.frequencyPenalty
+		case "num-ctx":
+			config.NumCtx = *gen.numCtx
+		case "keep-alive":
+			config.KeepAlive = *gen.keepAlive
+		case "thinking-budget":
+			config.ThinkingBudget = *gen.thinkingBudget
+		case "context-window":
+			config.ContextWindow = *gen.contextWindow
+		case "base-url":
+			config.BaseURL = *gen.baseURL
+		}
+	})
+	return config, nil
+}
+
+// isFlagSet reports whether the named flag was given on the command line
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
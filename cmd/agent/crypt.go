@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"llm-agent/pkg/storage"
+
+	"golang.org/x/term"
+)
+
+// Environment variables holding the passphrase of an encrypted chat history, and the new one
+// for history rekey, when no key file is given
+const (
+	passphraseEnv    = "LLM_AGENT_PASSPHRASE"
+	newPassphraseEnv = "LLM_AGENT_NEW_PASSPHRASE"
+)
+
+// readSecret returns the contents of keyfile, or else the passphrase in the environment
+// variable env, or else a passphrase typed on the terminal. With confirm, a typed passphrase
+// must be entered twice.
+func readSecret(keyfile, env, prompt string, confirm bool) ([]byte, error) {
+	if keyfile != "" {
+		data, err := os.ReadFile(keyfile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key file: %w", err)
+		}
+		return bytes.TrimRight(data, "\r\n"), nil
+	}
+	if passphrase := os.Getenv(env); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, fmt.Errorf("no passphrase: set %s or give a key file", env)
+	}
+
+	passphrase, err := readPassphrase(prompt)
+	if err != nil {
+		return nil, err
+	}
+	if confirm {
+		again, err := readPassphrase("Repeat the passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(passphrase, again) {
+			return nil, errors.New("the passphrases do not match")
+		}
+	}
+	return passphrase, nil
+}
+
+// readPassphrase reads a line from the terminal without echoing it
+func readPassphrase(prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	return passphrase, nil
+}
+
+// openCipher derives the key of the encrypted history at path
+func openCipher(path, keyfile string) (*storage.Cipher, error) {
+	secret, err := readSecret(keyfile, passphraseEnv, fmt.Sprintf("Passphrase for %s: ", path), false)
+	if err != nil {
+		return nil, err
+	}
+	return storage.OpenCipher(path, secret)
+}
+
+// openHistory opens the chat history at path, asking for its passphrase if it is encrypted
+func openHistory(path string, sync storage.SyncPolicy, keyfile string) (storage.Store, error) {
+	migrateLegacyHistory(path)
+	encrypted, err := storage.IsEncrypted(path)
+	if err != nil {
+		return nil, err
+	}
+	if !encrypted {
+		if keyfile != "" {
+			return nil, fmt.Errorf("%s is not encrypted, encrypt it with: llm-agent history encrypt -storage %s", path, path)
+		}
+		return storage.Open(path, sync)
+	}
+	c, err := openCipher(path, keyfile)
+	if err != nil {
+		return nil, err
+	}
+	return storage.OpenWith(path, sync, c)
+}
+
+// encryptHistory encrypts a plain chat history in place, or creates an empty encrypted one
+func encryptHistory(args []string) int {
+	flags := flag.NewFlagSet("history encrypt", flag.ExitOnError)
+	storagePath := flags.String("storage", defaultStoragePath, "Path of the chat history")
+	keyfile := flags.String("keyfile", "", "File whose contents are the key (instead of a passphrase)")
+	flags.Parse(args)
+
+	encrypted, err := storage.IsEncrypted(*storagePath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	if encrypted {
+		fmt.Printf("Error: %s is already encrypted, change its key with history rekey\n", *storagePath)
+		return 1
+	}
+
+	secret, err := readSecret(*keyfile, passphraseEnv, "New passphrase: ", true)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	to, err := storage.NewCipher(secret)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	n, err := storage.Reencrypt(*storagePath, nil, to)
+	if err != nil {
+		fmt.Printf("Error encrypting chat history: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Encrypted %d messages in %s, key info in %s\n", n, *storagePath, storage.KeyInfoPath(*storagePath))
+	return 0
+}
+
+// decryptHistory decrypts an encrypted chat history in place, or writes a decrypted copy
+func decryptHistory(args []string) int {
+	flags := flag.NewFlagSet("history decrypt", flag.ExitOnError)
+	storagePath := flags.String("storage", defaultStoragePath, "Path of the chat history")
+	keyfile := flags.String("keyfile", "", "File whose contents are the key (instead of a passphrase)")
+	output := flags.String("o", "", "Write a decrypted copy to this path (JSON Lines, or SQLite for .db files) and leave the history encrypted")
+	flags.Parse(args)
+
+	from, err := openCipher(*storagePath, *keyfile)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+
+	if *output == "" {
+		n, err := storage.Reencrypt(*storagePath, from, nil)
+		if err != nil {
+			fmt.Printf("Error decrypting chat history: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Decrypted %d messages in %s\n", n, *storagePath)
+		return 0
+	}
+
+	if _, err := os.Stat(*output); err == nil {
+		fmt.Printf("Error: %s already exists\n", *output)
+		return 1
+	}
+	src, err := storage.OpenWith(*storagePath, storage.SyncNone, from)
+	if err != nil {
+		fmt.Printf("Error opening chat history: %v\n", err)
+		return 1
+	}
+	defer src.Close()
+	dst, err := storage.Open(*output, storage.SyncAlways)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	defer dst.Close()
+	n, err := storage.Copy(dst, src)
+	if err != nil {
+		fmt.Printf("Error decrypting chat history: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Wrote %d decrypted messages to %s\n", n, *output)
+	return 0
+}
+
+// rekeyHistory re-encrypts an encrypted chat history with a new passphrase or key file
+func rekeyHistory(args []string) int {
+	flags := flag.NewFlagSet("history rekey", flag.ExitOnError)
+	storagePath := flags.String("storage", defaultStoragePath, "Path of the chat history")
+	keyfile := flags.String("keyfile", "", "File whose contents are the current key (instead of a passphrase)")
+	newKeyfile := flags.String("new-keyfile", "", "File whose contents are the new key (instead of a passphrase)")
+	flags.Parse(args)
+
+	from, err := openCipher(*storagePath, *keyfile)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	secret, err := readSecret(*newKeyfile, newPassphraseEnv, "New passphrase: ", true)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	to, err := storage.NewCipher(secret)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	n, err := storage.Reencrypt(*storagePath, from, to)
+	if err != nil {
+		fmt.Printf("Error re-encrypting chat history: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Re-encrypted %d messages in %s\n", n, *storagePath)
+	return 0
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"llm-agent/pkg/export"
+	"llm-agent/pkg/redact"
+	"llm-agent/pkg/storage"
+)
+
+// exportFormats maps the names accepted by -format to their renderers
+var exportFormats = map[string]func(io.Writer, []export.Conversation) error{
+	"markdown":  export.WriteMarkdown,
+	"html":      export.WriteHTML,
+	"openai":    export.WriteOpenAI,
+	"anthropic": export.WriteAnthropic,
+}
+
+// patternList collects the values of a repeatable regular expression flag
+type patternList []*regexp.Regexp
+
+func (p *patternList) String() string {
+	var patterns []string
+	for _, re := range *p {
+		patterns = append(patterns, re.String())
+	}
+	return strings.Join(patterns, ", ")
+}
+
+func (p *patternList) Set(value string) error {
+	re, err := regexp.Compile(value)
+	if err != nil {
+		return err
+	}
+	*p = append(*p, re)
+	return nil
+}
+
+// runExportCommand writes stored sessions as Markdown, HTML or a fine-tuning data set:
+//
+//	llm-agent export [-format markdown|html|openai|anthropic] [-session id] [-branch id|all]
+//	    [-since date] [-until date] [-model name] [-role roles] [-redact regexp]... [-redact-secrets] [-o file]
+func runExportCommand(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", "markdown", "Output format (markdown, html, openai, anthropic)")
+	storagePath := flags.String("storage", defaultStoragePath, "Path of the chat history")
+	keyfile := flags.String("keyfile", "", "File whose contents are the key of an encrypted history (instead of a passphrase)")
+	sessionID := flags.String("session", "", "Export only this session (ID or unique ID prefix)")
+	since := flags.String("since", "", "Export sessions active on or after this date (YYYY-MM-DD or RFC 3339)")
+	until := flags.String("until", "", "Export sessions started on or before this date (YYYY-MM-DD), or before this time (RFC 3339)")
+	model := flags.String("model", "", "Export sessions with responses from models whose name contains this")
+	branch := flags.String("branch", "", "Branch to export: the ID (or a unique prefix) of one of its messages, or all; defaults to the latest branch")
+	roles := flags.String("role", "", "Comma-separated roles of the messages to export (user, assistant, system)")
+	output := flags.String("o", "", "Path of a new file to write, readable only by its owner (defaults to standard output)")
+	var redactions patternList
+	flags.Var(&redactions, "redact", "Regular expression whose matches are replaced with [REDACTED] (repeatable)")
+	redactSecrets := flags.Bool("redact-secrets", false, "Also redact secrets found by the built-in detectors, e.g. in history saved with redaction off")
+	flags.Parse(args)
+
+	write, ok := exportFormats[*format]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown export format %q (expected markdown, html, openai or anthropic)\n", *format)
+		return 2
+	}
+
+	filter := export.Filter{SessionID: *sessionID, Model: *model, Roles: parseRoles(*roles), Branch: *branch}
+	var err error
+	if filter.Since, filter.Until, err = parseDateRange(*since, *until); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+
+	var redactors []export.Redactor
+	for _, re := range redactions {
+		re := re
+		redactors = append(redactors, func(text string) string {
+			return re.ReplaceAllString(text, "[REDACTED]")
+		})
+	}
+	if *redactSecrets {
+		detectors, _ := redact.Builtin()
+		redactors = append(redactors, redact.New(detectors...).Text)
+	}
+
+	store, err := openHistory(*storagePath, storage.SyncNone, *keyfile)
+	if err != nil {
+		fmt.Printf("Error opening chat history: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	conversations, err := export.Select(store, filter, redactors...)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	if len(conversations) == 0 {
+		fmt.Fprintln(os.Stderr, "No sessions match the filters")
+		return 1
+	}
+
+	if *output == "" {
+		if err := write(os.Stdout, conversations); err != nil {
+			fmt.Printf("Error writing export: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	// The export holds the history in plain text, so it is readable only by its owner like
+	// the history itself
+	file, err := os.OpenFile(*output, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		fmt.Printf("Error creating %s: %v\n", *output, err)
+		return 1
+	}
+	err = write(file, conversations)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Printf("Error writing export: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Exported %d sessions to %s\n", len(conversations), *output)
+	return 0
+}
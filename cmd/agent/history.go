@@ -0,0 +1,305 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"llm-agent/pkg/storage"
+)
+
+// defaultStoragePath is the chat history file used when -storage is not given
+const defaultStoragePath = "chat_history.jsonl"
+
+// legacyHistoryPath returns the JSON array history that earlier versions kept in place of a
+// JSON Lines history, e.g. chat_history.json for chat_history.jsonl
+func legacyHistoryPath(path string) string {
+	if filepath.Ext(path) != ".jsonl" {
+		return ""
+	}
+	return strings.TrimSuffix(path, ".jsonl") + ".json"
+}
+
+// migrateLegacyHistory converts the history of an earlier version to the JSON Lines history
+// at path when path does not exist yet, so upgrading does not silently start over. The old
+// file is left in place.
+func migrateLegacyHistory(path string) {
+	legacy := legacyHistoryPath(path)
+	if legacy == "" {
+		return
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		return
+	}
+	if _, err := os.Stat(legacy); err != nil {
+		return
+	}
+	count, err := storage.MigrateJSONArray(legacy, path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %s from an earlier version was not loaded: %v\n", legacy, err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Migrated %d messages from %s to %s\n", count, legacy, path)
+}
+
+// historyUsage lists the history subcommands
+const historyUsage = `Usage:
+  llm-agent history list [-storage path] [-n count]
+  llm-agent history search [-storage path] [-model name] [-since date] [-until date] [-role roles] [-n count] <query>
+  llm-agent history prune [-storage path] [-max-age age] [-max-sessions n] [-max-bytes size] [-archive dir] [-dry-run]
+  llm-agent history stats [-storage path] [-by day|week|month]
+  llm-agent history encrypt [-storage path] [-keyfile file]
+  llm-agent history decrypt [-storage path] [-keyfile file] [-o out]
+  llm-agent history rekey [-storage path] [-keyfile file] [-new-keyfile file]
+  llm-agent history migrate [-o out.jsonl] <file>
+`
+
+// runHistoryCommand manages chat history:
+//
+//	llm-agent history list [-storage path] [-n count]
+//	llm-agent history search [-storage path] [-model name] [-since date] [-until date] [-role roles] [-n count] <query>
+//	llm-agent history prune [-storage path] [-max-age age] [-max-sessions n] [-max-bytes size] [-archive dir] [-dry-run]
+//	llm-agent history stats [-storage path] [-by day|week|month]
+//	llm-agent history encrypt [-storage path] [-keyfile file]
+//	llm-agent history decrypt [-storage path] [-keyfile file] [-o out]
+//	llm-agent history rekey [-storage path] [-keyfile file] [-new-keyfile file]
+//	llm-agent history migrate [-o out.jsonl] <chat_history.json>
+//
+// Commands that read the history take -keyfile, or ask for the passphrase, when it is
+// encrypted.
+func runHistoryCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, historyUsage)
+		return 2
+	}
+
+	switch args[0] {
+	case "list":
+		return listSessions(args[1:])
+	case "search":
+		return searchHistory(args[1:])
+	case "prune":
+		return pruneHistory(args[1:])
+	case "stats":
+		return historyStats(args[1:])
+	case "encrypt":
+		return encryptHistory(args[1:])
+	case "decrypt":
+		return decryptHistory(args[1:])
+	case "rekey":
+		return rekeyHistory(args[1:])
+	case "migrate":
+		return migrateHistory(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown history command %q\n%s", args[0], historyUsage)
+		return 2
+	}
+}
+
+// listSessions prints the stored sessions, most recently active first
+func listSessions(args []string) int {
+	flags := flag.NewFlagSet("history list", flag.ExitOnError)
+	storagePath := flags.String("storage", defaultStoragePath, "Path of the chat history")
+	keyfile := flags.String("keyfile", "", "File whose contents are the key of an encrypted history (instead of a passphrase)")
+	limit := flags.Int("n", 20, "Number of sessions to show (0 shows all)")
+	flags.Parse(args)
+
+	store, err := openHistory(*storagePath, storage.SyncNone, *keyfile)
+	if err != nil {
+		fmt.Printf("Error opening chat history: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	sessions, err := store.ListSessions()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	if len(sessions) == 0 {
+		fmt.Println("No sessions stored")
+		return 0
+	}
+	if *limit > 0 && len(sessions) > *limit {
+		sessions = sessions[:*limit]
+	}
+
+	fmt.Printf("%-36s  %-16s  %-30s  %5s  %s\n", "ID", "DATE", "MODEL", "TURNS", "FIRST PROMPT")
+	for _, session := range sessions {
+		fmt.Printf("%-36s  %-16s  %-30s  %5d  %s\n",
+			session.ID,
+			session.StartedAt.Local().Format("2006-01-02 15:04"),
+			truncate(session.Model, 30),
+			session.Turns,
+			truncate(oneLine(session.FirstPrompt), 60))
+	}
+	return 0
+}
+
+// searchHistory prints the messages matching a full-text query, best matches first
+func searchHistory(args []string) int {
+	flags := flag.NewFlagSet("history search", flag.ExitOnError)
+	storagePath := flags.String("storage", defaultStoragePath, "Path of the chat history")
+	keyfile := flags.String("keyfile", "", "File whose contents are the key of an encrypted history (instead of a passphrase)")
+	model := flags.String("model", "", "Only messages from models whose name contains this")
+	since := flags.String("since", "", "Only messages from this date on (YYYY-MM-DD or RFC 3339)")
+	until := flags.String("until", "", "Only messages up to this date (YYYY-MM-DD), or before this time (RFC 3339)")
+	roles := flags.String("role", "", "Comma-separated roles of the messages to search (user, assistant, system)")
+	limit := flags.Int("n", 10, "Number of results to show (0 shows all)")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: llm-agent history search [flags] <query>\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	query := storage.SearchQuery{
+		Text:  strings.Join(flags.Args(), " "),
+		Model: *model,
+		Roles: parseRoles(*roles),
+		Limit: *limit,
+	}
+	var err error
+	if query.Since, query.Until, err = parseDateRange(*since, *until); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+
+	store, err := openHistory(*storagePath, storage.SyncNone, *keyfile)
+	if err != nil {
+		fmt.Printf("Error opening chat history: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	results, err := store.Search(query)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	if len(results) == 0 {
+		fmt.Println("No messages found")
+		return 0
+	}
+
+	// Matches are shown in bold on a terminal
+	start, end := "", ""
+	if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		start, end = "\033[1m", "\033[0m"
+	}
+	for _, result := range results {
+		msg := result.Message
+		fmt.Printf("%s  %s  %-9s  %-30s  %.2f\n",
+			msg.ConversationID,
+			msg.Timestamp.Local().Format("2006-01-02 15:04"),
+			msg.Role,
+			truncate(msg.Model, 30),
+			result.Score)
+		fmt.Printf("    %s\n\n", result.Highlight(start, end))
+	}
+	return 0
+}
+
+// parseRoles splits a comma-separated list of message roles
+func parseRoles(value string) []string {
+	var roles []string
+	for _, role := range strings.Split(value, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// dateLayout is the layout of dates given without a time
+const dateLayout = "2006-01-02"
+
+// parseDateRange parses the -since and -until flags. Each is a local date or an RFC 3339
+// time; an -until date includes the whole day.
+func parseDateRange(since, until string) (time.Time, time.Time, error) {
+	start, err := parseDate(since)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid -since: %w", err)
+	}
+	end, err := parseDate(until)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid -until: %w", err)
+	}
+	if len(until) == len(dateLayout) {
+		end = end.AddDate(0, 0, 1)
+	}
+	return start, end, nil
+}
+
+// parseDate parses a date given on the command line as a local date or an RFC 3339 time
+func parseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation(dateLayout, value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// findSession returns the session to resume: the one matching id, or the most recent one
+// when id is empty
+func findSession(store storage.Store, id string) (storage.Session, error) {
+	if id == "" {
+		return storage.LatestSession(store)
+	}
+	return storage.FindSession(store, id)
+}
+
+// oneLine joins the lines of a text with spaces
+func oneLine(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// truncate shortens a text to at most n runes, marking the cut with an ellipsis
+func truncate(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+// migrateHistory converts a history file in the old JSON array format to JSON Lines
+func migrateHistory(args []string) int {
+	flags := flag.NewFlagSet("history migrate", flag.ExitOnError)
+	output := flags.String("o", "", "Path of the JSON Lines file to write (defaults to the input with a .jsonl extension)")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: llm-agent history migrate [-o out.jsonl] <file>\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	src := flags.Arg(0)
+	dst := *output
+	if dst == "" {
+		dst = strings.TrimSuffix(src, filepath.Ext(src)) + ".jsonl"
+	}
+	if dst == src {
+		fmt.Println("Error: the input already has a .jsonl extension, choose an output path with -o")
+		return 1
+	}
+
+	count, err := storage.MigrateJSONArray(src, dst)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Migrated %d messages from %s to %s\n", count, src, dst)
+	return 0
+}
@@ -7,25 +7,97 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 
 	"llm-agent/pkg/agent"
+	"llm-agent/pkg/index"
 	"llm-agent/pkg/models"
+	"llm-agent/pkg/storage"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "models" {
+		os.Exit(runModelsCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "history" {
+		os.Exit(runHistoryCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		os.Exit(runExportCommand(os.Args[2:]))
+	}
+
 	showStats := flag.Bool("stats", false, "Show statistics when the program exits")
-	modelType := flag.String("model", "claude", "Model to use (claude, chatgpt, ollama)")
+	statsJSON := flag.String("stats-json", "", "Path to export per-turn statistics as JSON when the program exits")
+	modelType := flag.String("model", "claude", "Model to use (claude, chatgpt, gemini, mistral, ollama, router)")
 	ollamaModel := flag.String("ollama-model", "llama2", "Model to use with Ollama (e.g., llama2, mistral)")
-	claudeModel := flag.String("claude-model", "claude-3-sonnet-20240229", "Model to use with Claude (e.g., claude-3-sonnet-20240229, claude-3-opus-20240229)")
+	claudeModel := flag.String("claude-model", "claude-3-7-sonnet-latest", "Model to use with Claude (e.g., claude-3-7-sonnet-latest, claude-3-opus-20240229)")
 	chatgptModel := flag.String("chatgpt-model", "gpt-3.5-turbo", "Model to use with ChatGPT (e.g., gpt-3.5-turbo, gpt-4)")
-	storagePath := flag.String("storage", "chat_history.json", "Path to store chat history")
+	geminiModel := flag.String("gemini-model", "gemini-2.0-flash", "Model to use with Gemini (e.g., gemini-2.0-flash, gemini-2.5-pro)")
+	mistralModel := flag.String("mistral-model", "mistral-large-latest", "Model to use with Mistral (e.g., mistral-large-latest, pixtral-large-latest)")
+	storagePath := flag.String("storage", defaultStoragePath, "Path to store chat history (JSON Lines, or SQLite for .db, .sqlite and .sqlite3 files)")
+	resumeID := flag.String("resume", "", "Resume the stored session with this ID (or a unique prefix of it)")
+	continueSession := flag.Bool("continue", false, "Resume the most recent stored session")
+	branchID := flag.String("branch", "", "With -resume or -continue, the branch to continue: the ID (or a unique prefix) of one of its messages")
+	retention := registerRetentionFlags(flag.CommandLine, "retention-")
+	storageKeyfile := flag.String("storage-keyfile", "", "File whose contents are the key of an encrypted chat history (instead of a passphrase)")
+	storageSync := flag.String("storage-sync", "always", "When chat history is flushed to disk (always, none); none leaves it to the operating system")
 	workspaceRoot := flag.String("workspace", ".", "Workspace root directory")
+	pricingPath := flag.String("pricing", "", "Path to a JSON file overriding the built-in model pricing table")
+	budgetTokens := flag.Int64("budget-tokens", 0, "Stop the session after this many input and output tokens (0 disables)")
+	budgetCost := flag.Float64("budget-cost", 0, "Stop the session after spending this many US dollars (0 disables)")
+	budgetTime := flag.Duration("budget-time", 0, "Stop the session after this much wall-clock time, e.g. 30m (0 disables)")
+	budgetToolCalls := flag.Int("budget-tool-calls", 0, "Stop the session after this many tool calls (0 disables)")
+	embedderType := flag.String("embedder", "none", "Embedding backend for the search_code tool (none, openai, ollama, hash); none uses BM25 keyword search")
+	embeddingModel := flag.String("embedding-model", "", "Embedding model to use (defaults to text-embedding-3-small for openai, nomic-embed-text for ollama)")
+	indexPath := flag.String("index", "", "Path of the code search index (defaults to .llm-agent/index.gob in the workspace)")
+	routerConfigPath := flag.String("router-config", "", "Path to a JSON file with the routes and rules of -model router")
+	toolStrategyName := flag.String("tool-strategy", "auto", "How tools are offered to the model (auto, native, xml, json); auto uses native function calling when the model supports it")
+	showReasoning := flag.Bool("show-reasoning", false, "Stream model reasoning in full instead of collapsing it (use /reasoning to expand)")
+	redactSecrets := flag.Bool("redact-secrets", true, "Redact secrets such as API keys and private keys in tool results before they are sent to the model and in messages before they are saved")
+	var redactPatterns patternList
+	flag.Var(&redactPatterns, "redact-pattern", "Regular expression for an additional kind of secret to redact (repeatable)")
+	configPath := flag.String("config", "", "Path to a JSON config file with the model type and generation parameters")
+	generation := registerGenerationFlags()
 	flag.Parse()
 
+	config, err := loadConfig(*configPath, generation)
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	if config.Model != "" {
+		*modelType = config.Model
+	}
+	toolStrategy, err := agent.ParseToolStrategy(*toolStrategyName)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	redaction, err := buildRedaction(config.Redaction, *modelType, *redactSecrets, redactPatterns)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	syncPolicy, err := storage.ParseSyncPolicy(*storageSync)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// modelConfig returns the shared configuration for the given model name. A model name in
+	// the config file applies unless the provider's model flag was set explicitly.
+	modelConfig := func(apiKey, modelName, modelFlag string) models.ModelConfig {
+		c := config.ModelConfig
+		c.APIKey = apiKey
+		if c.ModelName == "" || isFlagSet(modelFlag) {
+			c.ModelName = modelName
+		}
+		return c
+	}
+
 	// Initialize model
 	var model models.Model
-	var err error
 	switch *modelType {
 	case "claude":
 		if os.Getenv("ANTHROPIC_API_KEY") == "" {
@@ -34,12 +106,7 @@ func main() {
 			fmt.Println("  export ANTHROPIC_API_KEY=your-api-key")
 			os.Exit(1)
 		}
-		model, err = models.NewClaudeModel(models.ModelConfig{
-			APIKey:      os.Getenv("ANTHROPIC_API_KEY"),
-			ModelName:   *claudeModel,
-			MaxTokens:   1024,
-			Temperature: 0.7,
-		})
+		model, err = models.NewClaudeModel(modelConfig(os.Getenv("ANTHROPIC_API_KEY"), *claudeModel, "claude-model"))
 	case "chatgpt":
 		if os.Getenv("OPENAI_API_KEY") == "" {
 			fmt.Println("Error: OPENAI_API_KEY environment variable is not set")
@@ -47,18 +114,38 @@ func main() {
 			fmt.Println("  export OPENAI_API_KEY=your-api-key")
 			os.Exit(1)
 		}
-		model, err = models.NewChatGPTModel(models.ModelConfig{
-			APIKey:      os.Getenv("OPENAI_API_KEY"),
-			ModelName:   *chatgptModel,
-			MaxTokens:   1024,
-			Temperature: 0.7,
-		})
+		model, err = models.NewChatGPTModel(modelConfig(os.Getenv("OPENAI_API_KEY"), *chatgptModel, "chatgpt-model"))
+	case "gemini":
+		if geminiAPIKey() == "" {
+			fmt.Println("Error: GEMINI_API_KEY environment variable is not set")
+			fmt.Println("Please set your API key using:")
+			fmt.Println("  export GEMINI_API_KEY=your-api-key")
+			os.Exit(1)
+		}
+		model, err = models.NewGeminiModel(modelConfig(geminiAPIKey(), *geminiModel, "gemini-model"))
+	case "mistral":
+		if os.Getenv("MISTRAL_API_KEY") == "" {
+			fmt.Println("Error: MISTRAL_API_KEY environment variable is not set")
+			fmt.Println("Please set your API key using:")
+			fmt.Println("  export MISTRAL_API_KEY=your-api-key")
+			os.Exit(1)
+		}
+		model, err = models.NewMistralModel(modelConfig(os.Getenv("MISTRAL_API_KEY"), *mistralModel, "mistral-model"))
 	case "ollama":
-		model, err = models.NewOllamaModel(models.ModelConfig{
-			ModelName:   *ollamaModel,
-			MaxTokens:   1024,
-			Temperature: 0.7,
-		})
+		var ollama *models.OllamaModel
+		ollama, err = models.NewOllamaModel(modelConfig("", *ollamaModel, "ollama-model"))
+		if err == nil {
+			err = prepareOllama(ollama)
+		}
+		model = ollama
+	case "router":
+		var router *models.RouterModel
+		var backends map[string]string
+		router, backends, err = newRouterModel(*routerConfigPath, config.ModelConfig)
+		if redaction.Redactor != nil {
+			redaction.Routes = routeRedaction(config.Redaction, backends)
+		}
+		model = router
 	default:
 		fmt.Printf("Error: Unknown model type %s\n", *modelType)
 		os.Exit(1)
@@ -69,6 +156,44 @@ func main() {
 		os.Exit(1)
 	}
 
+	pricing, err := models.LoadPricing(*pricingPath)
+	if err != nil {
+		fmt.Printf("Error loading pricing: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Initialize the code search index
+	var embedder models.Embedder
+	switch *embedderType {
+	case "none":
+	case "openai":
+		embedder, err = models.NewOpenAIEmbedder(models.ModelConfig{
+			APIKey:    os.Getenv("OPENAI_API_KEY"),
+			ModelName: *embeddingModel,
+		})
+	case "ollama":
+		embedder, err = models.NewOllamaEmbedder(models.ModelConfig{
+			ModelName: *embeddingModel,
+		})
+	case "hash":
+		embedder = models.NewHashEmbedder(0)
+	default:
+		fmt.Printf("Error: Unknown embedder %s\n", *embedderType)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Printf("Error initializing embedder: %v\n", err)
+		os.Exit(1)
+	}
+	if *indexPath == "" {
+		*indexPath = filepath.Join(*workspaceRoot, ".llm-agent", "index.gob")
+	}
+	codeIndex, err := index.New(*workspaceRoot, *indexPath, embedder)
+	if err != nil {
+		fmt.Printf("Error opening code index: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Initialize user input
 	scanner := bufio.NewScanner(os.Stdin)
 	getUserInput := func() (string, bool) {
@@ -78,13 +203,27 @@ func main() {
 		return scanner.Text(), true
 	}
 
+	budget := agent.Budget{
+		MaxTokens:    *budgetTokens,
+		MaxCost:      *budgetCost,
+		MaxDuration:  *budgetTime,
+		MaxToolCalls: *budgetToolCalls,
+	}
+
+	store, err := openHistory(*storagePath, syncPolicy, *storageKeyfile)
+	if err != nil {
+		fmt.Printf("Error opening chat history: %v\n", err)
+		os.Exit(1)
+	}
+	defer store.Close()
+
 	// Create and run agent
 	agent, err := agent.NewAgent(
 		model,
 		getUserInput,
 		nil, // tools will be initialized by the agent
 		*showStats,
-		*storagePath,
+		store,
 		*workspaceRoot,
 	)
 	if err != nil {
@@ -92,6 +231,32 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *branchID != "" && *resumeID == "" && !*continueSession {
+		fmt.Println("Error: -branch needs -resume or -continue")
+		os.Exit(1)
+	}
+	if *resumeID != "" || *continueSession {
+		session, err := findSession(store, *resumeID)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := agent.Resume(session.ID, *branchID); err != nil {
+			fmt.Printf("Error resuming session: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if err := applyRetention(store, retention, agent.SessionID()); err != nil {
+		fmt.Printf("Warning: failed to apply chat history retention: %v\n", err)
+	}
+
+	agent.SetPricing(pricing)
+	agent.SetCodeSearcher(codeIndex)
+	agent.SetBudget(budget)
+	agent.SetToolStrategy(toolStrategy)
+	agent.SetShowReasoning(*showReasoning)
+	agent.SetRedaction(redaction)
+
 	// Set up signal handling for graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -115,4 +280,9 @@ func main() {
 	if *showStats {
 		agent.PrintStats()
 	}
+	if *statsJSON != "" {
+		if err := agent.ExportStats(*statsJSON); err != nil {
+			fmt.Printf("Error exporting statistics: %v\n", err)
+		}
+	}
 }
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"llm-agent/pkg/models"
+
+	"golang.org/x/term"
+)
+
+// progressBarWidth is the number of characters in the pull progress bar
+const progressBarWidth = 30
+
+// runModelsCommand manages the models of the Ollama server:
+//
+//	llm-agent models list
+//	llm-agent models pull <name>
+//	llm-agent models show <name>
+func runModelsCommand(args []string) int {
+	flags := flag.NewFlagSet("models", flag.ExitOnError)
+	baseURL := flags.String("base-url", "", "Ollama server address (defaults to OLLAMA_HOST or http://localhost:11434)")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: llm-agent models [-base-url URL] list | pull <name> | show <name>\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	command, name := flags.Arg(0), flags.Arg(1)
+	if command != "list" && name == "" {
+		flags.Usage()
+		return 2
+	}
+
+	ollama, err := models.NewOllamaModel(models.ModelConfig{ModelName: name, BaseURL: *baseURL})
+	if err != nil {
+		fmt.Printf("Error initializing Ollama: %v\n", err)
+		return 1
+	}
+
+	ctx := context.Background()
+	switch command {
+	case "list":
+		err = listModels(ctx, ollama)
+	case "pull":
+		err = ollama.PullModel(ctx, name, newPullProgressPrinter())
+	case "show":
+		err = showModel(ctx, ollama, name)
+	default:
+		flags.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// listModels prints the installed models
+func listModels(ctx context.Context, ollama *models.OllamaModel) error {
+	installed, err := ollama.ListModels(ctx)
+	if err != nil {
+		return err
+	}
+	if len(installed) == 0 {
+		fmt.Println("No models installed, pull one with: llm-agent models pull <name>")
+		return nil
+	}
+
+	fmt.Printf("%-40s %-10s %-8s %-10s %s\n", "NAME", "SIZE", "PARAMS", "QUANT", "MODIFIED")
+	for _, info := range installed {
+		fmt.Printf("%-40s %-10s %-8s %-10s %s\n",
+			info.Name,
+			formatBytes(info.Size),
+			info.Details.ParameterSize,
+			info.Details.QuantizationLevel,
+			info.ModifiedAt.Format("2006-01-02 15:04"))
+	}
+	return nil
+}
+
+// showModel prints the details and capabilities of a model
+func showModel(ctx context.Context, ollama *models.OllamaModel, name string) error {
+	info, err := ollama.ShowModel(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Model: %s\n", name)
+	fmt.Printf("Family: %s\n", info.Details.Family)
+	fmt.Printf("Parameters: %s\n", info.Details.ParameterSize)
+	fmt.Printf("Quantization: %s\n", info.Details.QuantizationLevel)
+	if length := info.ContextLength(); length > 0 {
+		fmt.Printf("Context length: %d\n", length)
+	}
+	if len(info.Capabilities) > 0 {
+		fmt.Printf("Capabilities: %s\n", strings.Join(info.Capabilities, ", "))
+	}
+	fmt.Printf("Native tool calls: %t\n", info.SupportsTools())
+	if info.Parameters != "" {
+		fmt.Printf("Default parameters:\n%s\n", info.Parameters)
+	}
+	return nil
+}
+
+// newPullProgressPrinter returns a progress callback that draws a progress bar for each
+// layer being downloaded and prints the other pull steps on their own line
+func newPullProgressPrinter() func(progress models.OllamaPullProgress) {
+	var lastStatus string
+	return func(progress models.OllamaPullProgress) {
+		if progress.Total == 0 {
+			if lastStatus != "" {
+				fmt.Println()
+			}
+			fmt.Println(progress.Status)
+			lastStatus = ""
+			return
+		}
+
+		if lastStatus != "" && lastStatus != progress.Status {
+			fmt.Println()
+		}
+		lastStatus = progress.Status
+
+		filled := int(float64(progressBarWidth) * float64(progress.Completed) / float64(progress.Total))
+		if filled > progressBarWidth {
+			filled = progressBarWidth
+		}
+		fmt.Printf("\r%s [%s%s] %3d%% %s/%s",
+			progress.Status,
+			strings.Repeat("#", filled),
+			strings.Repeat(" ", progressBarWidth-filled),
+			progress.Completed*100/progress.Total,
+			formatBytes(progress.Completed),
+			formatBytes(progress.Total))
+	}
+}
+
+// prepareOllama makes sure the model is installed, asking before pulling a missing one, and
+// warns when it cannot call tools natively. Without a terminal to ask on, a missing model is
+// an error.
+func prepareOllama(ollama *models.OllamaModel) error {
+	missing := ""
+	confirmPull := func(name string) bool {
+		missing = name
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return false
+		}
+		fmt.Fprintf(os.Stderr, "Ollama model %s is not installed. Pull it now? [y/N] ", name)
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		return answer == "y" || answer == "yes"
+	}
+	info, err := ollama.Prepare(context.Background(), confirmPull, newPullProgressPrinter())
+	if err != nil {
+		if missing != "" {
+			return fmt.Errorf("%w (pull it with: llm-agent models pull %s)", err, missing)
+		}
+		return err
+	}
+	if !info.SupportsTools() {
+		fmt.Fprintf(os.Stderr, "Warning: %s does not support native tool calls, tools are only available through the XML prompt\n", ollama.GetName())
+	}
+	return nil
+}
+
+// formatBytes formats a size in bytes for display
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"sort"
+
+	"llm-agent/pkg/agent"
+	"llm-agent/pkg/redact"
+)
+
+// redactionConfig is the "redaction" section of the config file, e.g.
+//
+//	{"redaction": {"patterns": {"internal-token": "itk_[a-z0-9]{32}"}, "backends": {"ollama": false}}}
+type redactionConfig struct {
+	Enabled   *bool             `json:"enabled,omitempty"`   // Defaults to true
+	Detectors []string          `json:"detectors,omitempty"` // Built-in detectors to use, all by default
+	Patterns  map[string]string `json:"patterns,omitempty"`  // Regular expressions by the kind named in placeholders
+	Tools     map[string]bool   `json:"tools,omitempty"`     // false sends a tool's results unredacted
+	Backends  map[string]bool   `json:"backends,omitempty"`  // false sends tool results to a backend, or the router routes using it, unredacted
+	Storage   *bool             `json:"storage,omitempty"`   // false saves messages unredacted; defaults to true
+}
+
+// buildRedaction resolves the redaction settings for the model backend from the config file
+// and the -redact-secrets and -redact-pattern flags
+func buildRedaction(config redactionConfig, backend string, enabled bool, patterns patternList) (agent.Redaction, error) {
+	if isFlagSet("redact-secrets") || config.Enabled == nil {
+		config.Enabled = &enabled
+	}
+	if !*config.Enabled {
+		return agent.Redaction{}, nil
+	}
+
+	detectors, err := redact.Builtin(config.Detectors...)
+	if err != nil {
+		return agent.Redaction{}, err
+	}
+	// Custom patterns run before the built-in detectors, so their kind names the placeholder
+	kinds := make([]string, 0, len(config.Patterns))
+	for kind := range config.Patterns {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	var custom []redact.Detector
+	for _, kind := range kinds {
+		detector, err := redact.Pattern(kind, config.Patterns[kind])
+		if err != nil {
+			return agent.Redaction{}, err
+		}
+		custom = append(custom, detector)
+	}
+	for _, re := range patterns {
+		custom = append(custom, redact.Detector{Kind: "pattern", Pattern: re})
+	}
+
+	redaction := agent.Redaction{
+		Redactor:    redact.New(append(custom, detectors...)...),
+		ToolResults: true,
+		SkipTools:   make(map[string]bool),
+		Storage:     config.Storage == nil || *config.Storage,
+	}
+	if on, ok := config.Backends[backend]; ok {
+		redaction.ToolResults = on
+	}
+	for tool, on := range config.Tools {
+		redaction.SkipTools[tool] = !on
+	}
+	return redaction, nil
+}
+
+// routeRedaction resolves the per-backend settings for the routes of a router, given the
+// backend of each route
+func routeRedaction(config redactionConfig, backends map[string]string) map[string]bool {
+	routes := make(map[string]bool)
+	for route, backend := range backends {
+		if on, ok := config.Backends[backend]; ok {
+			routes[route] = on
+		}
+	}
+	return routes
+}
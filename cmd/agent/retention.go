@@ -0,0 +1,246 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"llm-agent/pkg/storage"
+)
+
+// ageValue is a duration flag that also accepts days, e.g. 30d
+type ageValue time.Duration
+
+func (a *ageValue) String() string {
+	return time.Duration(*a).String()
+}
+
+func (a *ageValue) Set(value string) error {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number of days %q", value)
+		}
+		*a = ageValue(time.Duration(n * float64(24*time.Hour)))
+		return nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*a = ageValue(d)
+	return nil
+}
+
+// byteSize is a size flag that accepts KB, MB and GB suffixes (powers of 1024)
+type byteSize int64
+
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+
+func (b *byteSize) String() string {
+	return formatBytes(int64(*b))
+}
+
+func (b *byteSize) Set(value string) error {
+	upper := strings.ToUpper(strings.TrimSpace(value))
+	unit := int64(1)
+	for _, u := range byteUnits {
+		if number, ok := strings.CutSuffix(upper, u.suffix); ok {
+			upper, unit = strings.TrimSpace(number), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(upper, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q", value)
+	}
+	*b = byteSize(n * float64(unit))
+	return nil
+}
+
+// retentionFlags holds the flags of a retention policy
+type retentionFlags struct {
+	maxAge      ageValue
+	maxSessions *int
+	maxBytes    byteSize
+	archive     *string
+}
+
+// registerRetentionFlags defines the retention flags on a flag set, with names starting with
+// prefix
+func registerRetentionFlags(flags *flag.FlagSet, prefix string) *retentionFlags {
+	r := &retentionFlags{}
+	flags.Var(&r.maxAge, prefix+"max-age", "Prune sessions without messages for this long, e.g. 720h or 30d (0 disables)")
+	r.maxSessions = flags.Int(prefix+"max-sessions", 0, "Keep at most this many sessions, pruning the least recently active (0 disables)")
+	flags.Var(&r.maxBytes, prefix+"max-bytes", "Keep at most this much history, e.g. 50MB, pruning the least recently active sessions (0 disables)")
+	r.archive = flags.String(prefix+"archive", "", "Directory where pruned sessions are saved as gzip-compressed JSON Lines before they are deleted")
+	return r
+}
+
+// policy returns the retention policy set by the flags
+func (r *retentionFlags) policy() storage.RetentionPolicy {
+	return storage.RetentionPolicy{
+		MaxAge:      time.Duration(r.maxAge),
+		MaxSessions: *r.maxSessions,
+		MaxBytes:    int64(r.maxBytes),
+	}
+}
+
+// applyRetention prunes the sessions that fall outside the retention policy, except keep
+func applyRetention(store storage.Store, retention *retentionFlags, keep ...string) error {
+	plan, err := storage.PlanPrune(store, retention.policy(), time.Now(), keep...)
+	if err != nil || len(plan) == 0 {
+		return err
+	}
+	archive, err := storage.Prune(store, sessionIDs(plan), *retention.archive)
+	if err != nil {
+		return err
+	}
+	if archive != "" {
+		fmt.Printf("Pruned %d sessions from the chat history, archived to %s\n", len(plan), archive)
+	} else {
+		fmt.Printf("Pruned %d sessions from the chat history\n", len(plan))
+	}
+	return nil
+}
+
+func sessionIDs(sessions []storage.SessionSize) []string {
+	ids := make([]string, len(sessions))
+	for i, session := range sessions {
+		ids[i] = session.ID
+	}
+	return ids
+}
+
+// pruneHistory deletes the sessions that fall outside a retention policy
+func pruneHistory(args []string) int {
+	flags := flag.NewFlagSet("history prune", flag.ExitOnError)
+	storagePath := flags.String("storage", defaultStoragePath, "Path of the chat history")
+	keyfile := flags.String("keyfile", "", "File whose contents are the key of an encrypted history (instead of a passphrase)")
+	dryRun := flags.Bool("dry-run", false, "List the sessions that would be pruned without deleting them")
+	retention := registerRetentionFlags(flags, "")
+	flags.Parse(args)
+
+	policy := retention.policy()
+	if policy.IsZero() {
+		fmt.Fprintln(os.Stderr, "Set at least one of -max-age, -max-sessions and -max-bytes")
+		return 2
+	}
+
+	store, err := openHistory(*storagePath, storage.SyncAlways, *keyfile)
+	if err != nil {
+		fmt.Printf("Error opening chat history: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	plan, err := storage.PlanPrune(store, policy, time.Now())
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	if len(plan) == 0 {
+		fmt.Println("No sessions to prune")
+		return 0
+	}
+
+	var total int64
+	fmt.Printf("%-36s  %-16s  %8s  %5s  %s\n", "ID", "LAST ACTIVE", "SIZE", "TURNS", "FIRST PROMPT")
+	for _, session := range plan {
+		total += session.Bytes
+		fmt.Printf("%-36s  %-16s  %8s  %5d  %s\n",
+			session.ID,
+			session.UpdatedAt.Local().Format("2006-01-02 15:04"),
+			formatBytes(session.Bytes),
+			session.Turns,
+			truncate(oneLine(session.FirstPrompt), 50))
+	}
+	if *dryRun {
+		fmt.Printf("\nWould prune %d sessions (%s)\n", len(plan), formatBytes(total))
+		return 0
+	}
+
+	archive, err := storage.Prune(store, sessionIDs(plan), *retention.archive)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	fmt.Printf("\nPruned %d sessions (%s)\n", len(plan), formatBytes(total))
+	if archive != "" {
+		fmt.Printf("Archived to %s\n", archive)
+	}
+	return 0
+}
+
+// historyStats prints the size of the chat history and its usage by model and over time
+func historyStats(args []string) int {
+	flags := flag.NewFlagSet("history stats", flag.ExitOnError)
+	storagePath := flags.String("storage", defaultStoragePath, "Path of the chat history")
+	keyfile := flags.String("keyfile", "", "File whose contents are the key of an encrypted history (instead of a passphrase)")
+	periodName := flags.String("by", "month", "Period to group usage by (day, week, month)")
+	flags.Parse(args)
+
+	period, err := storage.ParseStatsPeriod(*periodName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+
+	store, err := openHistory(*storagePath, storage.SyncNone, *keyfile)
+	if err != nil {
+		fmt.Printf("Error opening chat history: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	messages, err := store.Messages()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	stats := storage.ComputeStats(messages, period)
+
+	// SQLite keeps recent changes in a write-ahead log next to the database
+	var size int64
+	for _, path := range []string{*storagePath, *storagePath + "-wal"} {
+		if info, err := os.Stat(path); err == nil {
+			size += info.Size()
+		}
+	}
+
+	fmt.Printf("File: %s (%s)\n", *storagePath, formatBytes(size))
+	if stats.Messages == 0 {
+		fmt.Println("No messages stored")
+		return 0
+	}
+	fmt.Printf("Sessions: %d, messages: %d, from %s to %s\n",
+		stats.Sessions,
+		stats.Messages,
+		stats.First.Local().Format("2006-01-02"),
+		stats.Last.Local().Format("2006-01-02"))
+	fmt.Printf("Responses: %d, input tokens: %d, output tokens: %d, cost: $%.4f\n",
+		stats.Total.Responses, stats.Total.InputTokens, stats.Total.OutputTokens, stats.Total.Cost)
+
+	fmt.Printf("\n%-30s  %9s  %12s  %12s  %10s\n", "MODEL", "RESPONSES", "INPUT", "OUTPUT", "COST")
+	for _, model := range stats.Models {
+		fmt.Printf("%-30s  %9d  %12d  %12d  %10s\n",
+			truncate(model.Model, 30), model.Responses, model.InputTokens, model.OutputTokens, fmt.Sprintf("$%.4f", model.Cost))
+	}
+
+	layout := "2006-01-02"
+	if period == storage.PeriodMonth {
+		layout = "2006-01"
+	}
+	fmt.Printf("\n%-10s  %8s  %8s  %12s  %12s  %10s\n", strings.ToUpper(string(period)), "SESSIONS", "MESSAGES", "INPUT", "OUTPUT", "COST")
+	for _, p := range stats.Periods {
+		fmt.Printf("%-10s  %8d  %8d  %12d  %12d  %10s\n",
+			p.Start.Format(layout), p.Sessions, p.Messages, p.InputTokens, p.OutputTokens, fmt.Sprintf("$%.4f", p.Cost))
+	}
+	return 0
+}
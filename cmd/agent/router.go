@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"llm-agent/pkg/models"
+)
+
+// routerConfig is the format of the file passed with -router-config, e.g.
+//
+//	{
+//	  "default": "local",
+//	  "routes": [
+//	    {"name": "local", "model": "ollama", "model_name": "llama3.2", "description": "Quick questions and listing files"},
+//	    {"name": "smart", "model": "claude", "model_name": "claude-3-7-sonnet-latest", "description": "Refactoring and writing code"}
+//	  ],
+//	  "rules": [{"route": "smart", "keywords": ["refactor", "implement"]}]
+//	}
+type routerConfig struct {
+	Default    string             `json:"default,omitempty"`
+	Classifier string             `json:"classifier,omitempty"` // Route whose model picks the route when no rule matches
+	Routes     []json.RawMessage  `json:"routes"`
+	Rules      []models.RouteRule `json:"rules,omitempty"`
+}
+
+// routeConfig configures the model of a route. Generation parameters not set for the route
+// are taken from the flags and the -config file.
+type routeConfig struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Model       string `json:"model"` // claude, chatgpt, gemini, mistral or ollama
+	models.ModelConfig
+}
+
+// newRouterModel creates a router model from a router config file. It also returns the
+// backend of each route, by route name.
+func newRouterModel(path string, base models.ModelConfig) (*models.RouterModel, map[string]string, error) {
+	if path == "" {
+		return nil, nil, fmt.Errorf("-model router needs -router-config")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read router config: %w", err)
+	}
+	var config routerConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse router config: %w", err)
+	}
+
+	var routes []models.Route
+	backends := make(map[string]string)
+	for _, raw := range config.Routes {
+		route := routeConfig{ModelConfig: base}
+		route.ModelName = ""
+		if err := json.Unmarshal(raw, &route); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse route: %w", err)
+		}
+		if route.Name == "" {
+			route.Name = route.Model
+		}
+		model, err := newProviderModel(route.Model, route.ModelConfig)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to initialize route %s: %w", route.Name, err)
+		}
+		routes = append(routes, models.Route{Name: route.Name, Description: route.Description, Model: model})
+		backends[route.Name] = route.Model
+	}
+
+	router, err := models.NewRouterModel(routes, config.Rules, config.Default)
+	if err != nil {
+		return nil, nil, err
+	}
+	if config.Classifier != "" {
+		var classifier models.Model
+		for _, route := range routes {
+			if route.Name == config.Classifier {
+				classifier = route.Model
+			}
+		}
+		if classifier == nil {
+			return nil, nil, fmt.Errorf("unknown classifier route %q", config.Classifier)
+		}
+		router.SetClassifier(classifier)
+	}
+	return router, backends, nil
+}
+
+// newProviderModel creates a model of the given provider, taking API keys from the environment
+func newProviderModel(provider string, config models.ModelConfig) (models.Model, error) {
+	switch provider {
+	case "claude":
+		config.APIKey = os.Getenv("ANTHROPIC_API_KEY")
+		if config.APIKey == "" {
+			return nil, fmt.Errorf("ANTHROPIC_API_KEY environment variable is not set")
+		}
+		return models.NewClaudeModel(config)
+	case "chatgpt":
+		config.APIKey = os.Getenv("OPENAI_API_KEY")
+		if config.APIKey == "" {
+			return nil, fmt.Errorf("OPENAI_API_KEY environment variable is not set")
+		}
+		return models.NewChatGPTModel(config)
+	case "gemini":
+		config.APIKey = geminiAPIKey()
+		if config.APIKey == "" {
+			return nil, fmt.Errorf("GEMINI_API_KEY environment variable is not set")
+		}
+		return models.NewGeminiModel(config)
+	case "mistral":
+		config.APIKey = os.Getenv("MISTRAL_API_KEY")
+		if config.APIKey == "" {
+			return nil, fmt.Errorf("MISTRAL_API_KEY environment variable is not set")
+		}
+		return models.NewMistralModel(config)
+	case "ollama":
+		ollama, err := models.NewOllamaModel(config)
+		if err != nil {
+			return nil, err
+		}
+		if err := prepareOllama(ollama); err != nil {
+			return nil, err
+		}
+		return ollama, nil
+	default:
+		return nil, fmt.Errorf("unknown model type %s", provider)
+	}
+}
+
+// geminiAPIKey returns the Gemini API key, also accepting the GOOGLE_API_KEY used by Google's SDKs
+func geminiAPIKey() string {
+	if key := os.Getenv("GEMINI_API_KEY"); key != "" {
+		return key
+	}
+	return os.Getenv("GOOGLE_API_KEY")
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"llm-agent/pkg/models"
@@ -26,19 +28,12 @@ const (
 	colorGreen  = "\033[92m"       // Light green
 	colorYellow = "\033[93m"       // Light yellow for tool usage
 	colorOrange = "\033[38;5;208m" // Light orange for version and model info
+	colorDim    = "\033[2m"        // Dimmed text for model reasoning
 )
 
 // Spinner animation frames
 var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
 
-// Statistics tracks usage statistics for the agent
-type Statistics struct {
-	TotalInputTokens  int64
-	TotalOutputTokens int64
-	StartTime         time.Time
-	LastResponseTime  time.Duration
-}
-
 // Agent represents a chat agent that can interact with an LLM and use tools
 type Agent struct {
 	model         models.Model
@@ -46,17 +41,29 @@ type Agent struct {
 	tools         []tools.Tool
 	showStats     bool
 	stats         Statistics
-	storage       *storage.ChatStorage
+	statsMu       sync.Mutex // Guards stats, which PrintStats and ExportStats read while Run may be updating it
+	storage       storage.Store
 	workspaceRoot string
+	contextMgr    *ContextManager
+	pricing       models.PricingTable
+	budget        Budget
+	pendingParts  []models.ContentPart // Attachments to send with the next user message
+	codeSearcher  tools.CodeSearcher
+	toolStrategy  ToolStrategy
+	showReasoning bool                  // Stream reasoning in full instead of collapsing it
+	lastReasoning string                // Reasoning of the last turn, shown by /reasoning
+	sessionID     string                // Groups the stored messages of this run
+	resumed       []storage.ChatMessage // Stored messages of a resumed session
+	promptSaved   bool                  // Whether the system prompt of the session is stored
+	head          string                // ID of the last stored message of the current branch
+	switchTo      []storage.ChatMessage // Branch to continue from, set by /edit, /retry and /branches
+	switching     bool                  // Whether switchTo holds a branch to switch to
+	redaction     Redaction             // Where secrets are replaced with placeholders
+	unredacted    map[string]string     // Redacted tool results to their originals, for routes that see them
 }
 
 // NewAgent creates a new agent with the given model and tools
-func NewAgent(model models.Model, getUserInput func() (string, bool), tools []tools.Tool, showStats bool, storagePath string, workspaceRoot string) (*Agent, error) {
-	chatStorage, err := storage.NewChatStorage(storagePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize chat storage: %w", err)
-	}
-
+func NewAgent(model models.Model, getUserInput func() (string, bool), tools []tools.Tool, showStats bool, store storage.Store, workspaceRoot string) (*Agent, error) {
 	// Set tools for the model
 	if err := model.SetTools(tools); err != nil {
 		return nil, fmt.Errorf("failed to set tools: %w", err)
@@ -70,81 +77,97 @@ func NewAgent(model models.Model, getUserInput func() (string, bool), tools []to
 		stats: Statistics{
 			StartTime: time.Now(),
 		},
-		storage:       chatStorage,
+		storage:       store,
 		workspaceRoot: workspaceRoot,
+		contextMgr:    NewContextManager(model),
+		pricing:       models.DefaultPricing(),
+		sessionID:     uuid.New().String(),
 	}, nil
 }
 
+// SetPricing sets the pricing table used to compute the cost of each model call
+func (a *Agent) SetPricing(pricing models.PricingTable) {
+	a.pricing = pricing
+}
+
+// SetCodeSearcher enables the search_code tool backed by the given searcher
+func (a *Agent) SetCodeSearcher(searcher tools.CodeSearcher) {
+	a.codeSearcher = searcher
+}
+
+// SetBudget sets the limits that stop the agent loop when exceeded
+func (a *Agent) SetBudget(budget Budget) {
+	a.budget = budget
+}
+
+// SetToolStrategy sets how tools are offered to the model; the default picks the strategy
+// from the model's capabilities
+func (a *Agent) SetToolStrategy(strategy ToolStrategy) {
+	a.toolStrategy = strategy
+}
+
+// SetShowReasoning controls whether model reasoning is streamed in full or collapsed to a
+// one-line notice that /reasoning expands
+func (a *Agent) SetShowReasoning(show bool) {
+	a.showReasoning = show
+}
+
 // Run starts the agent's main loop
 func (a *Agent) Run(ctx context.Context) error {
 	// Print version and model information
-	fmt.Printf("%sLLM Agent v%s using model: %s%s\n\n",
+	fmt.Printf("%sLLM Agent v%s using model: %s%s\n",
 		colorOrange,
 		Version,
 		a.model.GetName(),
 		colorReset)
-
-	var messages []models.Message
-
-	// Add system message to describe available tools
-	toolDescriptions := make([]string, len(a.tools))
-	for i, tool := range a.tools {
-		toolDescriptions[i] = fmt.Sprintf("- %s: %s", tool.GetName(), tool.GetDescription())
-	}
-
-	var systemMessage string
-	baseModelType := strings.Split(a.model.GetName(), "-")[0]
-	if baseModelType == "ollama" {
-		systemMessage = fmt.Sprintf(`<system>
-You are a helpful AI assistant with access to the following tools:
-
-%s
-
-## Instructions for tool usage:
-1. When a user asks you to perform a task requiring these tools, analyze which tool is appropriate.
-2. To use a tool, format your response using JSON within <tool></tool> tags:
-   <tool>
-   {
-     "name": "tool_name",
-     "arguments": {
-       "param1": "value1",
-       "param2": "value2"
-     }
-   }
-   </tool>
-
-3. After each tool call, wait for the result which will appear in <result></result> tags.
-4. Explain your reasoning both before and after using tools.
-5. Present results clearly with appropriate formatting.
-
-Remember that you're running locally with limited resources, so be efficient with your reasoning.
-</system>`, strings.Join(toolDescriptions, "\n"))
-	} else {
-		systemMessage = fmt.Sprintf(`You are a helpful AI assistant with access to the following tools:
-
-%s
-
-When a user asks you to perform a task that can be done using these tools, you should use them. Always explain what you're doing and show the results of each tool usage. If a user asks a question that is not related to tool usage, answer the question as normal.`, strings.Join(toolDescriptions, "\n"))
-	}
-	messages = append(messages, models.Message{
-		Role:    "system",
-		Content: systemMessage,
-	})
+	fmt.Printf("%sSession: %s%s\n\n", colorOrange, a.sessionID, colorReset)
 
 	// Initialize tools
+	readFileTool := tools.NewReadFileTool()
+	readFileTool.SetAllowImages(a.model.SupportsVision())
 	a.tools = []tools.Tool{
-		tools.NewReadFileTool(),
+		readFileTool,
 		tools.NewListDirTool(a.workspaceRoot),
 		tools.NewSearchFileTool(),
 		tools.NewSummarizeFileTool(a.workspaceRoot),
 	}
+	if a.codeSearcher != nil {
+		a.tools = append(a.tools, tools.NewSearchCodeTool(a.codeSearcher))
+	}
 
-	// Set tools for the model
-	if err := a.model.SetTools(a.tools); err != nil {
+	// Offer tool definitions natively only when the model calls them that way
+	caps := a.model.Capabilities()
+	strategy, supported := resolveToolStrategy(a.toolStrategy, caps)
+	if !supported {
+		fmt.Printf("%sWarning: %s does not support the %s tool strategy, using %s%s\n", colorYellow, a.model.GetName(), a.toolStrategy, strategy, colorReset)
+	}
+	a.toolStrategy = strategy
+	var nativeTools []tools.Tool
+	if strategy == ToolStrategyNative {
+		nativeTools = a.tools
+	}
+	if err := a.model.SetTools(nativeTools); err != nil {
 		return fmt.Errorf("failed to set tools: %w", err)
 	}
 
+	// Add system message to describe available tools. Models without a system role get the
+	// instructions as the first user message.
+	systemRole := "system"
+	if !caps.SystemRole {
+		systemRole = "user"
+	}
+	messages := a.restoreHistory(models.Message{
+		Role:    systemRole,
+		Content: systemPrompt(strategy, a.tools),
+	})
+
 	for {
+		// Stop before the next turn if a budget limit has been reached
+		if reason := a.budget.exceeded(a.stats); reason != "" {
+			a.stopForBudget(reason)
+			return nil
+		}
+
 		// Get user input
 		fmt.Printf("%sYou: %s", colorBlue, colorReset)
 		input, ok := a.getUserInput()
@@ -156,42 +179,62 @@ When a user asks you to perform a task that can be done using these tools, you s
 			continue
 		}
 
-		// Generate a new conversation ID for this exchange
-		conversationID := uuid.New().String()
+		// Handle REPL commands
+		if strings.HasPrefix(input, "/") {
+			input = a.handleCommand(input)
+			if a.switching {
+				messages = a.branchHistory(messages[0], a.switchTo)
+				a.switchTo, a.switching = nil, false
+			}
+			if input == "" {
+				continue
+			}
+		}
 
 		// Add user message to history
 		userMsg := models.Message{
 			Role:    "user",
 			Content: input,
+			Parts:   a.pendingParts,
 		}
+		a.pendingParts = nil
 		messages = append(messages, userMsg)
 
-		// Save user message
-		if err := a.storage.SaveMessage(userMsg, a.model.GetName(), models.Usage{
-			InputTokens: int64(len(strings.Fields(input))),
-		}, conversationID); err != nil {
+		// The turn starts with the prompt, so it includes any compaction the prompt triggers
+		startTime := time.Now()
+		turn := TurnRecord{Start: startTime}
+		messages = a.compact(ctx, messages, &turn)
+
+		// Save the system prompt with the first message, so resuming restores it
+		a.saveSystemPrompt(messages[0])
+
+		// Save user message. Its tokens are counted in the usage of the response to it.
+		if err := a.save(storage.NewChatMessage(userMsg, a.model.GetName(), models.Usage{}, nil, a.sessionID)); err != nil {
 			fmt.Printf("Warning: failed to save user message: %v\n", err)
 		}
 
+		// A compaction summary counts toward the budget like any other model call
+		if reason := a.budget.exceeded(a.stats); reason != "" {
+			a.endTurn(turn, startTime)
+			a.stopForBudget(reason)
+			return nil
+		}
+
 		// Get model response
-		startTime := time.Now()
 		fmt.Printf("%sAssistant: %s", colorGreen, colorReset)
 
 		// Stream the response
-		var fullResponse string
-		err := a.model.StreamResponse(ctx, messages, func(chunk string) error {
-			// Color tool usage in yellow
-			if strings.Contains(chunk, "[Tool:") {
-				fmt.Printf("%s%s%s", colorYellow, chunk, colorReset)
-			} else {
-				fmt.Print(chunk)
-			}
-			fullResponse += chunk
-			return nil
-		})
+		var budgetReason string
+		resp, err := a.callModel(ctx, messages, &turn)
 		if err != nil {
 			return fmt.Errorf("error getting model response: %w", err)
 		}
+		fullResponse := resp.Content
+		turnReasoning := resp.Reasoning
+		reasoningBlocks := resp.ReasoningBlocks
+		turnUsage := resp.Usage
+		turnMetrics := resp.Metrics
+		turn.Model = resp.Model
 
 		// Check if the response contains tool usage
 		if strings.Contains(fullResponse, "<tool>") || strings.Contains(fullResponse, "[Tool:") || strings.Contains(fullResponse, "tool_calls") {
@@ -249,7 +292,15 @@ When a user asks you to perform a task that can be done using these tools, you s
 				}
 			}
 
+			// Ask models that can produce JSON to restate a malformed XML tool call
+			if toolName == "" && a.toolStrategy == ToolStrategyXML && strings.Contains(fullResponse, "<tool>") && caps.JSONMode {
+				toolName, toolInput = a.recoverToolCall(ctx, messages, fullResponse, &turn)
+			}
+
 			if toolName != "" {
+				budgetReason = a.budget.exceeded(a.stats)
+			}
+			if toolName != "" && budgetReason == "" {
 				for _, tool := range a.tools {
 					if tool.GetName() == toolName {
 
@@ -269,42 +320,95 @@ When a user asks you to perform a task that can be done using these tools, you s
 							}
 						}()
 
+						toolStart := time.Now()
 						result, err := tool.Execute(json.RawMessage(toolInput))
 						done <- true    // Stop the spinner
 						fmt.Print("\r") // Clear the spinner line
+						a.statsMu.Lock()
+						a.stats.ToolCalls++
+						a.statsMu.Unlock()
+						toolRecord := ToolCallRecord{
+							Name:     toolName,
+							Duration: time.Since(toolStart),
+						}
+						if err != nil {
+							toolRecord.Error = err.Error()
+						}
+						turn.ToolCalls = append(turn.ToolCalls, toolRecord)
+
+						// Store the call with the response that requested it, for auditing and resume
+						call := newToolCall(toolName, toolInput, toolRecord)
+						callMsg := storage.NewChatMessage(models.Message{
+							Role:      "assistant",
+							Content:   fullResponse,
+							Reasoning: turnReasoning,
+						}, turn.Model, models.Usage{}, nil, a.sessionID)
 
 						if err != nil {
+							callMsg.ToolCalls = []storage.ToolCall{call}
+							if saveErr := a.save(callMsg); saveErr != nil {
+								fmt.Printf("Warning: failed to save tool call: %v\n", saveErr)
+							}
 							return fmt.Errorf("error executing tool %s: %w", toolName, err)
 						}
 
+						// Attach images returned by the tool instead of sending the marker text
+						resultMsg := models.Message{Role: "user"}
+						if imagePath, ok := tools.ParseImageResult(result); ok {
+							image, err := models.NewImagePart(imagePath)
+							if err != nil {
+								return fmt.Errorf("error loading image %s: %w", imagePath, err)
+							}
+							result = fmt.Sprintf("Image %s is attached.", imagePath)
+							resultMsg.Parts = []models.ContentPart{image}
+						}
+						result = a.redactToolResult(toolName, result)
+						resultMsg.Content = fmt.Sprintf("<result>%s</result>", result)
+
 						// Print tool result in yellow
 						fmt.Printf("%s<result>%s</result>%s\n", colorYellow, result, colorReset)
 
-						// Add tool result to messages
-						messages = append(messages, models.Message{
-							Role:    "assistant",
-							Content: fullResponse,
-						})
+						call.Result = result
+						callMsg.ToolCalls = []storage.ToolCall{call}
+						resultRecord := storage.NewChatMessage(resultMsg, a.model.GetName(), models.Usage{}, nil, a.sessionID)
+						resultRecord.ToolCallID = call.ID
+						for _, record := range []storage.ChatMessage{callMsg, resultRecord} {
+							if err := a.save(record); err != nil {
+								fmt.Printf("Warning: failed to save tool call: %v\n", err)
+							}
+						}
+
+						// Add tool result to messages, keeping the thinking blocks the provider
+						// needs to continue from the tool call
 						messages = append(messages, models.Message{
-							Role:    "user",
-							Content: fmt.Sprintf("<result>%s</result>", result),
+							Role:            "assistant",
+							Content:         fullResponse,
+							ReasoningBlocks: reasoningBlocks,
 						})
+						messages = append(messages, resultMsg)
+
+						messages = a.compact(ctx, messages, &turn)
+
+						if budgetReason = a.budget.exceeded(a.stats); budgetReason != "" {
+							break
+						}
 
 						// Get model's response to the tool result
 						fmt.Printf("%sAssistant: %s", colorGreen, colorReset)
-						err = a.model.StreamResponse(ctx, messages, func(chunk string) error {
-							// Color tool usage in yellow
-							if strings.Contains(chunk, "<tool>") || strings.Contains(chunk, "<result>") || strings.Contains(chunk, "[Tool:") || strings.Contains(chunk, "tool_calls") {
-								fmt.Printf("%s%s%s", colorYellow, chunk, colorReset)
-							} else {
-								fmt.Print(chunk)
-							}
-							fullResponse += chunk
-							return nil
-						})
+						followUp, err := a.callModel(ctx, messages, &turn)
 						if err != nil {
 							return fmt.Errorf("error getting model response to tool result: %w", err)
 						}
+						fullResponse += followUp.Content
+						turnReasoning = joinReasoning(turnReasoning, followUp.Reasoning)
+						reasoningBlocks = followUp.ReasoningBlocks
+						turn.Model = followUp.Model
+						turnUsage.InputTokens += followUp.Usage.InputTokens
+						turnUsage.OutputTokens += followUp.Usage.OutputTokens
+						turnUsage.CacheCreationInputTokens += followUp.Usage.CacheCreationInputTokens
+						turnUsage.CacheReadInputTokens += followUp.Usage.CacheReadInputTokens
+						turnUsage.Cost += followUp.Usage.Cost
+						turnMetrics = mergeMetrics(turnMetrics, followUp.Metrics, turnUsage.OutputTokens)
 						break
 					}
 				}
@@ -312,52 +416,274 @@ When a user asks you to perform a task that can be done using these tools, you s
 		}
 
 		// Update statistics
-		a.stats.LastResponseTime = time.Since(startTime)
+		a.endTurn(turn, startTime)
 		if a.showStats {
-			// Estimate tokens for streaming response
-			inputTokens := float64(len(strings.Fields(input))) * 1.3 // Rough estimate
-			outputTokens := float64(len(strings.Fields(fullResponse))) * 1.3
-			a.stats.TotalInputTokens += int64(inputTokens)
-			a.stats.TotalOutputTokens += int64(outputTokens)
-
-			fmt.Printf("\n\n%s[Stats] Response time: %v, Input tokens: %d, Output tokens: %d%s\n",
+			fmt.Printf("\n\n%s[Stats] Response time: %v, Time to first token: %v, Tokens/s: %.1f, Input tokens: %d, Output tokens: %d, Cost: $%.4f (session: $%.4f)%s\n",
 				colorYellow,
 				a.stats.LastResponseTime.Round(time.Millisecond),
-				int64(inputTokens),
-				int64(outputTokens),
+				turnMetrics.TimeToFirstToken.Round(time.Millisecond),
+				turnMetrics.TokensPerSecond,
+				turnUsage.InputTokens,
+				turnUsage.OutputTokens,
+				turnUsage.Cost,
+				a.stats.TotalCost,
 				colorReset)
+			if turn.Model != a.model.GetName() {
+				fmt.Printf("%s[Stats] Served by: %s%s\n", colorYellow, turn.Model, colorReset)
+			}
+			if turnUsage.CacheCreationInputTokens > 0 || turnUsage.CacheReadInputTokens > 0 {
+				fmt.Printf("%s[Stats] Prompt cache: %d tokens written, %d tokens read%s\n",
+					colorYellow,
+					turnUsage.CacheCreationInputTokens,
+					turnUsage.CacheReadInputTokens,
+					colorReset)
+			}
+			if turnMetrics.LoadDuration > 0 || turnMetrics.EvalDuration > 0 {
+				fmt.Printf("%s[Stats] Load: %v, Prompt eval: %v, Eval: %v%s\n",
+					colorYellow,
+					turnMetrics.LoadDuration.Round(time.Millisecond),
+					turnMetrics.PromptEvalDuration.Round(time.Millisecond),
+					turnMetrics.EvalDuration.Round(time.Millisecond),
+					colorReset)
+			}
 		}
 
 		// Add assistant response to history
+		a.lastReasoning = turnReasoning
 		assistantMsg := models.Message{
-			Role:    "assistant",
-			Content: fullResponse,
+			Role:            "assistant",
+			Content:         fullResponse,
+			Reasoning:       turnReasoning,
+			ReasoningBlocks: reasoningBlocks,
 		}
 		messages = append(messages, assistantMsg)
 
-		// Save assistant message with the same conversation ID
-		if err := a.storage.SaveMessage(assistantMsg, a.model.GetName(), models.Usage{
-			OutputTokens: int64(len(strings.Fields(fullResponse))),
-		}, conversationID); err != nil {
+		// Save assistant message with the session ID
+		if err := a.save(storage.NewChatMessage(assistantMsg, turn.Model, turnUsage, &turnMetrics, a.sessionID)); err != nil {
 			fmt.Printf("Warning: failed to save assistant message: %v\n", err)
 		}
 
+		if budgetReason != "" {
+			a.stopForBudget(budgetReason)
+			return nil
+		}
+
 		// Add a newline before the next user input
 		fmt.Println()
 	}
 }
 
+// callModel streams a model response to the terminal and records the call in the turn statistics
+func (a *Agent) callModel(ctx context.Context, messages []models.Message, turn *TurnRecord) (*models.Response, error) {
+	if a.toolStrategy == ToolStrategyJSON {
+		return a.callModelJSON(ctx, messages, turn)
+	}
+
+	start := time.Now()
+	var firstToken time.Duration
+	reasoning := reasoningView{expanded: a.showReasoning}
+	resp, err := a.model.StreamResponse(ctx, messages, func(chunk models.Chunk) error {
+		if firstToken == 0 {
+			firstToken = time.Since(start)
+		}
+		if chunk.Reasoning != "" {
+			reasoning.write(chunk.Reasoning)
+			return nil
+		}
+		reasoning.close()
+
+		// Color tool usage in yellow
+		text := chunk.Content
+		if strings.Contains(text, "<tool>") || strings.Contains(text, "<result>") || strings.Contains(text, "[Tool:") || strings.Contains(text, "tool_calls") {
+			fmt.Printf("%s%s%s", colorYellow, text, colorReset)
+		} else {
+			fmt.Print(text)
+		}
+		return nil
+	})
+	reasoning.close()
+	if err != nil {
+		return nil, err
+	}
+
+	a.recordModelCall(resp, start, firstToken, turn)
+	return resp, nil
+}
+
+// recordModelCall prices a model response and adds it to the session and turn statistics,
+// together with the routing classifier call made for it, so budgets see every call
+func (a *Agent) recordModelCall(resp *models.Response, start time.Time, firstToken time.Duration, turn *TurnRecord) {
+	if classifier := resp.Classifier; classifier != nil {
+		a.recordModelCall(classifier, time.Now().Add(-classifier.Metrics.TotalDuration), classifier.Metrics.TimeToFirstToken, turn)
+	}
+
+	// Prefer the time to first token measured by the backend, it excludes local overhead
+	if resp.Metrics.TimeToFirstToken > 0 {
+		firstToken = resp.Metrics.TimeToFirstToken
+	}
+
+	// Routed responses name the model that served them
+	if resp.Model == "" {
+		resp.Model = a.model.GetName()
+	}
+	resp.Usage.Cost = a.pricing.Cost(resp.Model, resp.Usage)
+	a.statsMu.Lock()
+	defer a.statsMu.Unlock()
+	a.stats.TotalInputTokens += resp.Usage.InputTokens
+	a.stats.TotalOutputTokens += resp.Usage.OutputTokens
+	a.stats.TotalCacheWrite += resp.Usage.CacheCreationInputTokens
+	a.stats.TotalCacheRead += resp.Usage.CacheReadInputTokens
+	a.stats.TotalCost += resp.Usage.Cost
+	turn.ModelCalls = append(turn.ModelCalls, ModelCallRecord{
+		Model:            resp.Model,
+		Latency:          time.Since(start),
+		TimeToFirstToken: firstToken,
+		InputTokens:      resp.Usage.InputTokens,
+		OutputTokens:     resp.Usage.OutputTokens,
+		CacheWriteTokens: resp.Usage.CacheCreationInputTokens,
+		CacheReadTokens:  resp.Usage.CacheReadInputTokens,
+		Cost:             resp.Usage.Cost,
+		Metrics:          resp.Metrics,
+	})
+}
+
+// endTurn adds a finished turn to the session statistics
+func (a *Agent) endTurn(turn TurnRecord, start time.Time) {
+	a.statsMu.Lock()
+	defer a.statsMu.Unlock()
+	a.stats.LastResponseTime = time.Since(start)
+	turn.Duration = a.stats.LastResponseTime
+	a.stats.Turns = append(a.stats.Turns, turn)
+}
+
+// joinReasoning appends the reasoning of a follow-up model call to that of the turn
+func joinReasoning(turn, next string) string {
+	if turn == "" || next == "" {
+		return turn + next
+	}
+	return turn + "\n\n" + next
+}
+
+// mergeMetrics combines the metrics of consecutive model calls within a turn. The time to
+// first token of the turn is that of the first call, durations add up and the throughput is
+// recomputed from the total output tokens of the turn.
+func mergeMetrics(first, next models.Metrics, outputTokens int64) models.Metrics {
+	merged := first
+	merged.TotalDuration += next.TotalDuration
+	merged.LoadDuration += next.LoadDuration
+	merged.PromptEvalDuration += next.PromptEvalDuration
+	merged.EvalDuration += next.EvalDuration
+
+	generation := merged.EvalDuration
+	if generation == 0 {
+		generation = merged.TotalDuration - first.TimeToFirstToken - next.TimeToFirstToken
+	}
+	if generation > 0 {
+		merged.TokensPerSecond = float64(outputTokens) / generation.Seconds()
+	}
+	return merged
+}
+
+// stopForBudget reports that a budget limit was reached and summarizes the session
+func (a *Agent) stopForBudget(reason string) {
+	fmt.Printf("\n%s[Budget] Stopping: the %s has been reached.%s\n", colorYellow, reason, colorReset)
+	fmt.Printf("%s[Budget] Session summary: %d input tokens, %d output tokens, %d tool calls, $%.4f, %v elapsed%s\n",
+		colorYellow,
+		a.stats.TotalInputTokens,
+		a.stats.TotalOutputTokens,
+		a.stats.ToolCalls,
+		a.stats.TotalCost,
+		time.Since(a.stats.StartTime).Round(time.Second),
+		colorReset)
+}
+
+// compact shrinks the history when it approaches the model's context window,
+// reporting the compaction to the user and recording it in storage and the turn statistics
+func (a *Agent) compact(ctx context.Context, messages []models.Message, turn *TurnRecord) []models.Message {
+	start := time.Now()
+	compacted, event, err := a.contextMgr.Compact(ctx, messages)
+	if err != nil {
+		fmt.Printf("%sWarning: failed to compact conversation history: %v%s\n", colorYellow, err, colorReset)
+		return messages
+	}
+	if event == nil {
+		return messages
+	}
+	model, usage := a.model.GetName(), models.Usage{}
+	if event.Response != nil {
+		a.recordModelCall(event.Response, start, time.Since(start), turn)
+		model, usage = event.Response.Model, event.Response.Usage
+	}
+
+	fmt.Printf("%s[Context] Compacted history from ~%d to ~%d tokens (%d tool results truncated, %d messages summarized)%s\n",
+		colorYellow,
+		event.BeforeTokens,
+		event.AfterTokens,
+		event.TruncatedResults,
+		event.SummarizedMessages,
+		colorReset)
+
+	record := fmt.Sprintf(storage.CompactionRecordPrefix+" ~%d -> ~%d tokens, %d tool results truncated, %d messages summarized]",
+		event.BeforeTokens, event.AfterTokens, event.TruncatedResults, event.SummarizedMessages)
+	if event.Summary != "" {
+		record += "\n" + event.Summary
+	}
+	if err := a.save(storage.NewChatMessage(models.Message{
+		Role:    "system",
+		Content: record,
+	}, model, usage, nil, a.sessionID)); err != nil {
+		fmt.Printf("Warning: failed to save compaction event: %v\n", err)
+	}
+
+	return compacted
+}
+
 // PrintStats prints the agent's statistics
 func (a *Agent) PrintStats() {
 	if !a.showStats {
 		return
 	}
+	a.statsMu.Lock()
+	defer a.statsMu.Unlock()
 
-	totalTime := time.Since(a.stats.StartTime)
+	summary := a.stats.Summary()
 	fmt.Printf("\n=== Statistics ===\n")
-	fmt.Printf("Total runtime: %v\n", totalTime.Round(time.Second))
-	fmt.Printf("Total input tokens: %d\n", a.stats.TotalInputTokens)
-	fmt.Printf("Total output tokens: %d\n", a.stats.TotalOutputTokens)
-	fmt.Printf("Average response time: %v\n", a.stats.LastResponseTime.Round(time.Millisecond))
+	fmt.Printf("Total runtime: %v\n", summary.Runtime.Round(time.Second))
+	fmt.Printf("Turns: %d\n", summary.Turns)
+	fmt.Printf("Total input tokens: %d\n", summary.TotalInputTokens)
+	fmt.Printf("Total output tokens: %d\n", summary.TotalOutputTokens)
+	if summary.TotalCacheWrite > 0 || summary.TotalCacheRead > 0 {
+		fmt.Printf("Prompt cache: %d tokens written, %d tokens read\n", summary.TotalCacheWrite, summary.TotalCacheRead)
+	}
+	fmt.Printf("Total tool calls: %d\n", a.stats.ToolCalls)
+	fmt.Printf("Session cost: $%.4f\n", summary.TotalCost)
+	if total, err := a.storage.TotalCost(); err == nil {
+		fmt.Printf("Cumulative cost (all sessions): $%.4f\n", total)
+	}
+	fmt.Printf("Turn duration: %s\n", summary.TurnDuration)
+	fmt.Printf("Model latency: %s\n", summary.ModelLatency)
+	fmt.Printf("Time to first token: %s\n", summary.TimeToFirstToken)
+	fmt.Printf("Average throughput: %.1f tokens/s\n", summary.TokensPerSecond)
+
+	modelNames := make([]string, 0, len(summary.Models))
+	for name := range summary.Models {
+		modelNames = append(modelNames, name)
+	}
+	sort.Strings(modelNames)
+	for _, name := range modelNames {
+		model := summary.Models[name]
+		fmt.Printf("Model %s: %d calls, %d input tokens, %d output tokens, %d cache write, %d cache read, $%.4f\n",
+			name, model.Calls, model.InputTokens, model.OutputTokens, model.CacheWriteTokens, model.CacheReadTokens, model.Cost)
+	}
+
+	toolNames := make([]string, 0, len(summary.Tools))
+	for name := range summary.Tools {
+		toolNames = append(toolNames, name)
+	}
+	sort.Strings(toolNames)
+	for _, name := range toolNames {
+		tool := summary.Tools[name]
+		fmt.Printf("Tool %s: %d calls, %d errors, %s\n", name, tool.Calls, tool.Errors, tool.Duration)
+	}
 	fmt.Printf("==================\n")
 }
@@ -0,0 +1,178 @@
+package agent
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"llm-agent/pkg/storage"
+)
+
+// currentBranch loads the stored messages of the session and the branch the agent is on
+func (a *Agent) currentBranch() ([]storage.ChatMessage, []storage.ChatMessage, error) {
+	stored, err := a.storage.LoadConversation(a.sessionID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load session: %w", err)
+	}
+	return stored, storage.Branch(stored, a.head), nil
+}
+
+// switchBranch makes Run continue from the end of a stored branch. Messages stored from now
+// on follow the last message of the branch, so the messages after it are kept on their own
+// branch.
+func (a *Agent) switchBranch(branch []storage.ChatMessage) {
+	a.switchTo, a.switching = branch, true
+	a.head = ""
+	if len(branch) > 0 {
+		a.head = branch[len(branch)-1].ID
+	} else {
+		a.promptSaved = false // The new branch starts before the stored system prompt
+	}
+}
+
+// branchBefore switches to the branch ending just before a prompt, so the prompt can be sent
+// again in a new branch with the attachments it had
+func (a *Agent) branchBefore(branch []storage.ChatMessage, prompt storage.ChatMessage) {
+	for i, msg := range branch {
+		if msg.ID == prompt.ID {
+			a.switchBranch(branch[:i])
+			break
+		}
+	}
+	a.pendingParts = attachmentParts(prompt.Attachments)
+}
+
+// branchPrompts returns the prompts typed by the user on a branch
+func branchPrompts(branch []storage.ChatMessage) []storage.ChatMessage {
+	var prompts []storage.ChatMessage
+	for _, msg := range branch {
+		if storage.IsPrompt(msg) {
+			prompts = append(prompts, msg)
+		}
+	}
+	return prompts
+}
+
+// commandEdit replaces an earlier prompt and continues from it in a new branch, keeping the
+// original branch: /edit [N [message]]
+func (a *Agent) commandEdit(args string) string {
+	_, branch, err := a.currentBranch()
+	if err != nil {
+		fmt.Printf("%sError: %v%s\n", colorYellow, err, colorReset)
+		return ""
+	}
+	prompts := branchPrompts(branch)
+	if len(prompts) == 0 {
+		fmt.Printf("%sThere are no messages to edit yet%s\n", colorYellow, colorReset)
+		return ""
+	}
+
+	if args == "" {
+		for i, prompt := range prompts {
+			fmt.Printf("%s%3d. %s%s\n", colorYellow, i+1, truncateLine(prompt.Content, 70), colorReset)
+		}
+		fmt.Printf("%sUsage: /edit N [message]%s\n", colorYellow, colorReset)
+		return ""
+	}
+
+	number, text, _ := strings.Cut(args, " ")
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 1 || n > len(prompts) {
+		fmt.Printf("%sMessage number must be between 1 and %d%s\n", colorYellow, len(prompts), colorReset)
+		return ""
+	}
+	prompt := prompts[n-1]
+
+	text = strings.TrimSpace(text)
+	if text == "" {
+		fmt.Printf("%sMessage %d: %s%s\n", colorYellow, n, prompt.Content, colorReset)
+		fmt.Printf("%sNew message: %s", colorBlue, colorReset)
+		input, ok := a.getUserInput()
+		if !ok || strings.TrimSpace(input) == "" {
+			fmt.Printf("%sEdit cancelled%s\n", colorYellow, colorReset)
+			return ""
+		}
+		text = input
+	}
+
+	a.branchBefore(branch, prompt)
+	fmt.Printf("%sEditing message %d in a new branch; /branches lists the original%s\n", colorYellow, n, colorReset)
+	return text
+}
+
+// commandRetry sends the last prompt again in a new branch, keeping the previous answer on
+// its own branch: /retry
+func (a *Agent) commandRetry() string {
+	_, branch, err := a.currentBranch()
+	if err != nil {
+		fmt.Printf("%sError: %v%s\n", colorYellow, err, colorReset)
+		return ""
+	}
+	prompts := branchPrompts(branch)
+	if len(prompts) == 0 {
+		fmt.Printf("%sThere is no message to retry yet%s\n", colorYellow, colorReset)
+		return ""
+	}
+
+	prompt := prompts[len(prompts)-1]
+	a.branchBefore(branch, prompt)
+	fmt.Printf("%sRetrying: %s%s\n", colorYellow, truncateLine(prompt.Content, 70), colorReset)
+	return prompt.Content
+}
+
+// commandBranches lists the branches of the session or switches to one: /branches [id]
+func (a *Agent) commandBranches(args string) {
+	stored, err := a.storage.LoadConversation(a.sessionID)
+	if err != nil {
+		fmt.Printf("%sError: failed to load session: %v%s\n", colorYellow, err, colorReset)
+		return
+	}
+	if len(stored) == 0 {
+		fmt.Printf("%sThe session has no stored messages yet%s\n", colorYellow, colorReset)
+		return
+	}
+
+	if args != "" {
+		branch, err := storage.FindBranch(stored, args)
+		if err != nil {
+			fmt.Printf("%sError: %v%s\n", colorYellow, err, colorReset)
+			return
+		}
+		a.switchBranch(branch)
+		fmt.Printf("%sSwitched to branch %s with %d messages%s\n", colorYellow, shortID(a.head), len(branch), colorReset)
+		return
+	}
+
+	for _, branch := range storage.Branches(stored) {
+		marker := " "
+		if branch.Leaf.ID == a.head {
+			marker = "*"
+		}
+		fmt.Printf("%s%s %s  %s  %3d messages  %s%s\n",
+			colorYellow,
+			marker,
+			shortID(branch.Leaf.ID),
+			branch.UpdatedAt.Local().Format("2006-01-02 15:04"),
+			branch.Messages,
+			truncateLine(branch.LastPrompt, 50),
+			colorReset)
+	}
+	fmt.Printf("%sSwitch with /branches ID; -resume with -branch ID continues a branch later%s\n", colorYellow, colorReset)
+}
+
+// shortID returns the first characters of a message ID, enough to tell branches apart
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+// truncateLine joins the lines of a text and shortens it to at most n runes
+func truncateLine(text string, n int) string {
+	runes := []rune(strings.Join(strings.Fields(text), " "))
+	if len(runes) <= n {
+		return string(runes)
+	}
+	return string(runes[:n-1]) + "…"
+}
@@ -0,0 +1,32 @@
+package agent
+
+import (
+	"fmt"
+	"time"
+)
+
+// Budget holds hard limits for a session. Zero values mean no limit.
+type Budget struct {
+	MaxTokens    int64         // Total input and output tokens
+	MaxCost      float64       // Total cost in US dollars
+	MaxDuration  time.Duration // Wall-clock time since the agent started
+	MaxToolCalls int           // Number of tool executions
+}
+
+// exceeded returns a description of the first limit the statistics have reached,
+// or an empty string when the session is still within budget
+func (b Budget) exceeded(stats Statistics) string {
+	if b.MaxTokens > 0 && stats.TotalInputTokens+stats.TotalOutputTokens >= b.MaxTokens {
+		return fmt.Sprintf("token budget of %d tokens", b.MaxTokens)
+	}
+	if b.MaxCost > 0 && stats.TotalCost >= b.MaxCost {
+		return fmt.Sprintf("cost budget of $%.4f", b.MaxCost)
+	}
+	if b.MaxDuration > 0 && time.Since(stats.StartTime) >= b.MaxDuration {
+		return fmt.Sprintf("time budget of %v", b.MaxDuration)
+	}
+	if b.MaxToolCalls > 0 && stats.ToolCalls >= b.MaxToolCalls {
+		return fmt.Sprintf("tool call budget of %d calls", b.MaxToolCalls)
+	}
+	return ""
+}
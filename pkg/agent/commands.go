@@ -0,0 +1,151 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+
+	"llm-agent/pkg/models"
+	"llm-agent/pkg/storage"
+)
+
+// handleCommand processes a REPL command starting with a slash. It returns the prompt to send
+// to the model, or an empty string when the command was handled entirely locally.
+func (a *Agent) handleCommand(input string) string {
+	name, args, _ := strings.Cut(strings.TrimPrefix(input, "/"), " ")
+	args = strings.TrimSpace(args)
+
+	switch name {
+	case "image":
+		return a.commandImage(args)
+	case "reasoning":
+		a.commandReasoning()
+		return ""
+	case "model":
+		a.commandModel(args)
+		return ""
+	case "search":
+		a.commandSearch(args)
+		return ""
+	case "edit":
+		return a.commandEdit(args)
+	case "retry":
+		return a.commandRetry()
+	case "branches":
+		a.commandBranches(args)
+		return ""
+	default:
+		fmt.Printf("%sUnknown command /%s%s\n", colorYellow, name, colorReset)
+		return ""
+	}
+}
+
+// commandImage attaches an image to the next message: /image path [prompt]
+func (a *Agent) commandImage(args string) string {
+	path, prompt, _ := strings.Cut(args, " ")
+	if path == "" {
+		fmt.Printf("%sUsage: /image path [prompt]%s\n", colorYellow, colorReset)
+		return ""
+	}
+	if !a.model.SupportsVision() {
+		fmt.Printf("%sModel %s does not support image input%s\n", colorYellow, a.model.GetName(), colorReset)
+		return ""
+	}
+
+	image, err := models.NewImagePart(path)
+	if err != nil {
+		fmt.Printf("%sError: %v%s\n", colorYellow, err, colorReset)
+		return ""
+	}
+	a.pendingParts = append(a.pendingParts, image)
+
+	prompt = strings.TrimSpace(prompt)
+	if prompt == "" {
+		fmt.Printf("%sAttached %s, it will be sent with your next message%s\n", colorYellow, path, colorReset)
+	}
+	return prompt
+}
+
+// commandReasoning prints the full reasoning of the last turn: /reasoning
+func (a *Agent) commandReasoning() {
+	if a.lastReasoning == "" {
+		fmt.Printf("%sThe last response has no reasoning%s\n", colorYellow, colorReset)
+		return
+	}
+	fmt.Printf("%s%s%s\n", colorDim, a.lastReasoning, colorReset)
+}
+
+// commandModel shows the routes of a router model or forces one: /model [name|auto]
+func (a *Agent) commandModel(args string) {
+	router, ok := a.model.(*models.RouterModel)
+	if !ok {
+		fmt.Printf("%sUsing %s; /model needs -model router%s\n", colorYellow, a.model.GetName(), colorReset)
+		return
+	}
+
+	if args == "" {
+		current := router.Override()
+		if current == "" {
+			current = "auto"
+		}
+		fmt.Printf("%sRoute: %s%s\n", colorYellow, current, colorReset)
+		for _, route := range router.Routes() {
+			fmt.Printf("%s  %s (%s) %s%s\n", colorYellow, route.Name, route.Model.GetName(), route.Description, colorReset)
+		}
+		return
+	}
+
+	if args == "auto" {
+		args = ""
+	}
+	if err := router.SetOverride(args); err != nil {
+		fmt.Printf("%sError: %v%s\n", colorYellow, err, colorReset)
+		return
+	}
+	if args == "" {
+		fmt.Printf("%sRouting automatically%s\n", colorYellow, colorReset)
+	} else {
+		fmt.Printf("%sUsing %s for every request%s\n", colorYellow, args, colorReset)
+	}
+}
+
+// searchResultLimit is the number of results /search shows
+const searchResultLimit = 10
+
+// commandSearch searches the chat history of all sessions: /search [role:name] [model:name] query
+func (a *Agent) commandSearch(args string) {
+	query := storage.SearchQuery{Limit: searchResultLimit}
+	var words []string
+	for _, word := range strings.Fields(args) {
+		if role, ok := strings.CutPrefix(word, "role:"); ok {
+			query.Roles = append(query.Roles, role)
+		} else if model, ok := strings.CutPrefix(word, "model:"); ok {
+			query.Model = model
+		} else {
+			words = append(words, word)
+		}
+	}
+	query.Text = strings.Join(words, " ")
+	if query.Text == "" {
+		fmt.Printf("%sUsage: /search [role:name] [model:name] query%s\n", colorYellow, colorReset)
+		return
+	}
+
+	results, err := a.storage.Search(query)
+	if err != nil {
+		fmt.Printf("%sError: %v%s\n", colorYellow, err, colorReset)
+		return
+	}
+	if len(results) == 0 {
+		fmt.Printf("%sNo messages found%s\n", colorYellow, colorReset)
+		return
+	}
+	for _, result := range results {
+		msg := result.Message
+		session := msg.ConversationID
+		if session == a.sessionID {
+			session = "this session"
+		}
+		fmt.Printf("%s%s · %s · %s%s\n", colorOrange, msg.Timestamp.Local().Format("2006-01-02 15:04"), msg.Role, session, colorReset)
+		fmt.Printf("  %s\n", result.Highlight(colorYellow, colorReset))
+	}
+}
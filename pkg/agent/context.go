@@ -0,0 +1,176 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"llm-agent/pkg/models"
+)
+
+// Defaults for context compaction
+const (
+	defaultCompactThreshold = 0.8  // Fraction of the context window that triggers compaction
+	defaultKeepRecent       = 6    // Number of most recent messages that are never compacted
+	defaultMaxToolResult    = 2000 // Characters kept from old tool results
+	summaryPrefix           = "Summary of the earlier conversation:\n"
+	summaryAcknowledgement  = "Understood, I will continue from this summary."
+)
+
+// CompactionEvent describes a single compaction of the conversation history
+type CompactionEvent struct {
+	BeforeTokens       int
+	AfterTokens        int
+	TruncatedResults   int
+	SummarizedMessages int
+	Summary            string
+	// Response of the summary model call, whose usage counts toward the session like any
+	// other call; nil when only tool results were truncated
+	Response *models.Response
+}
+
+// ContextManager keeps the conversation history within the model's context window.
+// When the history approaches the limit it first truncates old tool results and then
+// summarizes older turns into a compact recap, always keeping the system prompt and
+// the most recent messages intact.
+type ContextManager struct {
+	model         models.Model
+	window        int
+	threshold     float64
+	keepRecent    int
+	maxToolResult int
+}
+
+// NewContextManager creates a context manager for the given model
+func NewContextManager(model models.Model) *ContextManager {
+	return &ContextManager{
+		model:         model,
+		window:        model.GetContextWindow(),
+		threshold:     defaultCompactThreshold,
+		keepRecent:    defaultKeepRecent,
+		maxToolResult: defaultMaxToolResult,
+	}
+}
+
+// limit returns the token budget available to the history, leaving room for the response
+func (c *ContextManager) limit() int {
+	available := c.window - c.model.GetMaxTokens()
+	if available <= 0 {
+		available = c.window
+	}
+	return int(float64(available) * c.threshold)
+}
+
+// Compact returns a history that fits the context window. The returned event is nil
+// when no compaction was necessary.
+func (c *ContextManager) Compact(ctx context.Context, messages []models.Message) ([]models.Message, *CompactionEvent, error) {
+	before := models.EstimateTokens(messages)
+	if c.window <= 0 || before <= c.limit() {
+		return messages, nil, nil
+	}
+
+	event := &CompactionEvent{BeforeTokens: before}
+	start := 0
+	if len(messages) > 0 && messages[0].Role == "system" {
+		start = 1
+	}
+	end := c.recentStart(messages, start)
+
+	// Drop the bulk of old tool results first, they are the cheapest to lose
+	compacted := make([]models.Message, len(messages))
+	copy(compacted, messages)
+	for i := start; i < end; i++ {
+		if truncated, ok := c.truncateToolResult(compacted[i].Content); ok {
+			compacted[i].Content = truncated
+			event.TruncatedResults++
+		}
+	}
+	if models.EstimateTokens(compacted) <= c.limit() || end <= start {
+		event.AfterTokens = models.EstimateTokens(compacted)
+		return compacted, event, nil
+	}
+
+	// Summarize everything between the system prompt and the recent messages
+	resp, err := c.summarize(ctx, compacted[start:end])
+	if err != nil {
+		return messages, nil, fmt.Errorf("failed to summarize history: %w", err)
+	}
+	summary := strings.TrimSpace(resp.Content)
+	event.Summary = summary
+	event.SummarizedMessages = end - start
+	event.Response = resp
+
+	// The recent window starts with a user prompt, so the summary is followed by an assistant
+	// acknowledgement to keep user and assistant turns alternating. The prompt itself is left
+	// untouched so the router recognizes it and does not classify it again.
+	result := make([]models.Message, 0, start+2+len(compacted)-end)
+	result = append(result, compacted[:start]...)
+	result = append(result,
+		models.Message{Role: "user", Content: summaryPrefix + summary},
+		models.Message{Role: "assistant", Content: summaryAcknowledgement},
+	)
+	result = append(result, compacted[end:]...)
+	event.AfterTokens = models.EstimateTokens(result)
+	return result, event, nil
+}
+
+// recentStart returns the index of the first message that must be kept verbatim.
+// The boundary is moved back so the recent window starts with a real user prompt
+// rather than an assistant reply or a dangling tool result.
+func (c *ContextManager) recentStart(messages []models.Message, start int) int {
+	end := len(messages) - c.keepRecent
+	if end < start {
+		return start
+	}
+	for end > start && !models.IsPrompt(messages[end]) {
+		end--
+	}
+	return end
+}
+
+// truncateToolResult shortens a tool result message, reporting whether it was changed
+func (c *ContextManager) truncateToolResult(content string) (string, bool) {
+	if !strings.HasPrefix(content, models.ToolResultPrefix) || len(content) <= c.maxToolResult {
+		return content, false
+	}
+	// Cut at a rune boundary so multi-byte characters are not split
+	cut := c.maxToolResult
+	for cut > 0 && !utf8.RuneStart(content[cut]) {
+		cut--
+	}
+	omitted := len(content) - cut
+	return fmt.Sprintf("%s\n[... %d characters of tool output omitted ...]</result>", content[:cut], omitted), true
+}
+
+// summarize asks the model for a compact recap of the given messages
+func (c *ContextManager) summarize(ctx context.Context, messages []models.Message) (*models.Response, error) {
+	var transcript strings.Builder
+	for _, msg := range messages {
+		transcript.WriteString(fmt.Sprintf("%s: %s\n\n", msg.Role, msg.Content))
+	}
+
+	// A router would spend a classifier call on the summary request, so it goes straight to
+	// the model of the current route
+	model := c.model
+	if router, ok := model.(*models.RouterModel); ok {
+		model = router.FixedModel()
+	}
+	resp, err := model.GenerateResponse(ctx, []models.Message{
+		{
+			Role:    "system",
+			Content: "You compress conversations between a user and an AI assistant that uses tools. Write a concise recap that preserves the user's goals, decisions made, file paths, tool findings and any open tasks. Do not add commentary.",
+		},
+		{
+			Role:    "user",
+			Content: fmt.Sprintf("Summarize the following conversation:\n\n%s", transcript.String()),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Model == "" {
+		resp.Model = model.GetName()
+	}
+	return resp, nil
+}
@@ -0,0 +1,44 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+)
+
+// reasoningView renders streamed model reasoning in dimmed text. Collapsed, it shows a single
+// line with the size of the reasoning; expanded, it shows the reasoning as it streams.
+type reasoningView struct {
+	expanded bool
+	open     bool
+	text     strings.Builder
+}
+
+// write renders a reasoning chunk
+func (v *reasoningView) write(chunk string) {
+	if !v.open {
+		v.open = true
+		if v.expanded {
+			fmt.Printf("\n%s", colorDim)
+		} else {
+			fmt.Printf("%s[Thinking...", colorDim)
+		}
+	}
+	v.text.WriteString(chunk)
+	if v.expanded {
+		fmt.Print(chunk)
+	}
+}
+
+// close ends the reasoning section once the answer starts or the response ends
+func (v *reasoningView) close() {
+	if !v.open {
+		return
+	}
+	v.open = false
+	if v.expanded {
+		fmt.Printf("%s\n\n", colorReset)
+	} else {
+		fmt.Printf(" %d words, /reasoning to expand]%s\n", len(strings.Fields(v.text.String())), colorReset)
+	}
+	v.text.Reset()
+}
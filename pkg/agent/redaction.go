@@ -0,0 +1,102 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+
+	"llm-agent/pkg/models"
+	"llm-agent/pkg/redact"
+	"llm-agent/pkg/storage"
+)
+
+// Redaction configures where secrets are replaced with placeholders before they leave the
+// process
+type Redaction struct {
+	Redactor    *redact.Redactor
+	ToolResults bool            // Redact tool results before they are sent to the model
+	SkipTools   map[string]bool // Tools whose results are sent to the model unredacted
+	Storage     bool            // Redact messages before they are saved to the chat history
+	// Routes overrides ToolResults per route of a router model, e.g. to send unredacted
+	// results only to a local model
+	Routes map[string]bool
+}
+
+// SetRedaction sets where secrets are redacted; by default nothing is
+func (a *Agent) SetRedaction(redaction Redaction) {
+	a.redaction = redaction
+	if router, ok := a.model.(*models.RouterModel); ok && len(redaction.Routes) > 0 {
+		router.SetPrepare(a.prepareForRoute)
+	}
+}
+
+// redactsToolResults reports whether tool results are redacted for the model or any of its routes
+func (r Redaction) redactsToolResults() bool {
+	if r.ToolResults {
+		return true
+	}
+	for _, on := range r.Routes {
+		if on {
+			return true
+		}
+	}
+	return false
+}
+
+// redactToolResult replaces the secrets in the result of a tool before it is sent to the
+// model, telling the user what was replaced. When routes differ in their setting, the
+// original is kept for the routes that see results unredacted.
+func (a *Agent) redactToolResult(toolName, result string) string {
+	if a.redaction.Redactor == nil || !a.redaction.redactsToolResults() || a.redaction.SkipTools[toolName] {
+		return result
+	}
+	redacted, findings := a.redaction.Redactor.Redact(result)
+	if len(findings) > 0 {
+		fmt.Printf("%s[Redacted] %s in the %s result%s\n", colorYellow, redact.Summary(findings), toolName, colorReset)
+		if len(a.redaction.Routes) > 0 {
+			if a.unredacted == nil {
+				a.unredacted = make(map[string]string)
+			}
+			a.unredacted[redacted] = result
+		}
+	}
+	return redacted
+}
+
+// prepareForRoute restores the original tool results for a route that sees them unredacted.
+// A result changed since, e.g. truncated by compaction, stays redacted.
+func (a *Agent) prepareForRoute(route *models.Route, messages []models.Message) []models.Message {
+	on, ok := a.redaction.Routes[route.Name]
+	if !ok {
+		on = a.redaction.ToolResults
+	}
+	if on || len(a.unredacted) == 0 {
+		return messages
+	}
+	prepared := make([]models.Message, len(messages))
+	copy(prepared, messages)
+	for i, msg := range prepared {
+		if msg.Role != "user" {
+			continue
+		}
+		result, ok := strings.CutPrefix(msg.Content, models.ToolResultPrefix)
+		if !ok {
+			continue
+		}
+		result, ok = strings.CutSuffix(result, "</result>")
+		if original, found := a.unredacted[result]; ok && found {
+			prepared[i].Content = models.ToolResultPrefix + original + "</result>"
+		}
+	}
+	return prepared
+}
+
+// redactForStorage replaces the secrets in a message before it is saved
+func (a *Agent) redactForStorage(msg storage.ChatMessage) storage.ChatMessage {
+	if a.redaction.Redactor == nil || !a.redaction.Storage {
+		return msg
+	}
+	msg, _ = storage.TransformMessage(msg, func(text string) (string, error) {
+		return a.redaction.Redactor.Text(text), nil
+	})
+	return msg
+}
@@ -0,0 +1,137 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"llm-agent/pkg/models"
+	"llm-agent/pkg/storage"
+
+	"github.com/google/uuid"
+)
+
+// SessionID returns the ID under which the messages of this run are stored
+func (a *Agent) SessionID() string {
+	return a.sessionID
+}
+
+// Resume continues a stored session: the messages of one of its branches are loaded into the
+// history when Run starts and new messages are stored under the same ID. branch is the ID, or
+// a unique prefix, of a message on the branch; empty selects the most recently saved branch.
+func (a *Agent) Resume(sessionID, branch string) error {
+	stored, err := a.storage.LoadConversation(sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to load session: %w", err)
+	}
+	if len(stored) == 0 {
+		return fmt.Errorf("session %s not found", sessionID)
+	}
+
+	path := storage.LatestBranch(stored)
+	if branch != "" {
+		if path, err = storage.FindBranch(stored, branch); err != nil {
+			return err
+		}
+	}
+	a.sessionID = sessionID
+	a.resumed = path
+	a.head = path[len(path)-1].ID
+	return nil
+}
+
+// restoreHistory returns the initial history of Run: the system prompt followed by the
+// messages of a resumed session
+func (a *Agent) restoreHistory(prompt models.Message) []models.Message {
+	if len(a.resumed) == 0 {
+		return []models.Message{prompt}
+	}
+
+	history := a.branchHistory(prompt, a.resumed)
+	a.promptSaved = true
+	fmt.Printf("%sResumed session with %d messages%s\n\n", colorOrange, len(history)-1, colorReset)
+	return history
+}
+
+// branchHistory returns the system prompt followed by the stored messages of a branch. A
+// stored system prompt replaces the given one; compaction records are skipped, as the
+// history is compacted again when needed.
+func (a *Agent) branchHistory(prompt models.Message, branch []storage.ChatMessage) []models.Message {
+	history := []models.Message{prompt}
+	for i, stored := range branch {
+		switch {
+		case stored.Role == "system" && i == 0 && !storage.IsCompactionRecord(stored):
+			history[0].Content = stored.Content
+		case stored.Role == "user" || stored.Role == "assistant":
+			history = append(history, models.Message{
+				Role:      stored.Role,
+				Content:   stored.Content,
+				Reasoning: stored.Reasoning,
+				Parts:     attachmentParts(stored.Attachments),
+			})
+		}
+	}
+	return history
+}
+
+// attachmentParts reloads the attachments of a stored message, skipping files that are gone
+func attachmentParts(paths []string) []models.ContentPart {
+	var parts []models.ContentPart
+	for _, path := range paths {
+		load := models.NewFilePart
+		if models.IsImageFile(path) {
+			load = models.NewImagePart
+		}
+		part, err := load(path)
+		if err != nil {
+			fmt.Printf("%sWarning: attachment %s of the resumed session is not available: %v%s\n", colorYellow, path, err, colorReset)
+			continue
+		}
+		parts = append(parts, part)
+	}
+	return parts
+}
+
+// saveSystemPrompt stores the system prompt once per session, before its first message
+func (a *Agent) saveSystemPrompt(prompt models.Message) {
+	if a.promptSaved {
+		return
+	}
+	a.promptSaved = true
+	if err := a.save(storage.NewChatMessage(models.Message{
+		Role:    "system",
+		Content: prompt.Content,
+	}, a.model.GetName(), models.Usage{}, nil, a.sessionID)); err != nil {
+		fmt.Printf("Warning: failed to save system prompt: %v\n", err)
+	}
+}
+
+// save stores a message as the follower of the last stored message of the current branch
+func (a *Agent) save(msg storage.ChatMessage) error {
+	msg = a.redactForStorage(msg)
+	msg.ParentID = a.head
+	if msg.ParentID == "" {
+		msg.ParentID = storage.RootParentID
+	}
+	if err := a.storage.SaveMessage(msg); err != nil {
+		return err
+	}
+	a.head = msg.ID
+	return nil
+}
+
+// newToolCall builds the stored record of a tool call. Arguments that are not valid JSON are
+// stored as a JSON string, so a malformed call from the model is kept as it was.
+func newToolCall(name, arguments string, record ToolCallRecord) storage.ToolCall {
+	call := storage.ToolCall{
+		ID:       uuid.New().String(),
+		Name:     name,
+		Error:    record.Error,
+		Duration: record.Duration,
+	}
+	if json.Valid([]byte(arguments)) {
+		call.Arguments = json.RawMessage(arguments)
+	} else if arguments != "" {
+		call.Arguments, _ = json.Marshal(arguments)
+	}
+	return call
+}
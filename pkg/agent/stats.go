@@ -0,0 +1,232 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"llm-agent/pkg/models"
+)
+
+// Statistics tracks usage statistics for the agent
+type Statistics struct {
+	TotalInputTokens  int64
+	TotalOutputTokens int64
+	TotalCacheWrite   int64   // Input tokens written to the prompt cache
+	TotalCacheRead    int64   // Input tokens read from the prompt cache
+	TotalCost         float64 // Cost in US dollars
+	ToolCalls         int
+	StartTime         time.Time
+	LastResponseTime  time.Duration
+	Turns             []TurnRecord
+}
+
+// TurnRecord holds the measurements of a single user turn, from prompt to final answer
+type TurnRecord struct {
+	Model      string            `json:"model"` // Model that produced the final answer
+	Start      time.Time         `json:"start"`
+	Duration   time.Duration     `json:"duration_ns"`
+	ModelCalls []ModelCallRecord `json:"model_calls"`
+	ToolCalls  []ToolCallRecord  `json:"tool_calls,omitempty"`
+}
+
+// ModelCallRecord holds the measurements of a single request to the model
+type ModelCallRecord struct {
+	Model            string         `json:"model"`
+	Latency          time.Duration  `json:"latency_ns"`
+	TimeToFirstToken time.Duration  `json:"time_to_first_token_ns"`
+	InputTokens      int64          `json:"input_tokens"`
+	OutputTokens     int64          `json:"output_tokens"`
+	CacheWriteTokens int64          `json:"cache_write_tokens,omitempty"`
+	CacheReadTokens  int64          `json:"cache_read_tokens,omitempty"`
+	Cost             float64        `json:"cost"`
+	Metrics          models.Metrics `json:"metrics"`
+}
+
+// ToolCallRecord holds the measurements of a single tool execution
+type ToolCallRecord struct {
+	Name     string        `json:"name"`
+	Duration time.Duration `json:"duration_ns"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// DurationSummary describes the distribution of a set of durations
+type DurationSummary struct {
+	Count   int           `json:"count"`
+	Average time.Duration `json:"average_ns"`
+	P50     time.Duration `json:"p50_ns"`
+	P90     time.Duration `json:"p90_ns"`
+	P99     time.Duration `json:"p99_ns"`
+	Max     time.Duration `json:"max_ns"`
+}
+
+// ToolSummary aggregates the executions of a single tool
+type ToolSummary struct {
+	Calls    int             `json:"calls"`
+	Errors   int             `json:"errors"`
+	Duration DurationSummary `json:"duration"`
+}
+
+// ModelSummary aggregates the calls made to a single model
+type ModelSummary struct {
+	Calls            int     `json:"calls"`
+	InputTokens      int64   `json:"input_tokens"`
+	OutputTokens     int64   `json:"output_tokens"`
+	CacheWriteTokens int64   `json:"cache_write_tokens,omitempty"`
+	CacheReadTokens  int64   `json:"cache_read_tokens,omitempty"`
+	Cost             float64 `json:"cost"`
+}
+
+// StatsSummary is the aggregated view of the statistics
+type StatsSummary struct {
+	Runtime           time.Duration           `json:"runtime_ns"`
+	Turns             int                     `json:"turns"`
+	TotalInputTokens  int64                   `json:"total_input_tokens"`
+	TotalOutputTokens int64                   `json:"total_output_tokens"`
+	TotalCacheWrite   int64                   `json:"total_cache_write_tokens,omitempty"`
+	TotalCacheRead    int64                   `json:"total_cache_read_tokens,omitempty"`
+	TotalCost         float64                 `json:"total_cost"`
+	TurnDuration      DurationSummary         `json:"turn_duration"`
+	ModelLatency      DurationSummary         `json:"model_latency"`
+	TimeToFirstToken  DurationSummary         `json:"time_to_first_token"`
+	TokensPerSecond   float64                 `json:"tokens_per_second"`
+	Models            map[string]ModelSummary `json:"models"`
+	Tools             map[string]ToolSummary  `json:"tools"`
+}
+
+// Summary aggregates the per-turn records into averages and percentiles
+func (s *Statistics) Summary() StatsSummary {
+	summary := StatsSummary{
+		Runtime:           time.Since(s.StartTime),
+		Turns:             len(s.Turns),
+		TotalInputTokens:  s.TotalInputTokens,
+		TotalOutputTokens: s.TotalOutputTokens,
+		TotalCacheWrite:   s.TotalCacheWrite,
+		TotalCacheRead:    s.TotalCacheRead,
+		TotalCost:         s.TotalCost,
+		Models:            make(map[string]ModelSummary),
+		Tools:             make(map[string]ToolSummary),
+	}
+
+	var turnDurations, latencies, firstTokens []time.Duration
+	var throughput float64
+	var throughputCalls int
+	toolDurations := make(map[string][]time.Duration)
+	for _, turn := range s.Turns {
+		turnDurations = append(turnDurations, turn.Duration)
+		for _, call := range turn.ModelCalls {
+			latencies = append(latencies, call.Latency)
+			if call.TimeToFirstToken > 0 {
+				firstTokens = append(firstTokens, call.TimeToFirstToken)
+			}
+			if call.Metrics.TokensPerSecond > 0 {
+				throughput += call.Metrics.TokensPerSecond
+				throughputCalls++
+			}
+			model := summary.Models[call.Model]
+			model.Calls++
+			model.InputTokens += call.InputTokens
+			model.OutputTokens += call.OutputTokens
+			model.CacheWriteTokens += call.CacheWriteTokens
+			model.CacheReadTokens += call.CacheReadTokens
+			model.Cost += call.Cost
+			summary.Models[call.Model] = model
+		}
+		for _, call := range turn.ToolCalls {
+			toolDurations[call.Name] = append(toolDurations[call.Name], call.Duration)
+			tool := summary.Tools[call.Name]
+			tool.Calls++
+			if call.Error != "" {
+				tool.Errors++
+			}
+			summary.Tools[call.Name] = tool
+		}
+	}
+
+	summary.TurnDuration = summarizeDurations(turnDurations)
+	summary.ModelLatency = summarizeDurations(latencies)
+	summary.TimeToFirstToken = summarizeDurations(firstTokens)
+	if throughputCalls > 0 {
+		summary.TokensPerSecond = throughput / float64(throughputCalls)
+	}
+	for name, durations := range toolDurations {
+		tool := summary.Tools[name]
+		tool.Duration = summarizeDurations(durations)
+		summary.Tools[name] = tool
+	}
+	return summary
+}
+
+// summarizeDurations computes the average and nearest-rank percentiles of the given durations
+func summarizeDurations(durations []time.Duration) DurationSummary {
+	if len(durations) == 0 {
+		return DurationSummary{}
+	}
+
+	sorted := make([]time.Duration, len(durations))
+	copy(sorted, durations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+
+	return DurationSummary{
+		Count:   len(sorted),
+		Average: total / time.Duration(len(sorted)),
+		P50:     percentile(sorted, 50),
+		P90:     percentile(sorted, 90),
+		P99:     percentile(sorted, 99),
+		Max:     sorted[len(sorted)-1],
+	}
+}
+
+// percentile returns the nearest-rank percentile of a sorted slice
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(p/100*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+// String formats the duration summary for display
+func (d DurationSummary) String() string {
+	if d.Count == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("avg %v, p50 %v, p90 %v, p99 %v (n=%d)",
+		d.Average.Round(time.Millisecond),
+		d.P50.Round(time.Millisecond),
+		d.P90.Round(time.Millisecond),
+		d.P99.Round(time.Millisecond),
+		d.Count)
+}
+
+// ExportStats writes the statistics summary and per-turn records to a JSON file
+func (a *Agent) ExportStats(path string) error {
+	a.statsMu.Lock()
+	report := struct {
+		Summary StatsSummary `json:"summary"`
+		Turns   []TurnRecord `json:"turns"`
+	}{
+		Summary: a.stats.Summary(),
+		Turns:   append([]TurnRecord(nil), a.stats.Turns...),
+	}
+	a.statsMu.Unlock()
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal statistics: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write statistics: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"llm-agent/pkg/models"
+	"llm-agent/pkg/tools"
+)
+
+// ToolStrategy is the way the model is told about tools and asks for them
+type ToolStrategy string
+
+const (
+	ToolStrategyAuto   ToolStrategy = "auto"   // Chosen from the model's capabilities
+	ToolStrategyNative ToolStrategy = "native" // The provider's function calling
+	ToolStrategyXML    ToolStrategy = "xml"    // <tool> tags described in the system prompt
+	ToolStrategyJSON   ToolStrategy = "json"   // Every reply is a JSON object constrained by a schema
+)
+
+// ParseToolStrategy parses the name of a tool strategy
+func ParseToolStrategy(name string) (ToolStrategy, error) {
+	switch strategy := ToolStrategy(name); strategy {
+	case ToolStrategyAuto, ToolStrategyNative, ToolStrategyXML, ToolStrategyJSON:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("unknown tool strategy %q (expected auto, native, xml or json)", name)
+	}
+}
+
+// resolveToolStrategy picks the strategy to use with a model. Native function calling is
+// preferred and models without it are prompted to use XML tags. A requested strategy the
+// model cannot support is replaced by the automatic choice.
+func resolveToolStrategy(requested ToolStrategy, caps models.Capabilities) (ToolStrategy, bool) {
+	switch requested {
+	case ToolStrategyXML:
+		return ToolStrategyXML, true
+	case ToolStrategyNative:
+		if caps.NativeTools {
+			return ToolStrategyNative, true
+		}
+	case ToolStrategyJSON:
+		if caps.JSONMode {
+			return ToolStrategyJSON, true
+		}
+	}
+
+	supported := requested == "" || requested == ToolStrategyAuto
+	if caps.NativeTools {
+		return ToolStrategyNative, supported
+	}
+	return ToolStrategyXML, supported
+}
+
+// systemPrompt describes the tools to the model in the form the strategy expects
+func systemPrompt(strategy ToolStrategy, agentTools []tools.Tool) string {
+	toolDescriptions := make([]string, len(agentTools))
+	for i, tool := range agentTools {
+		toolDescriptions[i] = fmt.Sprintf("- %s: %s", tool.GetName(), tool.GetDescription())
+	}
+
+	switch strategy {
+	case ToolStrategyXML:
+		return fmt.Sprintf(`<system>
+You are a helpful AI assistant with access to the following tools:
+
+%s
+
+## Instructions for tool usage:
+1. When a user asks you to perform a task requiring these tools, analyze which tool is appropriate.
+2. To use a tool, format your response using JSON within <tool></tool> tags:
+   <tool>
+   {
+     "name": "tool_name",
+     "arguments": {
+       "param1": "value1",
+       "param2": "value2"
+     }
+   }
+   </tool>
+
+3. After each tool call, wait for the result which will appear in <result></result> tags.
+4. Explain your reasoning both before and after using tools.
+5. Present results clearly with appropriate formatting.
+</system>`, strings.Join(toolDescriptions, "\n"))
+	case ToolStrategyJSON:
+		return fmt.Sprintf(`You are a helpful AI assistant with access to the following tools:
+
+%s
+
+Always reply with a JSON object. Put your answer or explanation for the user in "message". To use a tool, also set "tool" to an object with the tool "name" and its "arguments"; the result will appear in <result></result> tags in the next message. Leave out "tool" when no tool is needed.`, strings.Join(toolDescriptions, "\n"))
+	default:
+		return fmt.Sprintf(`You are a helpful AI assistant with access to the following tools:
+
+%s
+
+When a user asks you to perform a task that can be done using these tools, you should use them. Always explain what you're doing and show the results of each tool usage. If a user asks a question that is not related to tool usage, answer the question as normal.`, strings.Join(toolDescriptions, "\n"))
+	}
+}
+
+// jsonToolCall is a tool call in a JSON mode reply
+type jsonToolCall struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// jsonReply is the reply format of the JSON mode strategy
+type jsonReply struct {
+	Message string        `json:"message"`
+	Tool    *jsonToolCall `json:"tool,omitempty"`
+}
+
+// toolCallSchema returns the JSON schema of a call to one of the tools
+func toolCallSchema(agentTools []tools.Tool) map[string]interface{} {
+	names := make([]string, len(agentTools))
+	for i, tool := range agentTools {
+		names[i] = tool.GetName()
+	}
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"name":      map[string]interface{}{"type": "string", "enum": names},
+			"arguments": map[string]interface{}{"type": "object"},
+		},
+		"required": []string{"name", "arguments"},
+	}
+}
+
+// jsonReplySchema returns the JSON schema of replies in JSON mode
+func jsonReplySchema(agentTools []tools.Tool) json.RawMessage {
+	schema, _ := json.Marshal(map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"message": map[string]interface{}{"type": "string"},
+			"tool":    toolCallSchema(agentTools),
+		},
+		"required": []string{"message"},
+	})
+	return schema
+}
+
+// formatXMLToolCall renders a tool call in the <tool> format the agent parses
+func formatXMLToolCall(call jsonToolCall) string {
+	arguments := call.Arguments
+	if len(arguments) == 0 {
+		arguments = json.RawMessage("{}")
+	}
+	return fmt.Sprintf("\n<tool>\n{\"name\": %q, \"arguments\": %s}\n</tool>", call.Name, string(arguments))
+}
+
+// callModelJSON asks the model for a JSON mode reply and converts it to a response whose
+// content holds the message followed by the tool call in <tool> tags
+func (a *Agent) callModelJSON(ctx context.Context, messages []models.Message, turn *TurnRecord) (*models.Response, error) {
+	start := time.Now()
+	resp, err := a.model.GenerateStructured(ctx, messages, jsonReplySchema(a.tools))
+	if err != nil {
+		return nil, err
+	}
+	a.recordModelCall(resp, start, time.Since(start), turn)
+
+	var reply jsonReply
+	if err := json.Unmarshal([]byte(resp.Content), &reply); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON mode reply: %w", err)
+	}
+
+	resp.Content = reply.Message
+	fmt.Print(reply.Message)
+	if reply.Tool != nil && reply.Tool.Name != "" {
+		toolCall := formatXMLToolCall(*reply.Tool)
+		resp.Content += toolCall
+		fmt.Printf("%s%s%s", colorYellow, toolCall, colorReset)
+	}
+	return resp, nil
+}
+
+// recoverToolCall asks a model that supports JSON mode to restate a tool call from an XML
+// prompted reply whose <tool> block could not be parsed
+func (a *Agent) recoverToolCall(ctx context.Context, messages []models.Message, reply string, turn *TurnRecord) (string, string) {
+	schema, _ := json.Marshal(toolCallSchema(a.tools))
+	retry := append(append([]models.Message(nil), messages...),
+		models.Message{Role: "assistant", Content: reply},
+		models.Message{Role: "user", Content: "Your tool call could not be parsed. Restate it as a JSON object with the tool name and arguments."},
+	)
+
+	start := time.Now()
+	resp, err := a.model.GenerateStructured(ctx, retry, schema)
+	if err != nil {
+		return "", ""
+	}
+	a.recordModelCall(resp, start, time.Since(start), turn)
+
+	var call jsonToolCall
+	if err := json.Unmarshal([]byte(resp.Content), &call); err != nil {
+		return "", ""
+	}
+	return call.Name, string(call.Arguments)
+}
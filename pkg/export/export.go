@@ -0,0 +1,218 @@
+// Package export renders stored chat sessions for sharing and for building fine-tuning sets
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"llm-agent/pkg/storage"
+)
+
+// Filter selects the sessions and messages to export. Fields left at their zero value match
+// everything.
+type Filter struct {
+	SessionID string    // ID or unique ID prefix of a single session
+	Since     time.Time // Sessions active at or after this time
+	Until     time.Time // Sessions started before this time
+	Model     string    // Sessions with a message from a model whose name contains this
+	Roles     []string  // Messages with one of these roles
+	Branch    string    // ID of a message on the branch to export, or AllBranches; empty selects the latest branch
+}
+
+// AllBranches as Filter.Branch exports every branch of a session as its own conversation
+const AllBranches = "all"
+
+// Redactor rewrites text before it is exported, e.g. to remove secrets
+type Redactor func(text string) string
+
+// Conversation is a branch of a session prepared for export
+type Conversation struct {
+	Session  storage.Session
+	Branch   string // ID of the last message of the branch, empty when the session has no other branches
+	Messages []storage.ChatMessage
+}
+
+// Select loads the sessions matching the filter, oldest first, with the messages of the
+// selected branches redacted and normalized for rendering. Tool results are kept in the tool calls that produced them
+// instead of as separate messages.
+func Select(store storage.Store, filter Filter, redactors ...Redactor) ([]Conversation, error) {
+	var sessions []storage.Session
+	if filter.SessionID != "" {
+		session, err := storage.FindSession(store, filter.SessionID)
+		if err != nil {
+			return nil, err
+		}
+		sessions = []storage.Session{session}
+	} else {
+		all, err := store.ListSessions()
+		if err != nil {
+			return nil, err
+		}
+		sessions = all
+	}
+
+	var conversations []Conversation
+	for i := len(sessions) - 1; i >= 0; i-- {
+		session := sessions[i]
+		if !filter.Since.IsZero() && session.UpdatedAt.Before(filter.Since) {
+			continue
+		}
+		if !filter.Until.IsZero() && !session.StartedAt.Before(filter.Until) {
+			continue
+		}
+
+		messages, err := store.LoadConversation(session.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load session %s: %w", session.ID, err)
+		}
+		if filter.Model != "" && !usesModel(messages, filter.Model) {
+			continue
+		}
+
+		branches, err := selectBranches(messages, filter.Branch)
+		if err != nil {
+			return nil, fmt.Errorf("session %s: %w", session.ID, err)
+		}
+		forked := len(storage.Leaves(messages)) > 1
+		for _, branch := range branches {
+			conversation := Conversation{Session: session}
+			if forked {
+				conversation.Branch = branch[len(branch)-1].ID
+			}
+			branch = normalize(branch)
+			branch = filterRoles(branch, filter.Roles)
+			for j := range branch {
+				branch[j] = redactMessage(branch[j], redactors)
+			}
+			if len(branch) > 0 {
+				conversation.Messages = branch
+				conversations = append(conversations, conversation)
+			}
+		}
+	}
+	if len(conversations) == 0 && filter.Branch != "" && filter.Branch != AllBranches {
+		return nil, fmt.Errorf("branch %s not found", filter.Branch)
+	}
+	return conversations, nil
+}
+
+// selectBranches returns the branches of a session to export. A session without the message
+// that names the branch has none.
+func selectBranches(messages []storage.ChatMessage, branch string) ([][]storage.ChatMessage, error) {
+	switch branch {
+	case "":
+		return [][]storage.ChatMessage{storage.LatestBranch(messages)}, nil
+	case AllBranches:
+		var branches [][]storage.ChatMessage
+		for _, leaf := range storage.Leaves(messages) {
+			branches = append(branches, storage.Branch(messages, leaf.ID))
+		}
+		return branches, nil
+	}
+
+	for _, msg := range messages {
+		if strings.HasPrefix(msg.ID, branch) {
+			path, err := storage.FindBranch(messages, branch)
+			if err != nil {
+				return nil, err
+			}
+			return [][]storage.ChatMessage{path}, nil
+		}
+	}
+	return nil, nil
+}
+
+func usesModel(messages []storage.ChatMessage, model string) bool {
+	for _, msg := range messages {
+		if strings.Contains(msg.Model, model) {
+			return true
+		}
+	}
+	return false
+}
+
+func filterRoles(messages []storage.ChatMessage, roles []string) []storage.ChatMessage {
+	if len(roles) == 0 {
+		return messages
+	}
+	var filtered []storage.ChatMessage
+	for _, msg := range messages {
+		for _, role := range roles {
+			if msg.Role == role {
+				filtered = append(filtered, msg)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// normalize drops the tool result messages whose result is recorded with the tool call, and
+// removes from each answer the text of the tool call response it continues; the agent stores
+// the final answer of a turn with the responses before it.
+func normalize(messages []storage.ChatMessage) []storage.ChatMessage {
+	recorded := make(map[string]bool)
+	var result []storage.ChatMessage
+	var previous string // Content of the last assistant message that called a tool
+	for _, msg := range messages {
+		if msg.ToolCallID != "" && recorded[msg.ToolCallID] {
+			continue
+		}
+		if msg.Role == "assistant" {
+			if previous != "" && strings.HasPrefix(msg.Content, previous) {
+				msg.Content = strings.TrimLeft(msg.Content[len(previous):], "\n")
+			}
+			previous = ""
+			if len(msg.ToolCalls) > 0 {
+				previous = msg.Content
+				for _, call := range msg.ToolCalls {
+					recorded[call.ID] = true
+				}
+			}
+		}
+		result = append(result, msg)
+	}
+	return result
+}
+
+// redactMessage applies the redactors to the text of a message and its tool calls
+func redactMessage(msg storage.ChatMessage, redactors []Redactor) storage.ChatMessage {
+	if len(redactors) == 0 {
+		return msg
+	}
+	msg, _ = storage.TransformMessage(msg, func(text string) (string, error) {
+		for _, redactor := range redactors {
+			text = redactor(text)
+		}
+		return text, nil
+	})
+	return msg
+}
+
+// textBeforeToolCall returns the text of a response up to the tool call it contains
+func textBeforeToolCall(content string) string {
+	for _, marker := range []string{"\n[Tool:", "[Tool:", "<tool>"} {
+		if i := strings.Index(content, marker); i >= 0 {
+			content = content[:i]
+		}
+	}
+	return strings.TrimSpace(content)
+}
+
+// formatArguments returns tool call arguments as indented JSON
+func formatArguments(arguments json.RawMessage) string {
+	if len(arguments) == 0 {
+		return "{}"
+	}
+	var value interface{}
+	if err := json.Unmarshal(arguments, &value); err != nil {
+		return string(arguments)
+	}
+	indented, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return string(arguments)
+	}
+	return string(indented)
+}
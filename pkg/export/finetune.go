@@ -0,0 +1,186 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"llm-agent/pkg/storage"
+)
+
+// Fine-tuning sets are JSON Lines files with one conversation per line. System messages that
+// record a compaction and attachments are left out; tool calls are written in each provider's
+// native form with their results.
+
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    string           `json:"content"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAIToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// WriteOpenAI writes conversations in the OpenAI chat fine-tuning format
+func WriteOpenAI(w io.Writer, conversations []Conversation) error {
+	encoder := json.NewEncoder(w)
+	for _, conversation := range conversations {
+		var messages []openAIMessage
+		for _, msg := range conversation.Messages {
+			if storage.IsCompactionRecord(msg) {
+				continue
+			}
+			if len(msg.ToolCalls) == 0 {
+				if content := strings.TrimSpace(msg.Content); content != "" {
+					messages = append(messages, openAIMessage{Role: msg.Role, Content: content})
+				}
+				continue
+			}
+
+			assistant := openAIMessage{Role: msg.Role, Content: textBeforeToolCall(msg.Content)}
+			var results []openAIMessage
+			for _, call := range msg.ToolCalls {
+				toolCall := openAIToolCall{ID: call.ID, Type: "function"}
+				toolCall.Function.Name = call.Name
+				toolCall.Function.Arguments = argumentsString(call.Arguments)
+				assistant.ToolCalls = append(assistant.ToolCalls, toolCall)
+				results = append(results, openAIMessage{Role: "tool", Content: callOutput(call), ToolCallID: call.ID})
+			}
+			messages = append(messages, assistant)
+			messages = append(messages, results...)
+		}
+		if len(messages) == 0 {
+			continue
+		}
+		if err := encoder.Encode(map[string]interface{}{"messages": messages}); err != nil {
+			return fmt.Errorf("failed to write session %s: %w", conversation.Session.ID, err)
+		}
+	}
+	return nil
+}
+
+type anthropicMessage struct {
+	Role    string
+	Content []map[string]interface{}
+}
+
+// MarshalJSON writes a message with a single text block as plain string content
+func (m anthropicMessage) MarshalJSON() ([]byte, error) {
+	var content interface{} = m.Content
+	if len(m.Content) == 1 && m.Content[0]["type"] == "text" {
+		content = m.Content[0]["text"]
+	}
+	return json.Marshal(map[string]interface{}{"role": m.Role, "content": content})
+}
+
+// WriteAnthropic writes conversations in the Anthropic messages format, with the system prompt
+// in its own field and user and assistant turns alternating
+func WriteAnthropic(w io.Writer, conversations []Conversation) error {
+	encoder := json.NewEncoder(w)
+	for _, conversation := range conversations {
+		var system []string
+		var messages []anthropicMessage
+		add := func(role string, blocks ...map[string]interface{}) {
+			if len(blocks) == 0 {
+				return
+			}
+			// The API requires alternating roles, so consecutive messages of one role are merged
+			if n := len(messages); n > 0 && messages[n-1].Role == role {
+				messages[n-1].Content = append(messages[n-1].Content, blocks...)
+				return
+			}
+			if len(messages) == 0 && role != "user" {
+				return // Conversations must start with a user turn
+			}
+			messages = append(messages, anthropicMessage{Role: role, Content: blocks})
+		}
+
+		for _, msg := range conversation.Messages {
+			switch {
+			case storage.IsCompactionRecord(msg):
+				continue
+			case msg.Role == "system":
+				system = append(system, strings.TrimSpace(msg.Content))
+				continue
+			case len(msg.ToolCalls) == 0:
+				if content := strings.TrimSpace(msg.Content); content != "" {
+					add(msg.Role, textBlock(content))
+				}
+				continue
+			}
+
+			var calls, results []map[string]interface{}
+			if text := textBeforeToolCall(msg.Content); text != "" {
+				calls = append(calls, textBlock(text))
+			}
+			for _, call := range msg.ToolCalls {
+				input := call.Arguments
+				if !isJSONObject(input) {
+					input = json.RawMessage("{}")
+				}
+				calls = append(calls, map[string]interface{}{
+					"type":  "tool_use",
+					"id":    call.ID,
+					"name":  call.Name,
+					"input": input,
+				})
+				result := map[string]interface{}{
+					"type":        "tool_result",
+					"tool_use_id": call.ID,
+					"content":     callOutput(call),
+				}
+				if call.Error != "" {
+					result["is_error"] = true
+				}
+				results = append(results, result)
+			}
+			add(msg.Role, calls...)
+			add("user", results...)
+		}
+		if len(messages) == 0 {
+			continue
+		}
+
+		line := map[string]interface{}{"messages": messages}
+		if len(system) > 0 {
+			line["system"] = strings.Join(system, "\n\n")
+		}
+		if err := encoder.Encode(line); err != nil {
+			return fmt.Errorf("failed to write session %s: %w", conversation.Session.ID, err)
+		}
+	}
+	return nil
+}
+
+func textBlock(text string) map[string]interface{} {
+	return map[string]interface{}{"type": "text", "text": text}
+}
+
+// callOutput returns what a tool call returned to the model
+func callOutput(call storage.ToolCall) string {
+	if call.Error != "" {
+		return "Error: " + call.Error
+	}
+	return call.Result
+}
+
+// argumentsString returns tool call arguments as the JSON string OpenAI expects
+func argumentsString(arguments json.RawMessage) string {
+	if len(arguments) == 0 {
+		return "{}"
+	}
+	return string(arguments)
+}
+
+func isJSONObject(data json.RawMessage) bool {
+	var object map[string]interface{}
+	return json.Unmarshal(data, &object) == nil && object != nil
+}
@@ -0,0 +1,87 @@
+package export
+
+import (
+	"html/template"
+	"io"
+	"strings"
+	"time"
+
+	"llm-agent/pkg/storage"
+)
+
+// htmlTemplate renders a self-contained page: styles are inline and nothing is loaded from
+// elsewhere, so exports can be opened offline or attached to a bug report
+var htmlTemplate = template.Must(template.New("export").Funcs(template.FuncMap{
+	"title":     roleTitle,
+	"arguments": formatArguments,
+	"text":      messageText,
+	"time":      func(t time.Time) string { return t.Local().Format(time.RFC1123) },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Chat history</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 56rem; margin: 2rem auto; padding: 0 1rem; color: #1f2328; }
+header { border-bottom: 1px solid #d0d7de; margin: 2rem 0 1rem; }
+header p { color: #656d76; margin: 0.25rem 0 0.75rem; }
+.message { border: 1px solid #d0d7de; border-radius: 6px; margin: 1rem 0; padding: 0.75rem 1rem; }
+.user { background: #f6f8fa; }
+.system { background: #fff8c5; }
+.role { font-weight: 600; margin-bottom: 0.5rem; }
+.role span { color: #656d76; font-weight: normal; }
+.content { white-space: pre-wrap; }
+details { margin: 0.5rem 0; }
+summary { cursor: pointer; color: #0969da; }
+pre { background: #f6f8fa; border-radius: 6px; padding: 0.5rem; overflow-x: auto; white-space: pre-wrap; }
+.error { color: #cf222e; }
+</style>
+</head>
+<body>
+{{- range .}}
+<header>
+<h1>Session {{.Session.ID}}</h1>
+<p>Started {{time .Session.StartedAt}}{{with .Session.Model}} &middot; {{.}}{{end}} &middot; {{.Session.Turns}} turns{{with .Branch}} &middot; branch {{.}}{{end}}</p>
+</header>
+{{- range .Messages}}
+<div class="message {{.Role}}">
+<div class="role">{{title .Role}}{{if eq .Role "assistant"}}{{with .Model}} <span>{{.}}</span>{{end}}{{end}}</div>
+{{- with .Reasoning}}
+<details><summary>Reasoning</summary><pre>{{.}}</pre></details>
+{{- end}}
+{{- with text .}}
+<div class="content">{{.}}</div>
+{{- end}}
+{{- range .Attachments}}
+<p>Attachment: <code>{{.}}</code></p>
+{{- end}}
+{{- range .ToolCalls}}
+<details><summary>Tool: {{.Name}}</summary>
+<pre>{{arguments .Arguments}}</pre>
+{{- if .Error}}
+<p class="error">Error:</p><pre>{{.Error}}</pre>
+{{- else if .Result}}
+<p>Result:</p><pre>{{.Result}}</pre>
+{{- end}}
+</details>
+{{- end}}
+</div>
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
+
+// WriteHTML renders conversations as a self-contained HTML page with collapsible reasoning
+// and tool calls
+func WriteHTML(w io.Writer, conversations []Conversation) error {
+	return htmlTemplate.Execute(w, conversations)
+}
+
+// messageText returns the text of a message without the tool calls it contains
+func messageText(msg storage.ChatMessage) string {
+	if len(msg.ToolCalls) > 0 {
+		return textBeforeToolCall(msg.Content)
+	}
+	return strings.TrimSpace(msg.Content)
+}
@@ -0,0 +1,102 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"llm-agent/pkg/storage"
+)
+
+// WriteMarkdown renders conversations as a Markdown document. Reasoning and tool calls are
+// wrapped in <details> blocks, which most Markdown viewers show collapsed.
+func WriteMarkdown(w io.Writer, conversations []Conversation) error {
+	var b strings.Builder
+	for i, conversation := range conversations {
+		if i > 0 {
+			b.WriteString("\n---\n\n")
+		}
+		session := conversation.Session
+		fmt.Fprintf(&b, "# Session %s\n\n", session.ID)
+		fmt.Fprintf(&b, "- Started: %s\n", session.StartedAt.Local().Format(time.RFC1123))
+		if session.Model != "" {
+			fmt.Fprintf(&b, "- Model: %s\n", session.Model)
+		}
+		fmt.Fprintf(&b, "- Turns: %d\n", session.Turns)
+		if conversation.Branch != "" {
+			fmt.Fprintf(&b, "- Branch: %s\n", conversation.Branch)
+		}
+
+		for _, msg := range conversation.Messages {
+			b.WriteString("\n")
+			writeMarkdownMessage(&b, msg)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeMarkdownMessage(b *strings.Builder, msg storage.ChatMessage) {
+	heading := roleTitle(msg.Role)
+	if msg.Role == "assistant" && msg.Model != "" {
+		heading += " (" + msg.Model + ")"
+	}
+	fmt.Fprintf(b, "## %s\n\n", heading)
+
+	if msg.Reasoning != "" {
+		b.WriteString("<details>\n<summary>Reasoning</summary>\n\n")
+		b.WriteString(strings.TrimSpace(msg.Reasoning))
+		b.WriteString("\n\n</details>\n\n")
+	}
+
+	if content := messageText(msg); content != "" {
+		b.WriteString(content)
+		b.WriteString("\n")
+	}
+	for _, path := range msg.Attachments {
+		fmt.Fprintf(b, "\nAttachment: `%s`\n", path)
+	}
+
+	for _, call := range msg.ToolCalls {
+		fmt.Fprintf(b, "\n<details>\n<summary>Tool: %s</summary>\n\n", call.Name)
+		b.WriteString(fence("json", formatArguments(call.Arguments)))
+		if call.Error != "" {
+			b.WriteString("\nError:\n\n")
+			b.WriteString(fence("", call.Error))
+		} else if call.Result != "" {
+			b.WriteString("\nResult:\n\n")
+			b.WriteString(fence("", call.Result))
+		}
+		b.WriteString("\n</details>\n")
+	}
+}
+
+// fence wraps text in a code block whose fence is longer than any backtick run in the text
+func fence(language, text string) string {
+	longest, run := 0, 0
+	for _, r := range text {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	marker := strings.Repeat("`", max(3, longest+1))
+	return marker + language + "\n" + strings.TrimRight(text, "\n") + "\n" + marker + "\n"
+}
+
+// roleTitle returns the heading used for a message role
+func roleTitle(role string) string {
+	switch role {
+	case "user":
+		return "User"
+	case "assistant":
+		return "Assistant"
+	case "system":
+		return "System"
+	default:
+		return role
+	}
+}
@@ -0,0 +1,106 @@
+package index
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// BM25 parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// tokenize splits text into lowercase terms. Identifiers are also split on camel case and
+// underscores so "SaveMessage" matches a query for "save message".
+func tokenize(text string) []string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+
+	var terms []string
+	for _, word := range words {
+		terms = append(terms, strings.ToLower(word))
+		parts := splitIdentifier(word)
+		if len(parts) > 1 {
+			for _, part := range parts {
+				terms = append(terms, strings.ToLower(part))
+			}
+		}
+	}
+	return terms
+}
+
+// splitIdentifier splits camelCase, PascalCase and snake_case identifiers into words
+func splitIdentifier(word string) []string {
+	var parts []string
+	var current []rune
+	runes := []rune(word)
+	for i, r := range runes {
+		if r == '_' {
+			if len(current) > 0 {
+				parts = append(parts, string(current))
+				current = nil
+			}
+			continue
+		}
+		if i > 0 && unicode.IsUpper(r) && len(current) > 0 {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				parts = append(parts, string(current))
+				current = nil
+			}
+		}
+		current = append(current, r)
+	}
+	if len(current) > 0 {
+		parts = append(parts, string(current))
+	}
+	return parts
+}
+
+// bm25Scores ranks chunks against the query with Okapi BM25, returning one score per chunk
+func bm25Scores(chunks []*Chunk, query string) []float64 {
+	queryTerms := tokenize(query)
+	scores := make([]float64, len(chunks))
+	if len(queryTerms) == 0 || len(chunks) == 0 {
+		return scores
+	}
+
+	termFreqs := make([]map[string]int, len(chunks))
+	docFreq := make(map[string]int)
+	var totalLength int
+	for i, chunk := range chunks {
+		freqs := make(map[string]int)
+		terms := tokenize(chunk.Content)
+		for _, term := range terms {
+			freqs[term]++
+		}
+		for term := range freqs {
+			docFreq[term]++
+		}
+		termFreqs[i] = freqs
+		totalLength += len(terms)
+	}
+	avgLength := float64(totalLength) / float64(len(chunks))
+
+	n := float64(len(chunks))
+	for i, freqs := range termFreqs {
+		var length int
+		for _, count := range freqs {
+			length += count
+		}
+		for _, term := range queryTerms {
+			tf := float64(freqs[term])
+			if tf == 0 {
+				continue
+			}
+			df := float64(docFreq[term])
+			idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+			scores[i] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*float64(length)/avgLength))
+		}
+	}
+	return scores
+}
@@ -0,0 +1,130 @@
+package index
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strings"
+)
+
+// Chunking parameters for files that are split into line windows
+const (
+	windowLines  = 40 // Lines per window
+	windowStride = 30 // Lines between the starts of consecutive windows
+	maxDeclLines = 120
+)
+
+// Chunk is a contiguous range of lines from a source file
+type Chunk struct {
+	Path      string
+	StartLine int // 1-based, inclusive
+	EndLine   int // 1-based, inclusive
+	Content   string
+	Vector    []float32
+}
+
+// chunkFile splits a file into chunks: one per top-level declaration for Go files,
+// overlapping line windows for everything else
+func chunkFile(path string, content string) []Chunk {
+	lines := strings.Split(content, "\n")
+	if filepath.Ext(path) == ".go" {
+		if chunks, ok := chunkGoFile(path, content, lines); ok {
+			return chunks
+		}
+	}
+	return chunkLines(path, lines, 1, len(lines))
+}
+
+// chunkGoFile creates a chunk per top-level declaration, including its doc comment.
+// It reports false when the file cannot be parsed so the caller can fall back to windows.
+func chunkGoFile(path string, content string, lines []string) ([]Chunk, bool) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, content, parser.ParseComments)
+	if err != nil {
+		return nil, false
+	}
+
+	var chunks []Chunk
+
+	// The package clause and imports form the first chunk
+	headerEnd := fset.Position(file.Name.End()).Line
+	for _, imp := range file.Imports {
+		if line := fset.Position(imp.End()).Line; line > headerEnd {
+			headerEnd = line
+		}
+	}
+	for _, decl := range file.Decls {
+		if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+			if line := fset.Position(gen.End()).Line; line > headerEnd {
+				headerEnd = line
+			}
+		}
+	}
+	chunks = append(chunks, chunkLines(path, lines, 1, headerEnd)...)
+
+	for _, decl := range file.Decls {
+		var start token.Pos
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			start = d.Pos()
+			if d.Doc != nil {
+				start = d.Doc.Pos()
+			}
+		case *ast.GenDecl:
+			if d.Tok == token.IMPORT {
+				continue
+			}
+			start = d.Pos()
+			if d.Doc != nil {
+				start = d.Doc.Pos()
+			}
+		default:
+			continue
+		}
+		startLine := fset.Position(start).Line
+		endLine := fset.Position(decl.End()).Line
+		chunks = append(chunks, chunkLines(path, lines, startLine, endLine)...)
+	}
+	return chunks, true
+}
+
+// chunkLines returns the lines between start and end (1-based, inclusive) as a single chunk,
+// or as overlapping windows when the range is longer than a declaration should be
+func chunkLines(path string, lines []string, start, end int) []Chunk {
+	if end > len(lines) {
+		end = len(lines)
+	}
+	if start < 1 {
+		start = 1
+	}
+	if start > end {
+		return nil
+	}
+
+	if end-start+1 <= maxDeclLines && filepath.Ext(path) == ".go" {
+		return []Chunk{newChunk(path, lines, start, end)}
+	}
+
+	var chunks []Chunk
+	for from := start; from <= end; from += windowStride {
+		to := from + windowLines - 1
+		if to > end {
+			to = end
+		}
+		chunks = append(chunks, newChunk(path, lines, from, to))
+		if to == end {
+			break
+		}
+	}
+	return chunks
+}
+
+func newChunk(path string, lines []string, start, end int) Chunk {
+	return Chunk{
+		Path:      path,
+		StartLine: start,
+		EndLine:   end,
+		Content:   strings.Join(lines[start-1:end], "\n"),
+	}
+}
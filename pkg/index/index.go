@@ -0,0 +1,324 @@
+package index
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"llm-agent/pkg/models"
+	"llm-agent/pkg/tools"
+)
+
+// indexVersion is bumped whenever the on-disk format or chunking changes
+const indexVersion = 1
+
+// maxFileSize is the largest file that is indexed
+const maxFileSize = 512 * 1024
+
+// skipDirs are directories that never contain source worth indexing
+var skipDirs = map[string]bool{
+	"node_modules": true,
+	"vendor":       true,
+	"dist":         true,
+	"build":        true,
+	"target":       true,
+}
+
+// fileEntry is the indexed state of a single file
+type fileEntry struct {
+	ModTime time.Time
+	Size    int64
+	Hash    string
+	Chunks  []Chunk
+}
+
+// indexData is the persisted form of the index
+type indexData struct {
+	Version  int
+	Embedder string
+	Files    map[string]*fileEntry
+}
+
+// UpdateStats describes the work done by an incremental update
+type UpdateStats struct {
+	Added   int
+	Updated int
+	Removed int
+	Chunks  int
+}
+
+// Index is a chunked, optionally embedded, on-disk index of the files in a workspace.
+// Without an embedder it ranks chunks lexically with BM25.
+type Index struct {
+	mu       sync.Mutex
+	root     string
+	path     string
+	embedder models.Embedder
+	data     indexData
+}
+
+// New opens the index stored at path for the workspace root, creating an empty one if it
+// does not exist. The embedder may be nil to use lexical search only.
+func New(root, path string, embedder models.Embedder) (*Index, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve workspace root: %w", err)
+	}
+
+	idx := &Index{
+		root:     absRoot,
+		path:     path,
+		embedder: embedder,
+	}
+	if err := idx.load(); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// embedderName returns the name of the configured embedder, empty when using BM25
+func (idx *Index) embedderName() string {
+	if idx.embedder == nil {
+		return ""
+	}
+	return idx.embedder.GetName()
+}
+
+// load reads the index from disk, discarding it if it was built with another format or embedder
+func (idx *Index) load() error {
+	idx.data = indexData{
+		Version:  indexVersion,
+		Embedder: idx.embedderName(),
+		Files:    make(map[string]*fileEntry),
+	}
+
+	data, err := os.ReadFile(idx.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+
+	var stored indexData
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&stored); err != nil {
+		// A corrupt index is rebuilt rather than blocking the agent
+		return nil
+	}
+	if stored.Version != indexVersion || stored.Embedder != idx.embedderName() || stored.Files == nil {
+		return nil
+	}
+	idx.data = stored
+	return nil
+}
+
+// save writes the index to disk atomically
+func (idx *Index) save() error {
+	if err := os.MkdirAll(filepath.Dir(idx.path), 0755); err != nil {
+		return fmt.Errorf("failed to create index directory: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(idx.data); err != nil {
+		return fmt.Errorf("failed to encode index: %w", err)
+	}
+
+	tmp := idx.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	if err := os.Rename(tmp, idx.path); err != nil {
+		return fmt.Errorf("failed to replace index: %w", err)
+	}
+	return nil
+}
+
+// Update brings the index in line with the workspace. Files are re-chunked and re-embedded
+// only when their modification time or size changed and their content hash differs.
+func (idx *Index) Update(ctx context.Context) (UpdateStats, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	var stats UpdateStats
+	seen := make(map[string]bool)
+	var pending []*Chunk
+	absIndexPath, _ := filepath.Abs(idx.path)
+
+	err := filepath.Walk(idx.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil // Skip entries we can't access
+		}
+		if info.IsDir() {
+			name := info.Name()
+			if path != idx.root && (strings.HasPrefix(name, ".") || skipDirs[name]) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || info.Size() > maxFileSize || path == absIndexPath {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(idx.root, path)
+		if err != nil {
+			return err
+		}
+		seen[relPath] = true
+
+		entry, exists := idx.data.Files[relPath]
+		if exists && entry.ModTime.Equal(info.ModTime()) && entry.Size == info.Size() {
+			return nil
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil || isBinary(content) {
+			delete(idx.data.Files, relPath)
+			return nil
+		}
+		hash := sha256.Sum256(content)
+		hashString := hex.EncodeToString(hash[:])
+		if exists && entry.Hash == hashString {
+			entry.ModTime = info.ModTime()
+			entry.Size = info.Size()
+			return nil
+		}
+
+		entry = &fileEntry{
+			ModTime: info.ModTime(),
+			Size:    info.Size(),
+			Hash:    hashString,
+			Chunks:  chunkFile(relPath, string(content)),
+		}
+		idx.data.Files[relPath] = entry
+		for i := range entry.Chunks {
+			pending = append(pending, &entry.Chunks[i])
+		}
+		if exists {
+			stats.Updated++
+		} else {
+			stats.Added++
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, fmt.Errorf("failed to walk workspace: %w", err)
+	}
+
+	for path := range idx.data.Files {
+		if !seen[path] {
+			delete(idx.data.Files, path)
+			stats.Removed++
+		}
+	}
+
+	if idx.embedder != nil && len(pending) > 0 {
+		texts := make([]string, len(pending))
+		for i, chunk := range pending {
+			texts[i] = chunkText(chunk)
+		}
+		vectors, err := idx.embedder.Embed(ctx, texts)
+		if err != nil {
+			// Drop the unembedded files so the next update retries them
+			for _, chunk := range pending {
+				delete(idx.data.Files, chunk.Path)
+			}
+			return stats, fmt.Errorf("failed to embed chunks: %w", err)
+		}
+		for i, chunk := range pending {
+			chunk.Vector = vectors[i]
+		}
+	}
+	stats.Chunks = len(pending)
+
+	if stats.Added+stats.Updated+stats.Removed > 0 {
+		if err := idx.save(); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// Search updates the index and returns the chunks most relevant to the query
+func (idx *Index) Search(ctx context.Context, query string, limit int) ([]tools.CodeSearchResult, error) {
+	if _, err := idx.Update(ctx); err != nil {
+		return nil, err
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	var chunks []*Chunk
+	for _, entry := range idx.data.Files {
+		for i := range entry.Chunks {
+			chunks = append(chunks, &entry.Chunks[i])
+		}
+	}
+
+	var scores []float64
+	if idx.embedder != nil {
+		vectors, err := idx.embedder.Embed(ctx, []string{query})
+		if err != nil {
+			return nil, fmt.Errorf("failed to embed query: %w", err)
+		}
+		scores = make([]float64, len(chunks))
+		for i, chunk := range chunks {
+			scores[i] = float64(models.CosineSimilarity(vectors[0], chunk.Vector))
+		}
+	} else {
+		scores = bm25Scores(chunks, query)
+	}
+
+	order := make([]int, len(chunks))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		if scores[order[a]] != scores[order[b]] {
+			return scores[order[a]] > scores[order[b]]
+		}
+		ca, cb := chunks[order[a]], chunks[order[b]]
+		if ca.Path != cb.Path {
+			return ca.Path < cb.Path
+		}
+		return ca.StartLine < cb.StartLine
+	})
+
+	var results []tools.CodeSearchResult
+	for _, i := range order {
+		if len(results) >= limit || scores[i] <= 0 {
+			break
+		}
+		chunk := chunks[i]
+		results = append(results, tools.CodeSearchResult{
+			Path:      chunk.Path,
+			StartLine: chunk.StartLine,
+			EndLine:   chunk.EndLine,
+			Score:     scores[i],
+			Content:   chunk.Content,
+		})
+	}
+	return results, nil
+}
+
+// chunkText is the text embedded for a chunk; the path gives the embedding some context
+func chunkText(chunk *Chunk) string {
+	return fmt.Sprintf("%s:%d-%d\n%s", chunk.Path, chunk.StartLine, chunk.EndLine, chunk.Content)
+}
+
+// isBinary reports whether content looks like a binary file
+func isBinary(content []byte) bool {
+	sample := content
+	if len(sample) > 8000 {
+		sample = sample[:8000]
+	}
+	return bytes.IndexByte(sample, 0) != -1
+}
@@ -2,10 +2,12 @@ package models
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"llm-agent/pkg/tools"
+	"time"
 
 	openai "github.com/sashabaranov/go-openai"
 )
@@ -21,7 +23,13 @@ func NewChatGPTModel(config ModelConfig) (*ChatGPTModel, error) {
 		return nil, fmt.Errorf("API key is required for ChatGPT model")
 	}
 
-	client := openai.NewClient(config.APIKey)
+	warnUnsupported("chatgpt", config, ParamTopP, ParamStop, ParamSeed, ParamPresencePenalty, ParamFrequencyPenalty, ParamThinkingBudget)
+
+	clientConfig := openai.DefaultConfig(config.APIKey)
+	if config.BaseURL != "" {
+		clientConfig.BaseURL = config.BaseURL
+	}
+	client := openai.NewClientWithConfig(clientConfig)
 	return &ChatGPTModel{
 		client: client,
 		config: config,
@@ -29,33 +37,24 @@ func NewChatGPTModel(config ModelConfig) (*ChatGPTModel, error) {
 }
 
 func (m *ChatGPTModel) GenerateResponse(ctx context.Context, messages []Message) (*Response, error) {
-	openaiMessages := make([]openai.ChatCompletionMessage, len(messages))
-	for i, msg := range messages {
-		role := msg.Role
-		if role != "user" && role != "assistant" && role != "system" {
-			role = "user"
-		}
-		openaiMessages[i] = openai.ChatCompletionMessage{
-			Role:    role,
-			Content: msg.Content,
-		}
+	if err := checkVision(m, messages); err != nil {
+		return nil, err
 	}
+	openaiMessages := toOpenAIMessages(messages)
 
-	resp, err := m.client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
-		Model:       m.config.ModelName,
-		Messages:    openaiMessages,
-		MaxTokens:   m.config.MaxTokens,
-		Temperature: float32(m.config.Temperature),
-		Tools:       m.tools,
-	})
+	resp, err := m.client.CreateChatCompletion(ctx, m.newRequest(openaiMessages))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create chat completion: %w", err)
 	}
 
 	content := resp.Choices[0].Message.Content
+	for _, toolCall := range resp.Choices[0].Message.ToolCalls {
+		content += formatToolCall(toolCall.Function.Name, toolCall.Function.Arguments)
+	}
 
 	return &Response{
-		Content: content,
+		Content:   content,
+		Reasoning: resp.Choices[0].Message.ReasoningContent,
 		Usage: Usage{
 			InputTokens:  int64(resp.Usage.PromptTokens),
 			OutputTokens: int64(resp.Usage.CompletionTokens),
@@ -63,47 +62,208 @@ func (m *ChatGPTModel) GenerateResponse(ctx context.Context, messages []Message)
 	}, nil
 }
 
-func (m *ChatGPTModel) StreamResponse(ctx context.Context, messages []Message, onChunk func(chunk string) error) error {
-	openaiMessages := make([]openai.ChatCompletionMessage, len(messages))
-	for i, msg := range messages {
-		role := msg.Role
-		if role != "user" && role != "assistant" && role != "system" {
-			role = "user"
-		}
-		openaiMessages[i] = openai.ChatCompletionMessage{
-			Role:    role,
-			Content: msg.Content,
-		}
+func (m *ChatGPTModel) StreamResponse(ctx context.Context, messages []Message, onChunk func(chunk Chunk) error) (*Response, error) {
+	if err := checkVision(m, messages); err != nil {
+		return nil, err
 	}
+	openaiMessages := toOpenAIMessages(messages)
 
-	stream, err := m.client.CreateChatCompletionStream(ctx, openai.ChatCompletionRequest{
-		Model:       m.config.ModelName,
-		Messages:    openaiMessages,
-		MaxTokens:   m.config.MaxTokens,
-		Temperature: float32(m.config.Temperature),
-		Tools:       m.tools,
-	})
+	start := time.Now()
+	request := m.newRequest(openaiMessages)
+	request.StreamOptions = &openai.StreamOptions{
+		IncludeUsage: true,
+	}
+	stream, err := m.client.CreateChatCompletionStream(ctx, request)
 	if err != nil {
-		return fmt.Errorf("failed to create chat completion stream: %w", err)
+		return nil, fmt.Errorf("failed to create chat completion stream: %w", err)
 	}
 	defer stream.Close()
 
+	var content, reasoning string
+	var usage *openai.Usage
+	var metrics Metrics
+	var toolCalls []openai.ToolCall
 	for {
 		response, err := stream.Recv()
 		if err != nil {
 			if err == io.EOF {
 				break
 			}
-			return fmt.Errorf("error receiving stream: %w", err)
+			return nil, fmt.Errorf("error receiving stream: %w", err)
+		}
+		// The final chunk carries the usage and no choices
+		if response.Usage != nil {
+			usage = response.Usage
+		}
+		if len(response.Choices) == 0 {
+			continue
 		}
-		chunk := response.Choices[0].Delta.Content
-		if chunk != "" {
+		// OpenAI-compatible servers for reasoning models stream the reasoning separately
+		delta := response.Choices[0].Delta
+		toolCalls = accumulateToolCalls(toolCalls, delta.ToolCalls)
+		for _, chunk := range []Chunk{{Reasoning: delta.ReasoningContent}, {Content: delta.Content}} {
+			if chunk.Content == "" && chunk.Reasoning == "" {
+				continue
+			}
+			if metrics.TimeToFirstToken == 0 {
+				metrics.TimeToFirstToken = time.Since(start)
+			}
+			content += chunk.Content
+			reasoning += chunk.Reasoning
 			if err := onChunk(chunk); err != nil {
-				return fmt.Errorf("error processing chunk: %w", err)
+				return nil, fmt.Errorf("error processing chunk: %w", err)
 			}
 		}
 	}
-	return nil
+
+	// Tool call arguments arrive in fragments, so calls are only complete at the end
+	for _, toolCall := range toolCalls {
+		toolChunk := formatToolCall(toolCall.Function.Name, toolCall.Function.Arguments)
+		if metrics.TimeToFirstToken == 0 {
+			metrics.TimeToFirstToken = time.Since(start)
+		}
+		content += toolChunk
+		if err := onChunk(Chunk{Content: toolChunk}); err != nil {
+			return nil, fmt.Errorf("error processing tool call: %w", err)
+		}
+	}
+
+	metrics.TotalDuration = time.Since(start)
+	result := &Response{Content: content, Reasoning: reasoning}
+	if usage != nil {
+		result.Usage = Usage{
+			InputTokens:  int64(usage.PromptTokens),
+			OutputTokens: int64(usage.CompletionTokens),
+		}
+	} else {
+		result.Usage = Usage{
+			InputTokens:  int64(EstimateTokens(messages)),
+			OutputTokens: int64(EstimateTokens([]Message{{Content: reasoning + content}})),
+		}
+	}
+	metrics.TokensPerSecond = tokensPerSecond(result.Usage.OutputTokens, metrics.TotalDuration-metrics.TimeToFirstToken)
+	result.Metrics = metrics
+	return result, nil
+}
+
+// accumulateToolCalls merges streamed tool call fragments into the calls they belong to
+func accumulateToolCalls(calls []openai.ToolCall, deltas []openai.ToolCall) []openai.ToolCall {
+	for _, delta := range deltas {
+		index := len(calls)
+		if delta.Index != nil {
+			index = *delta.Index
+		}
+		for len(calls) <= index {
+			calls = append(calls, openai.ToolCall{Type: openai.ToolTypeFunction})
+		}
+		if delta.ID != "" {
+			calls[index].ID = delta.ID
+		}
+		calls[index].Function.Name += delta.Function.Name
+		calls[index].Function.Arguments += delta.Function.Arguments
+	}
+	return calls
+}
+
+// GenerateStructured requests a JSON response constrained by the schema using response_format
+func (m *ChatGPTModel) GenerateStructured(ctx context.Context, messages []Message, schema json.RawMessage) (*Response, error) {
+	if err := checkVision(m, messages); err != nil {
+		return nil, err
+	}
+
+	request := m.newRequest(toOpenAIMessages(messages))
+	request.Tools = nil
+	request.ResponseFormat = &openai.ChatCompletionResponseFormat{
+		Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+		JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+			Name:   structuredOutputName,
+			Schema: schema,
+		},
+	}
+	resp, err := m.client.CreateChatCompletion(ctx, request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create chat completion: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return nil, fmt.Errorf("chat completion returned no choices")
+	}
+
+	return &Response{
+		Content: resp.Choices[0].Message.Content,
+		Usage: Usage{
+			InputTokens:  int64(resp.Usage.PromptTokens),
+			OutputTokens: int64(resp.Usage.CompletionTokens),
+		},
+	}, nil
+}
+
+// newRequest builds a chat completion request for the given messages from the model config
+func (m *ChatGPTModel) newRequest(messages []openai.ChatCompletionMessage) openai.ChatCompletionRequest {
+	request := openai.ChatCompletionRequest{
+		Model:            m.config.ModelName,
+		Messages:         messages,
+		MaxTokens:        m.config.MaxTokens,
+		Temperature:      float32(m.config.Temperature),
+		TopP:             float32(m.config.TopP),
+		Stop:             m.config.StopSequences,
+		Seed:             m.config.Seed,
+		PresencePenalty:  float32(m.config.PresencePenalty),
+		FrequencyPenalty: float32(m.config.FrequencyPenalty),
+		Tools:            m.tools,
+	}
+
+	if m.isReasoningModel() {
+		// Reasoning models only accept max_completion_tokens and the default temperature
+		request.MaxCompletionTokens = request.MaxTokens
+		request.MaxTokens = 0
+		request.Temperature = 0
+		if m.config.ThinkingBudget > 0 {
+			request.ReasoningEffort = reasoningEffort(m.config.ThinkingBudget)
+		}
+	}
+	return request
+}
+
+// isReasoningModel reports whether the model is an o-series reasoning model
+func (m *ChatGPTModel) isReasoningModel() bool {
+	return hasAnyPrefix(m.config.ModelName, "o1", "o3", "o4")
+}
+
+// toOpenAIMessages converts our messages to OpenAI's format, mapping unknown roles to user
+func toOpenAIMessages(messages []Message) []openai.ChatCompletionMessage {
+	openaiMessages := make([]openai.ChatCompletionMessage, len(messages))
+	for i, msg := range messages {
+		role := msg.Role
+		if role != "user" && role != "assistant" && role != "system" {
+			role = "user"
+		}
+		if !msg.HasImages() {
+			openaiMessages[i] = openai.ChatCompletionMessage{
+				Role:    role,
+				Content: msg.Text(),
+			}
+			continue
+		}
+
+		// Images are sent as data URLs alongside the text
+		parts := []openai.ChatMessagePart{{
+			Type: openai.ChatMessagePartTypeText,
+			Text: msg.Text(),
+		}}
+		for _, image := range msg.Images() {
+			parts = append(parts, openai.ChatMessagePart{
+				Type: openai.ChatMessagePartTypeImageURL,
+				ImageURL: &openai.ChatMessageImageURL{
+					URL: fmt.Sprintf("data:%s;base64,%s", image.MIMEType, base64.StdEncoding.EncodeToString(image.Data)),
+				},
+			})
+		}
+		openaiMessages[i] = openai.ChatCompletionMessage{
+			Role:         role,
+			MultiContent: parts,
+		}
+	}
+	return openaiMessages
 }
 
 func (m *ChatGPTModel) GetName() string {
@@ -114,6 +274,30 @@ func (m *ChatGPTModel) GetMaxTokens() int {
 	return m.config.MaxTokens
 }
 
+func (m *ChatGPTModel) GetContextWindow() int {
+	if m.config.ContextWindow > 0 {
+		return m.config.ContextWindow
+	}
+	return defaultChatGPTContextWindow
+}
+
+func (m *ChatGPTModel) Capabilities() Capabilities {
+	// The first o1 previews accept neither tools nor system messages
+	preview := hasAnyPrefix(m.config.ModelName, "o1-preview", "o1-mini")
+	return Capabilities{
+		NativeTools:    !preview,
+		ParallelTools:  !preview,
+		Vision:         m.SupportsVision(),
+		StreamingTools: !preview,
+		SystemRole:     !preview,
+		JSONMode:       !preview,
+	}
+}
+
+func (m *ChatGPTModel) SupportsVision() bool {
+	return hasAnyPrefix(m.config.ModelName, "gpt-4o", "gpt-4-turbo", "gpt-4-vision", "gpt-4.1", "gpt-4.5", "gpt-5", "o1", "o3", "o4")
+}
+
 func (m *ChatGPTModel) SetTools(tools []tools.Tool) error {
 	// Convert our tools to ChatGPT's tool format
 	chatGPTTools := make([]openai.Tool, len(tools))
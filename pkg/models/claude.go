@@ -2,11 +2,27 @@ package models
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"llm-agent/pkg/tools"
+	"os"
+	"time"
 
 	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+)
+
+// cacheBreakpoint marks the end of a prompt prefix that Claude should cache. Up to four
+// breakpoints are allowed per request; we use one each for the tools, the system prompt and
+// the history. Prefixes shorter than the model's minimum cacheable length are not cached.
+var cacheBreakpoint = anthropic.CacheControlEphemeralParam{Type: "ephemeral"}
+
+// Limits of extended thinking: the budget must be at least minThinkingBudget tokens and below
+// max_tokens. When it is not, max_tokens is raised to leave thinkingHeadroom tokens for the answer.
+const (
+	minThinkingBudget = 1024
+	thinkingHeadroom  = 4096
 )
 
 type ClaudeModel struct {
@@ -20,7 +36,23 @@ func NewClaudeModel(config ModelConfig) (*ClaudeModel, error) {
 		return nil, fmt.Errorf("API key is required for Claude model")
 	}
 
-	client := anthropic.NewClient()
+	warnUnsupported("claude", config, ParamTopP, ParamTopK, ParamStop, ParamThinkingBudget)
+	if config.ThinkingBudget > 0 {
+		if config.ThinkingBudget < minThinkingBudget {
+			return nil, fmt.Errorf("thinking budget for Claude must be at least %d tokens, got %d", minThinkingBudget, config.ThinkingBudget)
+		}
+		if config.ThinkingBudget >= config.MaxTokens {
+			maxTokens := config.ThinkingBudget + thinkingHeadroom
+			fmt.Fprintf(os.Stderr, "Warning: the thinking budget of %d tokens must be below max tokens (%d), raising max tokens to %d\n", config.ThinkingBudget, config.MaxTokens, maxTokens)
+			config.MaxTokens = maxTokens
+		}
+	}
+
+	opts := []option.RequestOption{option.WithAPIKey(config.APIKey)}
+	if config.BaseURL != "" {
+		opts = append(opts, option.WithBaseURL(config.BaseURL))
+	}
+	client := anthropic.NewClient(opts...)
 	return &ClaudeModel{
 		client: &client,
 		config: config,
@@ -31,22 +63,9 @@ func (m *ClaudeModel) SetTools(tools []tools.Tool) error {
 	// Convert our tools to Claude's tool format
 	claudeTools := make([]anthropic.ToolUnionParam, len(tools))
 	for i, tool := range tools {
-		// Parse the input schema
-		var schema map[string]interface{}
-		if err := json.Unmarshal(tool.GetInputSchema(), &schema); err != nil {
-			return fmt.Errorf("failed to parse tool schema: %w", err)
-		}
-
-		// Create the tool input schema
-		inputSchema := anthropic.ToolInputSchemaParam{
-			Type:        "object",
-			Properties:  schema["properties"],
-			ExtraFields: make(map[string]interface{}),
-		}
-
-		// Add required fields if present
-		if required, ok := schema["required"].([]interface{}); ok {
-			inputSchema.ExtraFields["required"] = required
+		inputSchema, err := claudeInputSchema(tool.GetInputSchema())
+		if err != nil {
+			return err
 		}
 
 		claudeTools[i] = anthropic.ToolUnionParamOfTool(
@@ -55,22 +74,99 @@ func (m *ClaudeModel) SetTools(tools []tools.Tool) error {
 		)
 	}
 
+	// The tool definitions rarely change, cache them with everything before them
+	if len(claudeTools) > 0 {
+		*claudeTools[len(claudeTools)-1].GetCacheControl() = cacheBreakpoint
+	}
+
 	m.tools = claudeTools
 	return nil
 }
 
+// newParams builds the request parameters for the given messages from the model config
+func (m *ClaudeModel) newParams(messages []Message) anthropic.MessageNewParams {
+	system, anthropicMessages := toClaudeMessages(messages)
+	params := anthropic.MessageNewParams{
+		Model:         anthropic.Model(m.config.ModelName),
+		MaxTokens:     int64(m.config.MaxTokens),
+		System:        system,
+		Messages:      anthropicMessages,
+		Tools:         m.tools,
+		StopSequences: m.config.StopSequences,
+	}
+
+	if m.config.ThinkingBudget > 0 {
+		// Extended thinking requires the default temperature and no top_k
+		params.Thinking = anthropic.ThinkingConfigParamOfThinkingConfigEnabled(int64(m.config.ThinkingBudget))
+	} else {
+		// A zero temperature is left unset, so the API default applies
+		if m.config.Temperature != 0 {
+			params.Temperature = anthropic.Float(m.config.Temperature)
+		}
+		if m.config.TopK > 0 {
+			params.TopK = anthropic.Int(int64(m.config.TopK))
+		}
+	}
+	if m.config.TopP > 0 {
+		params.TopP = anthropic.Float(m.config.TopP)
+	}
+
+	// Cache the system prompt and the history up to the latest message, so the next request
+	// only pays full price for what was added since
+	if len(system) > 0 {
+		system[len(system)-1].CacheControl = cacheBreakpoint
+	}
+	if len(anthropicMessages) > 0 {
+		blocks := anthropicMessages[len(anthropicMessages)-1].Content
+		for i := len(blocks) - 1; i >= 0; i-- {
+			// Thinking blocks cannot carry a breakpoint
+			if cacheControl := blocks[i].GetCacheControl(); cacheControl != nil {
+				*cacheControl = cacheBreakpoint
+				break
+			}
+		}
+	}
+	return params
+}
+
+// claudeUsage converts Claude's usage, which counts cached input tokens separately
+func claudeUsage(usage anthropic.Usage) Usage {
+	return Usage{
+		InputTokens:              usage.InputTokens,
+		OutputTokens:             usage.OutputTokens,
+		CacheCreationInputTokens: usage.CacheCreationInputTokens,
+		CacheReadInputTokens:     usage.CacheReadInputTokens,
+	}
+}
+
+// claudeInputSchema converts a JSON schema to Claude's tool input schema format
+func claudeInputSchema(raw json.RawMessage) (anthropic.ToolInputSchemaParam, error) {
+	// Parse the input schema
+	var schema map[string]interface{}
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		return anthropic.ToolInputSchemaParam{}, fmt.Errorf("failed to parse tool schema: %w", err)
+	}
+
+	// Create the tool input schema
+	inputSchema := anthropic.ToolInputSchemaParam{
+		Type:        "object",
+		Properties:  schema["properties"],
+		ExtraFields: make(map[string]interface{}),
+	}
+
+	// Add required fields if present
+	if required, ok := schema["required"].([]interface{}); ok {
+		inputSchema.ExtraFields["required"] = required
+	}
+	return inputSchema, nil
+}
+
 func (m *ClaudeModel) GenerateResponse(ctx context.Context, messages []Message) (*Response, error) {
-	anthropicMessages := make([]anthropic.MessageParam, len(messages))
-	for i, msg := range messages {
-		anthropicMessages[i] = anthropic.NewUserMessage(anthropic.NewTextBlock(msg.Content))
+	if err := checkVision(m, messages); err != nil {
+		return nil, err
 	}
 
-	message, err := m.client.Messages.New(ctx, anthropic.MessageNewParams{
-		Model:     anthropic.ModelClaude3_7SonnetLatest,
-		MaxTokens: int64(m.config.MaxTokens),
-		Messages:  anthropicMessages,
-		Tools:     m.tools,
-	})
+	message, err := m.client.Messages.New(ctx, m.newParams(messages))
 	if err != nil {
 		return nil, err
 	}
@@ -84,51 +180,185 @@ func (m *ClaudeModel) GenerateResponse(ctx context.Context, messages []Message)
 			content += textBlock.Text
 		case "tool_use":
 			toolBlock := block.AsResponseToolUseBlock()
-			// Format tool usage in a way that's easy to read
-			content += fmt.Sprintf("\n[Tool: %s]\nInput: %s\n", toolBlock.Name, string(toolBlock.Input))
+			content += formatToolCall(toolBlock.Name, string(toolBlock.Input))
+		}
+	}
+
+	reasoning, blocks := claudeReasoning(message.Content)
+	return &Response{
+		Content:         content,
+		Reasoning:       reasoning,
+		ReasoningBlocks: blocks,
+		Usage:           claudeUsage(message.Usage),
+	}, nil
+}
+
+func (m *ClaudeModel) StreamResponse(ctx context.Context, messages []Message, onChunk func(chunk Chunk) error) (*Response, error) {
+	if err := checkVision(m, messages); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	stream := m.client.Messages.NewStreaming(ctx, m.newParams(messages))
+	defer stream.Close()
+
+	var metrics Metrics
+	var content string
+	message := anthropic.Message{}
+	for stream.Next() {
+		event := stream.Current()
+		if err := message.Accumulate(event); err != nil {
+			return nil, fmt.Errorf("failed to accumulate stream event: %w", err)
+		}
+
+		if event.Type != "content_block_delta" {
+			continue
+		}
+		var chunk Chunk
+		switch event.Delta.Type {
+		case "text_delta":
+			chunk.Content = event.Delta.Text
+		case "thinking_delta":
+			chunk.Reasoning = event.Delta.Thinking
+		}
+		if chunk.Content == "" && chunk.Reasoning == "" {
+			continue
+		}
+		if metrics.TimeToFirstToken == 0 {
+			metrics.TimeToFirstToken = time.Since(start)
+		}
+		content += chunk.Content
+		if err := onChunk(chunk); err != nil {
+			return nil, err
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return nil, err
+	}
+
+	// Tool calls are only complete once the stream has finished
+	for _, block := range message.Content {
+		if block.Type != "tool_use" {
+			continue
+		}
+		toolChunk := formatToolCall(block.Name, string(block.Input))
+		if metrics.TimeToFirstToken == 0 {
+			metrics.TimeToFirstToken = time.Since(start)
+		}
+		content += toolChunk
+		if err := onChunk(Chunk{Content: toolChunk}); err != nil {
+			return nil, err
 		}
 	}
 
+	metrics.TotalDuration = time.Since(start)
+	metrics.TokensPerSecond = tokensPerSecond(message.Usage.OutputTokens, metrics.TotalDuration-metrics.TimeToFirstToken)
+
+	reasoning, blocks := claudeReasoning(message.Content)
 	return &Response{
-		Content: content,
-		Usage: Usage{
-			InputTokens:  message.Usage.InputTokens,
-			OutputTokens: message.Usage.OutputTokens,
-		},
+		Content:         content,
+		Reasoning:       reasoning,
+		ReasoningBlocks: blocks,
+		Usage:           claudeUsage(message.Usage),
+		Metrics:         metrics,
 	}, nil
 }
 
-func (m *ClaudeModel) StreamResponse(ctx context.Context, messages []Message, onChunk func(chunk string) error) error {
-	anthropicMessages := make([]anthropic.MessageParam, len(messages))
-	for i, msg := range messages {
-		anthropicMessages[i] = anthropic.NewUserMessage(anthropic.NewTextBlock(msg.Content))
+// GenerateStructured forces Claude to call a tool whose input schema is the requested schema
+// and returns the tool input as the response content
+func (m *ClaudeModel) GenerateStructured(ctx context.Context, messages []Message, schema json.RawMessage) (*Response, error) {
+	inputSchema, err := claudeInputSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkVision(m, messages); err != nil {
+		return nil, err
 	}
 
-	message, err := m.client.Messages.New(ctx, anthropic.MessageNewParams{
-		Model:     anthropic.ModelClaude3_7SonnetLatest,
-		MaxTokens: int64(m.config.MaxTokens),
-		Messages:  anthropicMessages,
-		Tools:     m.tools,
-	})
+	params := m.newParams(messages)
+	params.Tools = []anthropic.ToolUnionParam{anthropic.ToolUnionParamOfTool(inputSchema, structuredOutputName)}
+	params.ToolChoice = anthropic.ToolChoiceParamOfToolChoiceTool(structuredOutputName)
+	// Forcing a tool call is not allowed together with extended thinking
+	params.Thinking = anthropic.ThinkingConfigParamUnion{}
+
+	message, err := m.client.Messages.New(ctx, params)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Extract content from the response
-	var content string
 	for _, block := range message.Content {
+		if block.Type == "tool_use" && block.Name == structuredOutputName {
+			return &Response{
+				Content: string(block.Input),
+				Usage:   claudeUsage(message.Usage),
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("claude did not return structured output")
+}
+
+// claudeReasoning collects the thinking blocks of a response, which must be sent back
+// unchanged with the assistant message
+func claudeReasoning(content []anthropic.ContentBlockUnion) (string, []ReasoningBlock) {
+	var reasoning string
+	var blocks []ReasoningBlock
+	for _, block := range content {
 		switch block.Type {
-		case "text":
-			textBlock := block.AsResponseTextBlock()
-			content += textBlock.Text
-		case "tool_use":
-			toolBlock := block.AsResponseToolUseBlock()
-			// Format tool usage in a way that's easy to read
-			content += fmt.Sprintf("\n[Tool: %s]\nInput: %s\n", toolBlock.Name, string(toolBlock.Input))
+		case "thinking":
+			reasoning += block.Thinking
+			blocks = append(blocks, ReasoningBlock{Text: block.Thinking, Signature: block.Signature})
+		case "redacted_thinking":
+			blocks = append(blocks, ReasoningBlock{Redacted: block.Data})
 		}
 	}
+	return reasoning, blocks
+}
+
+// toClaudeMessages converts our messages to Claude's format. System messages become the
+// system prompt, images are encoded as base64 blocks and assistant messages are preceded by
+// their thinking blocks.
+func toClaudeMessages(messages []Message) ([]anthropic.TextBlockParam, []anthropic.MessageParam) {
+	var system []anthropic.TextBlockParam
+	var anthropicMessages []anthropic.MessageParam
+	for _, msg := range messages {
+		if msg.Role == "system" {
+			system = append(system, anthropic.TextBlockParam{Text: msg.Text()})
+			continue
+		}
 
-	return onChunk(content)
+		var blocks []anthropic.ContentBlockParamUnion
+		if msg.Role == "assistant" {
+			for _, block := range msg.ReasoningBlocks {
+				if block.Redacted != "" {
+					blocks = append(blocks, anthropic.ContentBlockParamOfRequestRedactedThinkingBlock(block.Redacted))
+				} else {
+					blocks = append(blocks, anthropic.ContentBlockParamOfRequestThinkingBlock(block.Signature, block.Text))
+				}
+			}
+		}
+		// Claude rejects empty text blocks
+		if msg.Content != "" {
+			blocks = append(blocks, anthropic.NewTextBlock(msg.Content))
+		}
+		for _, part := range msg.Parts {
+			if part.Type == PartImage {
+				blocks = append(blocks, anthropic.NewImageBlockBase64(part.MIMEType, base64.StdEncoding.EncodeToString(part.Data)))
+			} else {
+				blocks = append(blocks, anthropic.NewTextBlock(partText(part)))
+			}
+		}
+		if len(blocks) == 0 {
+			continue
+		}
+
+		if msg.Role == "assistant" {
+			anthropicMessages = append(anthropicMessages, anthropic.NewAssistantMessage(blocks...))
+		} else {
+			anthropicMessages = append(anthropicMessages, anthropic.NewUserMessage(blocks...))
+		}
+	}
+	return system, anthropicMessages
 }
 
 func (m *ClaudeModel) GetName() string {
@@ -138,3 +368,26 @@ func (m *ClaudeModel) GetName() string {
 func (m *ClaudeModel) GetMaxTokens() int {
 	return m.config.MaxTokens
 }
+
+func (m *ClaudeModel) GetContextWindow() int {
+	if m.config.ContextWindow > 0 {
+		return m.config.ContextWindow
+	}
+	return defaultClaudeContextWindow
+}
+
+func (m *ClaudeModel) Capabilities() Capabilities {
+	return Capabilities{
+		NativeTools:    true,
+		ParallelTools:  true,
+		Vision:         m.SupportsVision(),
+		StreamingTools: true,
+		SystemRole:     true,
+		JSONMode:       true,
+	}
+}
+
+func (m *ClaudeModel) SupportsVision() bool {
+	// Every model since Claude 3 accepts images
+	return !hasAnyPrefix(m.config.ModelName, "claude-2", "claude-instant")
+}
@@ -0,0 +1,153 @@
+package models
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Content part types
+const (
+	PartText  = "text"
+	PartImage = "image"
+	PartFile  = "file"
+)
+
+// imageTokenEstimate is the rough number of tokens an image costs in the context window
+const imageTokenEstimate = 1500
+
+// ContentPart is a piece of multimodal message content. Messages with parts are sent with
+// Message.Content as the leading text followed by the parts in order.
+type ContentPart struct {
+	Type     string `json:"type"`                // PartText, PartImage or PartFile
+	Text     string `json:"text,omitempty"`      // Text of a text part, or contents of a file reference
+	Data     []byte `json:"data,omitempty"`      // Raw image bytes
+	MIMEType string `json:"mime_type,omitempty"` // MIME type of the image
+	Path     string `json:"path,omitempty"`      // Source path of an image or file reference
+}
+
+// imageMIMETypes maps the image extensions accepted by the backends to their MIME types
+var imageMIMETypes = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+}
+
+// IsImageFile reports whether the path has an image extension supported by the backends
+func IsImageFile(path string) bool {
+	_, ok := imageMIMETypes[strings.ToLower(filepath.Ext(path))]
+	return ok
+}
+
+// NewImagePart loads an image file into a content part
+func NewImagePart(path string) (ContentPart, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ContentPart{}, fmt.Errorf("failed to read image: %w", err)
+	}
+
+	mimeType, ok := imageMIMETypes[strings.ToLower(filepath.Ext(path))]
+	if !ok {
+		mimeType = http.DetectContentType(data)
+	}
+	if !strings.HasPrefix(mimeType, "image/") {
+		return ContentPart{}, fmt.Errorf("%s is not an image (detected %s)", path, mimeType)
+	}
+
+	return ContentPart{
+		Type:     PartImage,
+		Data:     data,
+		MIMEType: mimeType,
+		Path:     path,
+	}, nil
+}
+
+// NewFilePart loads a text file into a file reference part
+func NewFilePart(path string) (ContentPart, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ContentPart{}, fmt.Errorf("failed to read file: %w", err)
+	}
+	return ContentPart{
+		Type: PartFile,
+		Text: string(data),
+		Path: path,
+	}, nil
+}
+
+// HasImages reports whether the message carries image parts
+func (m Message) HasImages() bool {
+	for _, part := range m.Parts {
+		if part.Type == PartImage {
+			return true
+		}
+	}
+	return false
+}
+
+// Images returns the image parts of the message
+func (m Message) Images() []ContentPart {
+	var images []ContentPart
+	for _, part := range m.Parts {
+		if part.Type == PartImage {
+			images = append(images, part)
+		}
+	}
+	return images
+}
+
+// Text returns the textual content of the message: the content followed by text parts and
+// file references. Images are not included.
+func (m Message) Text() string {
+	if len(m.Parts) == 0 {
+		return m.Content
+	}
+
+	var text strings.Builder
+	text.WriteString(m.Content)
+	for _, part := range m.Parts {
+		if part.Type == PartImage {
+			continue
+		}
+		if text.Len() > 0 {
+			text.WriteString("\n\n")
+		}
+		text.WriteString(partText(part))
+	}
+	return text.String()
+}
+
+// partText renders a non-image part as text
+func partText(part ContentPart) string {
+	if part.Type == PartFile {
+		return fmt.Sprintf("<file path=%q>\n%s\n</file>", part.Path, part.Text)
+	}
+	return part.Text
+}
+
+// checkVision returns an error when the messages contain images but the model cannot see them
+func checkVision(model Model, messages []Message) error {
+	if model.SupportsVision() {
+		return nil
+	}
+	for _, msg := range messages {
+		if msg.HasImages() {
+			return fmt.Errorf("model %s does not support image input", model.GetName())
+		}
+	}
+	return nil
+}
+
+// hasAnyPrefix reports whether s starts with any of the prefixes
+func hasAnyPrefix(s string, prefixes ...string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,314 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"strings"
+	"unicode"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// embeddingBatchSize is the maximum number of texts sent in a single embedding request
+const embeddingBatchSize = 96
+
+// Embedder turns text into vectors for semantic search
+type Embedder interface {
+	// Embed returns one vector per input text, in the same order
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+
+	// Dimensions returns the length of the vectors, or 0 if not yet known
+	Dimensions() int
+
+	// GetName returns the name of the embedding model
+	GetName() string
+}
+
+// embedInBatches splits texts into batches and concatenates the vectors returned by embed
+func embedInBatches(ctx context.Context, texts []string, embed func(ctx context.Context, batch []string) ([][]float32, error)) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += embeddingBatchSize {
+		end := start + embeddingBatchSize
+		if end > len(texts) {
+			end = len(texts)
+		}
+		batch, err := embed(ctx, texts[start:end])
+		if err != nil {
+			return nil, err
+		}
+		if len(batch) != end-start {
+			return nil, fmt.Errorf("expected %d embeddings, got %d", end-start, len(batch))
+		}
+		vectors = append(vectors, batch...)
+	}
+	return vectors, nil
+}
+
+// CosineSimilarity returns the cosine similarity of two vectors of the same length
+func CosineSimilarity(a, b []float32) float32 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return float32(dot / (math.Sqrt(normA) * math.Sqrt(normB)))
+}
+
+// OpenAIEmbedder uses the OpenAI embeddings API
+type OpenAIEmbedder struct {
+	client *openai.Client
+	config ModelConfig
+}
+
+// openAIEmbeddingDimensions lists the vector sizes of the OpenAI embedding models
+var openAIEmbeddingDimensions = map[string]int{
+	"text-embedding-3-small": 1536,
+	"text-embedding-3-large": 3072,
+	"text-embedding-ada-002": 1536,
+}
+
+func NewOpenAIEmbedder(config ModelConfig) (*OpenAIEmbedder, error) {
+	if config.APIKey == "" {
+		return nil, fmt.Errorf("API key is required for OpenAI embeddings")
+	}
+	if config.ModelName == "" {
+		config.ModelName = "text-embedding-3-small"
+	}
+
+	clientConfig := openai.DefaultConfig(config.APIKey)
+	if config.BaseURL != "" {
+		clientConfig.BaseURL = config.BaseURL
+	}
+	return &OpenAIEmbedder{
+		client: openai.NewClientWithConfig(clientConfig),
+		config: config,
+	}, nil
+}
+
+func (e *OpenAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	return embedInBatches(ctx, texts, func(ctx context.Context, batch []string) ([][]float32, error) {
+		resp, err := e.client.CreateEmbeddings(ctx, openai.EmbeddingRequest{
+			Input: batch,
+			Model: openai.EmbeddingModel(e.config.ModelName),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create embeddings: %w", err)
+		}
+
+		vectors := make([][]float32, len(batch))
+		for _, data := range resp.Data {
+			if data.Index < 0 || data.Index >= len(vectors) {
+				return nil, fmt.Errorf("embedding index %d out of range", data.Index)
+			}
+			vectors[data.Index] = data.Embedding
+		}
+		return vectors, nil
+	})
+}
+
+func (e *OpenAIEmbedder) Dimensions() int {
+	return openAIEmbeddingDimensions[e.config.ModelName]
+}
+
+func (e *OpenAIEmbedder) GetName() string {
+	return fmt.Sprintf("openai-%s", e.config.ModelName)
+}
+
+// OllamaEmbedder uses a local Ollama server for embeddings
+type OllamaEmbedder struct {
+	config     ModelConfig
+	client     *http.Client
+	dimensions int
+}
+
+// ollamaEmbeddingDimensions lists the vector sizes of common Ollama embedding models
+var ollamaEmbeddingDimensions = map[string]int{
+	"nomic-embed-text":  768,
+	"mxbai-embed-large": 1024,
+	"all-minilm":        384,
+}
+
+type ollamaEmbedRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type ollamaEmbedResponse struct {
+	Embeddings [][]float32 `json:"embeddings"`
+}
+
+type ollamaEmbeddingsRequest struct {
+	Model  string `json:"model"`
+	Prompt string `json:"prompt"`
+}
+
+type ollamaEmbeddingsResponse struct {
+	Embedding []float32 `json:"embedding"`
+}
+
+func NewOllamaEmbedder(config ModelConfig) (*OllamaEmbedder, error) {
+	if config.ModelName == "" {
+		config.ModelName = "nomic-embed-text"
+	}
+
+	name, _, _ := strings.Cut(config.ModelName, ":")
+	return &OllamaEmbedder{
+		config:     config,
+		client:     &http.Client{},
+		dimensions: ollamaEmbeddingDimensions[name],
+	}, nil
+}
+
+// Embed sends batches to /api/embed, falling back to one /api/embeddings request per text
+// for Ollama versions that predate the batch endpoint
+func (e *OllamaEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors, err := embedInBatches(ctx, texts, func(ctx context.Context, batch []string) ([][]float32, error) {
+		var resp ollamaEmbedResponse
+		status, err := e.post(ctx, "/api/embed", ollamaEmbedRequest{Model: e.config.ModelName, Input: batch}, &resp)
+		if err != nil {
+			return nil, err
+		}
+		if status == http.StatusNotFound {
+			return e.embedEach(ctx, batch)
+		}
+		if status != http.StatusOK {
+			return nil, fmt.Errorf("ollama API returned status code: %d", status)
+		}
+		return resp.Embeddings, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(vectors) > 0 {
+		e.dimensions = len(vectors[0])
+	}
+	return vectors, nil
+}
+
+// embedEach embeds texts one at a time using the legacy /api/embeddings endpoint
+func (e *OllamaEmbedder) embedEach(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		var resp ollamaEmbeddingsResponse
+		status, err := e.post(ctx, "/api/embeddings", ollamaEmbeddingsRequest{Model: e.config.ModelName, Prompt: text}, &resp)
+		if err != nil {
+			return nil, err
+		}
+		if status != http.StatusOK {
+			return nil, fmt.Errorf("ollama API returned status code: %d", status)
+		}
+		vectors[i] = resp.Embedding
+	}
+	return vectors, nil
+}
+
+// post sends a JSON request to Ollama and decodes a successful response into out
+func (e *OllamaEmbedder) post(ctx context.Context, path string, body interface{}, out interface{}) (int, error) {
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", ollamaBaseURL(e.config)+path, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to make request to Ollama: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return 0, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return resp.StatusCode, nil
+}
+
+func (e *OllamaEmbedder) Dimensions() int {
+	return e.dimensions
+}
+
+func (e *OllamaEmbedder) GetName() string {
+	return fmt.Sprintf("ollama-%s", e.config.ModelName)
+}
+
+// HashEmbedder is a local, deterministic embedder based on feature hashing of words.
+// It needs no network access, which makes it a stand-in for tests and offline use; it
+// captures lexical overlap rather than meaning.
+type HashEmbedder struct {
+	dimensions int
+}
+
+// NewHashEmbedder creates a hash embedder producing vectors of the given size
+func NewHashEmbedder(dimensions int) *HashEmbedder {
+	if dimensions <= 0 {
+		dimensions = 256
+	}
+	return &HashEmbedder{dimensions: dimensions}
+}
+
+func (e *HashEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vector := make([]float32, e.dimensions)
+		words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+		})
+		for _, word := range words {
+			h := fnv.New32a()
+			h.Write([]byte(word))
+			sum := h.Sum32()
+			// The top bit picks the sign so unrelated words cancel out instead of piling up
+			if sum&(1<<31) != 0 {
+				vector[sum%uint32(e.dimensions)]--
+			} else {
+				vector[sum%uint32(e.dimensions)]++
+			}
+		}
+		normalize(vector)
+		vectors[i] = vector
+	}
+	return vectors, nil
+}
+
+func (e *HashEmbedder) Dimensions() int {
+	return e.dimensions
+}
+
+func (e *HashEmbedder) GetName() string {
+	return fmt.Sprintf("hash-%d", e.dimensions)
+}
+
+// normalize scales a vector to unit length in place
+func normalize(vector []float32) {
+	var norm float64
+	for _, v := range vector {
+		norm += float64(v) * float64(v)
+	}
+	if norm == 0 {
+		return
+	}
+	norm = math.Sqrt(norm)
+	for i := range vector {
+		vector[i] = float32(float64(vector[i]) / norm)
+	}
+}
@@ -0,0 +1,186 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// testTexts returns n distinct texts, more than fit in one embedding batch when n is large
+func testTexts(n int) []string {
+	texts := make([]string, n)
+	for i := range texts {
+		texts[i] = fmt.Sprintf("text %d", i)
+	}
+	return texts
+}
+
+func TestHashEmbedder(t *testing.T) {
+	embedder := NewHashEmbedder(64)
+	texts := []string{"Read the config file", "read the CONFIG file!", "deploy to production", ""}
+
+	first, err := embedder.Embed(context.Background(), texts)
+	if err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	second, err := embedder.Embed(context.Background(), texts)
+	if err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Error("vectors differ between calls")
+	}
+	for i, vector := range first {
+		if len(vector) != embedder.Dimensions() {
+			t.Errorf("vector %d has %d dimensions, want %d", i, len(vector), embedder.Dimensions())
+		}
+	}
+
+	// Case and punctuation are ignored, so the first two texts embed identically
+	if sim := CosineSimilarity(first[0], first[1]); sim < 0.999 {
+		t.Errorf("similarity of equal words = %f", sim)
+	}
+	if sim := CosineSimilarity(first[0], first[2]); sim > 0.5 {
+		t.Errorf("similarity of unrelated texts = %f", sim)
+	}
+	if sim := CosineSimilarity(first[0], first[3]); sim != 0 {
+		t.Errorf("similarity with an empty text = %f", sim)
+	}
+
+	if got := NewHashEmbedder(0).Dimensions(); got != 256 {
+		t.Errorf("default dimensions = %d", got)
+	}
+}
+
+func TestOpenAIEmbedderBatches(t *testing.T) {
+	var batches []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/embeddings" {
+			t.Errorf("path = %q", r.URL.Path)
+		}
+		var request struct {
+			Input []string `json:"input"`
+			Model string   `json:"model"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+		if request.Model != "text-embedding-3-small" {
+			t.Errorf("model = %q", request.Model)
+		}
+		batches = append(batches, len(request.Input))
+
+		// Answer in reverse order; the index puts each vector back in place
+		type embedding struct {
+			Object    string    `json:"object"`
+			Embedding []float32 `json:"embedding"`
+			Index     int       `json:"index"`
+		}
+		data := make([]embedding, 0, len(request.Input))
+		for i := len(request.Input) - 1; i >= 0; i-- {
+			var n float32
+			fmt.Sscanf(request.Input[i], "text %g", &n)
+			data = append(data, embedding{Object: "embedding", Embedding: []float32{n}, Index: i})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"object": "list", "data": data})
+	}))
+	t.Cleanup(server.Close)
+
+	embedder, err := NewOpenAIEmbedder(ModelConfig{APIKey: "test-key", BaseURL: server.URL})
+	if err != nil {
+		t.Fatalf("NewOpenAIEmbedder: %v", err)
+	}
+	vectors, err := embedder.Embed(context.Background(), testTexts(embeddingBatchSize+4))
+	if err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+
+	if want := []int{embeddingBatchSize, 4}; !reflect.DeepEqual(batches, want) {
+		t.Errorf("batch sizes = %v, want %v", batches, want)
+	}
+	for i, vector := range vectors {
+		if len(vector) != 1 || vector[0] != float32(i) {
+			t.Fatalf("vector %d = %v", i, vector)
+		}
+	}
+	if embedder.Dimensions() != 1536 {
+		t.Errorf("dimensions = %d", embedder.Dimensions())
+	}
+}
+
+func TestOllamaEmbedderBatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		legacy   bool // The server only has the per-text /api/embeddings endpoint
+		requests int
+	}{
+		{name: "batch endpoint", requests: 2},
+		{name: "legacy endpoint", legacy: true, requests: 2 + embeddingBatchSize + 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				switch {
+				case r.URL.Path == "/api/embed" && !tt.legacy:
+					var request ollamaEmbedRequest
+					if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+						t.Errorf("failed to decode request: %v", err)
+					}
+					if len(request.Input) > embeddingBatchSize {
+						t.Errorf("batch of %d texts", len(request.Input))
+					}
+					resp := ollamaEmbedResponse{}
+					for _, text := range request.Input {
+						resp.Embeddings = append(resp.Embeddings, []float32{float32(len(text)), 0, 0})
+					}
+					json.NewEncoder(w).Encode(resp)
+				case r.URL.Path == "/api/embeddings" && tt.legacy:
+					var request ollamaEmbeddingsRequest
+					if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+						t.Errorf("failed to decode request: %v", err)
+					}
+					json.NewEncoder(w).Encode(ollamaEmbeddingsResponse{Embedding: []float32{float32(len(request.Prompt)), 0, 0}})
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			t.Cleanup(server.Close)
+
+			embedder, err := NewOllamaEmbedder(ModelConfig{ModelName: "nomic-embed-text:latest", BaseURL: server.URL})
+			if err != nil {
+				t.Fatalf("NewOllamaEmbedder: %v", err)
+			}
+			if embedder.Dimensions() != 768 {
+				t.Errorf("dimensions before embedding = %d", embedder.Dimensions())
+			}
+
+			texts := testTexts(embeddingBatchSize + 4)
+			vectors, err := embedder.Embed(context.Background(), texts)
+			if err != nil {
+				t.Fatalf("Embed: %v", err)
+			}
+			if len(vectors) != len(texts) {
+				t.Fatalf("got %d vectors for %d texts", len(vectors), len(texts))
+			}
+			for i, vector := range vectors {
+				if vector[0] != float32(len(texts[i])) {
+					t.Fatalf("vector %d = %v", i, vector)
+				}
+			}
+			// The legacy server answers 404 to each batch before the texts are sent one by one
+			if requests != tt.requests {
+				t.Errorf("%d requests, want %d", requests, tt.requests)
+			}
+			// The vectors returned by the server set the dimensions
+			if embedder.Dimensions() != 3 {
+				t.Errorf("dimensions after embedding = %d", embedder.Dimensions())
+			}
+		})
+	}
+}
@@ -0,0 +1,405 @@
+package models
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"llm-agent/pkg/tools"
+)
+
+// defaultGeminiURL is the address of the Gemini API
+const defaultGeminiURL = "https://generativelanguage.googleapis.com"
+
+// GeminiModel uses the Gemini generateContent API
+type GeminiModel struct {
+	config ModelConfig
+	client *http.Client
+	tools  []geminiTool
+}
+
+type geminiRequest struct {
+	Contents          []geminiContent         `json:"contents"`
+	SystemInstruction *geminiContent          `json:"systemInstruction,omitempty"`
+	Tools             []geminiTool            `json:"tools,omitempty"`
+	ToolConfig        *geminiToolConfig       `json:"toolConfig,omitempty"`
+	GenerationConfig  *geminiGenerationConfig `json:"generationConfig,omitempty"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiPart struct {
+	Text         string              `json:"text,omitempty"`
+	Thought      bool                `json:"thought,omitempty"`
+	InlineData   *geminiInlineData   `json:"inlineData,omitempty"`
+	FunctionCall *geminiFunctionCall `json:"functionCall,omitempty"`
+}
+
+type geminiInlineData struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+type geminiFunctionCall struct {
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args"`
+}
+
+type geminiTool struct {
+	FunctionDeclarations []geminiFunctionDeclaration `json:"functionDeclarations"`
+}
+
+type geminiFunctionDeclaration struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Parameters  map[string]interface{} `json:"parameters"`
+}
+
+type geminiToolConfig struct {
+	FunctionCallingConfig struct {
+		Mode string `json:"mode"`
+	} `json:"functionCallingConfig"`
+}
+
+type geminiGenerationConfig struct {
+	Temperature      *float64              `json:"temperature,omitempty"`
+	TopP             float64               `json:"topP,omitempty"`
+	TopK             int                   `json:"topK,omitempty"`
+	MaxOutputTokens  int                   `json:"maxOutputTokens,omitempty"`
+	StopSequences    []string              `json:"stopSequences,omitempty"`
+	Seed             *int                  `json:"seed,omitempty"`
+	PresencePenalty  float64               `json:"presencePenalty,omitempty"`
+	FrequencyPenalty float64               `json:"frequencyPenalty,omitempty"`
+	ResponseMimeType string                `json:"responseMimeType,omitempty"`
+	ResponseSchema   interface{}           `json:"responseSchema,omitempty"`
+	ThinkingConfig   *geminiThinkingConfig `json:"thinkingConfig,omitempty"`
+}
+
+type geminiThinkingConfig struct {
+	ThinkingBudget  int  `json:"thinkingBudget"`
+	IncludeThoughts bool `json:"includeThoughts"`
+}
+
+type geminiResponse struct {
+	Candidates []struct {
+		Content geminiContent `json:"content"`
+	} `json:"candidates"`
+	UsageMetadata struct {
+		PromptTokenCount        int64 `json:"promptTokenCount"`
+		CandidatesTokenCount    int64 `json:"candidatesTokenCount"`
+		ThoughtsTokenCount      int64 `json:"thoughtsTokenCount"`
+		CachedContentTokenCount int64 `json:"cachedContentTokenCount"`
+	} `json:"usageMetadata"`
+}
+
+func NewGeminiModel(config ModelConfig) (*GeminiModel, error) {
+	if config.APIKey == "" {
+		return nil, fmt.Errorf("API key is required for Gemini model")
+	}
+	if config.ModelName == "" {
+		config.ModelName = "gemini-2.0-flash"
+	}
+
+	warnUnsupported("gemini", config, ParamTopP, ParamTopK, ParamStop, ParamSeed, ParamPresencePenalty, ParamFrequencyPenalty, ParamThinkingBudget)
+
+	return &GeminiModel{
+		config: config,
+		client: &http.Client{},
+	}, nil
+}
+
+func (m *GeminiModel) SetTools(tools []tools.Tool) error {
+	if len(tools) == 0 {
+		m.tools = nil
+		return nil
+	}
+
+	declarations := make([]geminiFunctionDeclaration, len(tools))
+	for i, tool := range tools {
+		parameters, err := toolFunctionParameters(tool.GetInputSchema())
+		if err != nil {
+			return err
+		}
+		declarations[i] = geminiFunctionDeclaration{
+			Name:        tool.GetName(),
+			Description: tool.GetDescription(),
+			Parameters:  parameters,
+		}
+	}
+	m.tools = []geminiTool{{FunctionDeclarations: declarations}}
+	return nil
+}
+
+// newRequest builds a generateContent request for the given messages from the model config
+func (m *GeminiModel) newRequest(messages []Message) geminiRequest {
+	system, contents := toGeminiContents(messages)
+	temperature := m.config.Temperature
+	request := geminiRequest{
+		Contents:          contents,
+		SystemInstruction: system,
+		Tools:             m.tools,
+		GenerationConfig: &geminiGenerationConfig{
+			Temperature:      &temperature,
+			TopP:             m.config.TopP,
+			TopK:             m.config.TopK,
+			MaxOutputTokens:  m.config.MaxTokens,
+			StopSequences:    m.config.StopSequences,
+			Seed:             m.config.Seed,
+			PresencePenalty:  m.config.PresencePenalty,
+			FrequencyPenalty: m.config.FrequencyPenalty,
+		},
+	}
+	if m.config.ThinkingBudget > 0 {
+		request.GenerationConfig.ThinkingConfig = &geminiThinkingConfig{
+			ThinkingBudget:  m.config.ThinkingBudget,
+			IncludeThoughts: true,
+		}
+	}
+	return request
+}
+
+// url returns the address of a model method, e.g. generateContent
+func (m *GeminiModel) url(method string) string {
+	baseURL := defaultGeminiURL
+	if m.config.BaseURL != "" {
+		baseURL = strings.TrimSuffix(m.config.BaseURL, "/")
+	}
+	return fmt.Sprintf("%s/v1beta/models/%s:%s", baseURL, m.config.ModelName, method)
+}
+
+// generate sends a non-streaming generateContent request
+func (m *GeminiModel) generate(ctx context.Context, request geminiRequest) (*geminiResponse, error) {
+	resp, err := postJSON(ctx, m.client, m.url("generateContent"), map[string]string{"x-goog-api-key": m.config.APIKey}, request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate content: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var geminiResp geminiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&geminiResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &geminiResp, nil
+}
+
+func (m *GeminiModel) GenerateResponse(ctx context.Context, messages []Message) (*Response, error) {
+	if err := checkVision(m, messages); err != nil {
+		return nil, err
+	}
+
+	geminiResp, err := m.generate(ctx, m.newRequest(messages))
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Response{Usage: geminiUsage(geminiResp)}
+	for _, chunk := range geminiChunks(geminiResp) {
+		result.Content += chunk.Content
+		result.Reasoning += chunk.Reasoning
+	}
+	return result, nil
+}
+
+func (m *GeminiModel) StreamResponse(ctx context.Context, messages []Message, onChunk func(chunk Chunk) error) (*Response, error) {
+	if err := checkVision(m, messages); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	resp, err := postJSON(ctx, m.client, m.url("streamGenerateContent?alt=sse"), map[string]string{"x-goog-api-key": m.config.APIKey}, m.newRequest(messages))
+	if err != nil {
+		return nil, fmt.Errorf("failed to stream content: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result Response
+	var metrics Metrics
+	err = readSSE(resp.Body, func(data []byte) error {
+		var geminiResp geminiResponse
+		if err := json.Unmarshal(data, &geminiResp); err != nil {
+			return fmt.Errorf("failed to decode stream event: %w", err)
+		}
+		// Every event carries the usage so far, the last one is complete
+		if usage := geminiUsage(&geminiResp); usage.InputTokens > 0 || usage.OutputTokens > 0 {
+			result.Usage = usage
+		}
+		for _, chunk := range geminiChunks(&geminiResp) {
+			if metrics.TimeToFirstToken == 0 {
+				metrics.TimeToFirstToken = time.Since(start)
+			}
+			result.Content += chunk.Content
+			result.Reasoning += chunk.Reasoning
+			if err := onChunk(chunk); err != nil {
+				return fmt.Errorf("error processing chunk: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	metrics.TotalDuration = time.Since(start)
+	metrics.TokensPerSecond = tokensPerSecond(result.Usage.OutputTokens, metrics.TotalDuration-metrics.TimeToFirstToken)
+	result.Metrics = metrics
+	return &result, nil
+}
+
+// GenerateStructured constrains the response to the schema with a JSON response MIME type
+func (m *GeminiModel) GenerateStructured(ctx context.Context, messages []Message, schema json.RawMessage) (*Response, error) {
+	if err := checkVision(m, messages); err != nil {
+		return nil, err
+	}
+
+	var responseSchema interface{}
+	if err := json.Unmarshal(schema, &responseSchema); err != nil {
+		return nil, fmt.Errorf("failed to parse schema: %w", err)
+	}
+
+	request := m.newRequest(messages)
+	request.Tools = nil
+	request.GenerationConfig.ResponseMimeType = "application/json"
+	request.GenerationConfig.ResponseSchema = geminiSchema(responseSchema)
+
+	geminiResp, err := m.generate(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Response{Usage: geminiUsage(geminiResp)}
+	for _, chunk := range geminiChunks(geminiResp) {
+		result.Content += chunk.Content
+	}
+	return result, nil
+}
+
+// geminiSchema removes the JSON schema keywords that Gemini's OpenAPI schema subset rejects
+func geminiSchema(schema interface{}) interface{} {
+	switch value := schema.(type) {
+	case map[string]interface{}:
+		cleaned := make(map[string]interface{}, len(value))
+		for key, child := range value {
+			switch key {
+			case "$schema", "$id", "additionalProperties":
+				continue
+			}
+			cleaned[key] = geminiSchema(child)
+		}
+		return cleaned
+	case []interface{}:
+		cleaned := make([]interface{}, len(value))
+		for i, child := range value {
+			cleaned[i] = geminiSchema(child)
+		}
+		return cleaned
+	default:
+		return schema
+	}
+}
+
+// geminiChunks converts the parts of the first candidate to chunks. Function calls are
+// formatted like Claude's tool calls.
+func geminiChunks(resp *geminiResponse) []Chunk {
+	if len(resp.Candidates) == 0 {
+		return nil
+	}
+	var chunks []Chunk
+	for _, part := range resp.Candidates[0].Content.Parts {
+		switch {
+		case part.FunctionCall != nil:
+			args := string(part.FunctionCall.Args)
+			if args == "" {
+				args = "{}"
+			}
+			chunks = append(chunks, Chunk{Content: formatToolCall(part.FunctionCall.Name, args)})
+		case part.Thought && part.Text != "":
+			chunks = append(chunks, Chunk{Reasoning: part.Text})
+		case part.Text != "":
+			chunks = append(chunks, Chunk{Content: part.Text})
+		}
+	}
+	return chunks
+}
+
+// geminiUsage converts Gemini's usage metadata; thinking tokens are billed as output
+func geminiUsage(resp *geminiResponse) Usage {
+	usage := resp.UsageMetadata
+	return Usage{
+		InputTokens:          usage.PromptTokenCount - usage.CachedContentTokenCount,
+		OutputTokens:         usage.CandidatesTokenCount + usage.ThoughtsTokenCount,
+		CacheReadInputTokens: usage.CachedContentTokenCount,
+	}
+}
+
+// toGeminiContents converts our messages to Gemini's format. System messages become the
+// system instruction and assistant messages use the model role.
+func toGeminiContents(messages []Message) (*geminiContent, []geminiContent) {
+	var system *geminiContent
+	var contents []geminiContent
+	for _, msg := range messages {
+		if msg.Role == "system" {
+			if system == nil {
+				system = &geminiContent{}
+			}
+			system.Parts = append(system.Parts, geminiPart{Text: msg.Text()})
+			continue
+		}
+
+		role := "user"
+		if msg.Role == "assistant" {
+			role = "model"
+		}
+		content := geminiContent{Role: role}
+		if text := msg.Text(); text != "" {
+			content.Parts = append(content.Parts, geminiPart{Text: text})
+		}
+		for _, image := range msg.Images() {
+			content.Parts = append(content.Parts, geminiPart{InlineData: &geminiInlineData{
+				MimeType: image.MIMEType,
+				Data:     base64.StdEncoding.EncodeToString(image.Data),
+			}})
+		}
+		if len(content.Parts) > 0 {
+			contents = append(contents, content)
+		}
+	}
+	return system, contents
+}
+
+func (m *GeminiModel) GetName() string {
+	return fmt.Sprintf("gemini-%s", m.config.ModelName)
+}
+
+func (m *GeminiModel) GetMaxTokens() int {
+	return m.config.MaxTokens
+}
+
+func (m *GeminiModel) GetContextWindow() int {
+	if m.config.ContextWindow > 0 {
+		return m.config.ContextWindow
+	}
+	return defaultGeminiContextWindow
+}
+
+func (m *GeminiModel) SupportsVision() bool {
+	// Every Gemini model accepts images
+	return true
+}
+
+func (m *GeminiModel) Capabilities() Capabilities {
+	return Capabilities{
+		NativeTools:    true,
+		ParallelTools:  true,
+		Vision:         true,
+		StreamingTools: true,
+		SystemRole:     true,
+		JSONMode:       true,
+	}
+}
@@ -0,0 +1,173 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newGeminiTestModel returns a Gemini model that talks to a stand-in server running handler
+func newGeminiTestModel(t *testing.T, handler http.HandlerFunc) *GeminiModel {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	model, err := NewGeminiModel(ModelConfig{
+		APIKey:    "test-key",
+		ModelName: "gemini-test",
+		BaseURL:   server.URL,
+		MaxTokens: 1024,
+	})
+	if err != nil {
+		t.Fatalf("NewGeminiModel: %v", err)
+	}
+	return model
+}
+
+func TestGeminiGenerateResponse(t *testing.T) {
+	model := newGeminiTestModel(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1beta/models/gemini-test:generateContent" {
+			t.Errorf("path = %q", r.URL.Path)
+		}
+		if key := r.Header.Get("x-goog-api-key"); key != "test-key" {
+			t.Errorf("API key header = %q", key)
+		}
+		var request geminiRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+		if request.SystemInstruction == nil || request.SystemInstruction.Parts[0].Text != "Be brief." {
+			t.Errorf("system instruction = %+v", request.SystemInstruction)
+		}
+		if len(request.Contents) != 1 || request.Contents[0].Role != "user" {
+			t.Errorf("contents = %+v", request.Contents)
+		}
+
+		fmt.Fprint(w, `{
+			"candidates": [{"content": {"role": "model", "parts": [
+				{"text": "Thinking it over.", "thought": true},
+				{"text": "Hello"},
+				{"text": " there"}
+			]}}],
+			"usageMetadata": {"promptTokenCount": 12, "candidatesTokenCount": 5, "thoughtsTokenCount": 3}
+		}`)
+	})
+
+	resp, err := model.GenerateResponse(context.Background(), []Message{
+		{Role: "system", Content: "Be brief."},
+		{Role: "user", Content: "Hi"},
+	})
+	if err != nil {
+		t.Fatalf("GenerateResponse: %v", err)
+	}
+	if resp.Content != "Hello there" {
+		t.Errorf("content = %q", resp.Content)
+	}
+	if resp.Reasoning != "Thinking it over." {
+		t.Errorf("reasoning = %q", resp.Reasoning)
+	}
+	if want := (Usage{InputTokens: 12, OutputTokens: 8}); resp.Usage != want {
+		t.Errorf("usage = %+v, want %+v", resp.Usage, want)
+	}
+}
+
+func TestGeminiStreamResponseToolCall(t *testing.T) {
+	events := []string{
+		`{"candidates": [{"content": {"role": "model", "parts": [{"text": "Let me check"}]}}], "usageMetadata": {"promptTokenCount": 20, "candidatesTokenCount": 2}}`,
+		`{"candidates": [{"content": {"role": "model", "parts": [{"text": " the file."}]}}], "usageMetadata": {"promptTokenCount": 20, "candidatesTokenCount": 4}}`,
+		`{"candidates": [{"content": {"role": "model", "parts": [{"functionCall": {"name": "read_file", "args": {"path": "go.mod"}}}]}}], "usageMetadata": {"promptTokenCount": 20, "candidatesTokenCount": 9}}`,
+	}
+	model := newGeminiTestModel(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1beta/models/gemini-test:streamGenerateContent" || r.URL.Query().Get("alt") != "sse" {
+			t.Errorf("URL = %q", r.URL)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range events {
+			fmt.Fprintf(w, "data: %s\r\n\r\n", event)
+		}
+	})
+
+	var chunks []string
+	resp, err := model.StreamResponse(context.Background(), []Message{{Role: "user", Content: "What is the module name?"}}, func(chunk Chunk) error {
+		chunks = append(chunks, chunk.Content)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamResponse: %v", err)
+	}
+
+	toolCall := formatToolCall("read_file", `{"path": "go.mod"}`)
+	if want := "Let me check the file." + toolCall; resp.Content != want {
+		t.Errorf("content = %q, want %q", resp.Content, want)
+	}
+	if len(chunks) != 3 || chunks[2] != toolCall {
+		t.Errorf("chunks = %q", chunks)
+	}
+	if want := (Usage{InputTokens: 20, OutputTokens: 9}); resp.Usage != want {
+		t.Errorf("usage = %+v, want %+v", resp.Usage, want)
+	}
+	if resp.Metrics.TimeToFirstToken <= 0 || resp.Metrics.TotalDuration < resp.Metrics.TimeToFirstToken {
+		t.Errorf("metrics = %+v", resp.Metrics)
+	}
+}
+
+func TestGeminiUsage(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata string
+		want     Usage
+	}{
+		{
+			name:     "plain",
+			metadata: `{"promptTokenCount": 100, "candidatesTokenCount": 20}`,
+			want:     Usage{InputTokens: 100, OutputTokens: 20},
+		},
+		{
+			name:     "cached input is counted separately",
+			metadata: `{"promptTokenCount": 100, "candidatesTokenCount": 20, "cachedContentTokenCount": 60}`,
+			want:     Usage{InputTokens: 40, OutputTokens: 20, CacheReadInputTokens: 60},
+		},
+		{
+			name:     "thoughts are billed as output",
+			metadata: `{"promptTokenCount": 100, "candidatesTokenCount": 20, "thoughtsTokenCount": 50}`,
+			want:     Usage{InputTokens: 100, OutputTokens: 70},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp geminiResponse
+			if err := json.Unmarshal([]byte(`{"usageMetadata": `+tt.metadata+`}`), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if got := geminiUsage(&resp); got != tt.want {
+				t.Errorf("geminiUsage = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGeminiErrorStatus(t *testing.T) {
+	model := newGeminiTestModel(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error": {"code": 400, "message": "API key not valid", "status": "INVALID_ARGUMENT"}}`)
+	})
+
+	_, err := model.GenerateResponse(context.Background(), []Message{{Role: "user", Content: "Hi"}})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{"failed to generate content", "status code 400", "API key not valid"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+
+	_, err = model.StreamResponse(context.Background(), []Message{{Role: "user", Content: "Hi"}}, func(Chunk) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "API key not valid") {
+		t.Errorf("stream error = %v", err)
+	}
+}
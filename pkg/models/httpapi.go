@@ -0,0 +1,101 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// postJSON sends a JSON request to an HTTP API and returns the response if it succeeded.
+// Failed responses are turned into an error that includes the start of the response body.
+func postJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, body interface{}) (*http.Response, error) {
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("API returned status code %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return resp, nil
+}
+
+// readSSE reads a server-sent events stream and calls onData with the data of each event
+// until the stream ends or sends [DONE]
+func readSSE(body io.Reader, onData func(data []byte) error) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	var data []byte
+	flush := func() error {
+		if len(data) == 0 {
+			return nil
+		}
+		event := data
+		data = nil
+		return onData(event)
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if err := flush(); err != nil {
+				return err
+			}
+			continue
+		}
+		value, ok := strings.CutPrefix(line, "data:")
+		if !ok {
+			continue // Comments, event names and ids are not used
+		}
+		value = strings.TrimPrefix(value, " ")
+		if value == "[DONE]" {
+			return flush()
+		}
+		if len(data) > 0 {
+			data = append(data, '\n')
+		}
+		data = append(data, value...)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read event stream: %w", err)
+	}
+	return flush()
+}
+
+// toolFunctionParameters returns a tool's input schema as a plain object schema with only the
+// type, properties and required fields, which every provider accepts
+func toolFunctionParameters(raw json.RawMessage) (map[string]interface{}, error) {
+	var schema map[string]interface{}
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		return nil, fmt.Errorf("failed to parse tool schema: %w", err)
+	}
+	parameters := map[string]interface{}{
+		"type":       "object",
+		"properties": schema["properties"],
+	}
+	if required, ok := schema["required"].([]interface{}); ok {
+		parameters["required"] = required
+	}
+	return parameters, nil
+}
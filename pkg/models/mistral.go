@@ -0,0 +1,421 @@
+package models
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"llm-agent/pkg/tools"
+)
+
+// defaultMistralURL is the address of the Mistral API
+const defaultMistralURL = "https://api.mistral.ai"
+
+// MistralModel uses the Mistral chat completions API
+type MistralModel struct {
+	config ModelConfig
+	client *http.Client
+	tools  []mistralTool
+}
+
+type mistralRequest struct {
+	Model            string                 `json:"model"`
+	Messages         []mistralMessage       `json:"messages"`
+	Temperature      *float64               `json:"temperature,omitempty"`
+	TopP             float64                `json:"top_p,omitempty"`
+	MaxTokens        int                    `json:"max_tokens,omitempty"`
+	Stop             []string               `json:"stop,omitempty"`
+	RandomSeed       *int                   `json:"random_seed,omitempty"`
+	PresencePenalty  float64                `json:"presence_penalty,omitempty"`
+	FrequencyPenalty float64                `json:"frequency_penalty,omitempty"`
+	Tools            []mistralTool          `json:"tools,omitempty"`
+	ResponseFormat   *mistralResponseFormat `json:"response_format,omitempty"`
+	Stream           bool                   `json:"stream"`
+}
+
+// mistralMessage is a request message; content is a string or a list of parts with images
+type mistralMessage struct {
+	Role    string      `json:"role"`
+	Content interface{} `json:"content"`
+}
+
+type mistralContentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+}
+
+type mistralTool struct {
+	Type     string          `json:"type"`
+	Function mistralFunction `json:"function"`
+}
+
+type mistralFunction struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Parameters  map[string]interface{} `json:"parameters"`
+}
+
+type mistralResponseFormat struct {
+	Type       string `json:"type"`
+	JSONSchema struct {
+		Name   string          `json:"name"`
+		Schema json.RawMessage `json:"schema"`
+		Strict bool            `json:"strict"`
+	} `json:"json_schema"`
+}
+
+type mistralToolCall struct {
+	ID       string `json:"id"`
+	Index    *int   `json:"index,omitempty"`
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+// mistralResponse is a chat completion or one chunk of a streamed completion
+type mistralResponse struct {
+	Choices []struct {
+		Message *mistralResponseMessage `json:"message"`
+		Delta   *mistralResponseMessage `json:"delta"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int64 `json:"prompt_tokens"`
+		CompletionTokens int64 `json:"completion_tokens"`
+	} `json:"usage"`
+}
+
+type mistralResponseMessage struct {
+	Content   json.RawMessage   `json:"content"`
+	ToolCalls []mistralToolCall `json:"tool_calls"`
+}
+
+func NewMistralModel(config ModelConfig) (*MistralModel, error) {
+	if config.APIKey == "" {
+		return nil, fmt.Errorf("API key is required for Mistral model")
+	}
+	if config.ModelName == "" {
+		config.ModelName = "mistral-large-latest"
+	}
+
+	warnUnsupported("mistral", config, ParamTopP, ParamStop, ParamSeed, ParamPresencePenalty, ParamFrequencyPenalty)
+
+	return &MistralModel{
+		config: config,
+		client: &http.Client{},
+	}, nil
+}
+
+func (m *MistralModel) SetTools(tools []tools.Tool) error {
+	mistralTools := make([]mistralTool, len(tools))
+	for i, tool := range tools {
+		parameters, err := toolFunctionParameters(tool.GetInputSchema())
+		if err != nil {
+			return err
+		}
+		mistralTools[i] = mistralTool{
+			Type: "function",
+			Function: mistralFunction{
+				Name:        tool.GetName(),
+				Description: tool.GetDescription(),
+				Parameters:  parameters,
+			},
+		}
+	}
+	m.tools = mistralTools
+	return nil
+}
+
+// newRequest builds a chat completion request for the given messages from the model config
+func (m *MistralModel) newRequest(messages []Message) mistralRequest {
+	temperature := m.config.Temperature
+	return mistralRequest{
+		Model:            m.config.ModelName,
+		Messages:         toMistralMessages(messages),
+		Temperature:      &temperature,
+		TopP:             m.config.TopP,
+		MaxTokens:        m.config.MaxTokens,
+		Stop:             m.config.StopSequences,
+		RandomSeed:       m.config.Seed,
+		PresencePenalty:  m.config.PresencePenalty,
+		FrequencyPenalty: m.config.FrequencyPenalty,
+		Tools:            m.tools,
+	}
+}
+
+func (m *MistralModel) url() string {
+	baseURL := defaultMistralURL
+	if m.config.BaseURL != "" {
+		baseURL = strings.TrimSuffix(m.config.BaseURL, "/")
+	}
+	return baseURL + "/v1/chat/completions"
+}
+
+func (m *MistralModel) headers() map[string]string {
+	return map[string]string{"Authorization": "Bearer " + m.config.APIKey}
+}
+
+// complete sends a non-streaming chat completion request
+func (m *MistralModel) complete(ctx context.Context, request mistralRequest) (*Response, error) {
+	resp, err := postJSON(ctx, m.client, m.url(), m.headers(), request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create chat completion: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var mistralResp mistralResponse
+	if err := json.NewDecoder(resp.Body).Decode(&mistralResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if len(mistralResp.Choices) == 0 || mistralResp.Choices[0].Message == nil {
+		return nil, fmt.Errorf("chat completion returned no choices")
+	}
+
+	result := &Response{}
+	message := mistralResp.Choices[0].Message
+	for _, chunk := range mistralChunks(message.Content) {
+		result.Content += chunk.Content
+		result.Reasoning += chunk.Reasoning
+	}
+	for _, toolCall := range message.ToolCalls {
+		result.Content += formatToolCall(toolCall.Function.Name, mistralArguments(toolCall.Function.Arguments))
+	}
+	if mistralResp.Usage != nil {
+		result.Usage = Usage{
+			InputTokens:  mistralResp.Usage.PromptTokens,
+			OutputTokens: mistralResp.Usage.CompletionTokens,
+		}
+	}
+	return result, nil
+}
+
+func (m *MistralModel) GenerateResponse(ctx context.Context, messages []Message) (*Response, error) {
+	if err := checkVision(m, messages); err != nil {
+		return nil, err
+	}
+	return m.complete(ctx, m.newRequest(messages))
+}
+
+func (m *MistralModel) StreamResponse(ctx context.Context, messages []Message, onChunk func(chunk Chunk) error) (*Response, error) {
+	if err := checkVision(m, messages); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	request := m.newRequest(messages)
+	request.Stream = true
+	resp, err := postJSON(ctx, m.client, m.url(), m.headers(), request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create chat completion stream: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result Response
+	var metrics Metrics
+	var toolCalls []mistralToolCall
+	var usageReported bool
+	err = readSSE(resp.Body, func(data []byte) error {
+		var event mistralResponse
+		if err := json.Unmarshal(data, &event); err != nil {
+			return fmt.Errorf("failed to decode stream event: %w", err)
+		}
+		// The final chunk carries the usage
+		if event.Usage != nil {
+			result.Usage = Usage{
+				InputTokens:  event.Usage.PromptTokens,
+				OutputTokens: event.Usage.CompletionTokens,
+			}
+			usageReported = true
+		}
+		if len(event.Choices) == 0 || event.Choices[0].Delta == nil {
+			return nil
+		}
+
+		delta := event.Choices[0].Delta
+		toolCalls = accumulateMistralToolCalls(toolCalls, delta.ToolCalls)
+		for _, chunk := range mistralChunks(delta.Content) {
+			if metrics.TimeToFirstToken == 0 {
+				metrics.TimeToFirstToken = time.Since(start)
+			}
+			result.Content += chunk.Content
+			result.Reasoning += chunk.Reasoning
+			if err := onChunk(chunk); err != nil {
+				return fmt.Errorf("error processing chunk: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Tool calls are emitted once the stream is complete, like the other providers
+	for _, toolCall := range toolCalls {
+		toolChunk := formatToolCall(toolCall.Function.Name, mistralArguments(toolCall.Function.Arguments))
+		if metrics.TimeToFirstToken == 0 {
+			metrics.TimeToFirstToken = time.Since(start)
+		}
+		result.Content += toolChunk
+		if err := onChunk(Chunk{Content: toolChunk}); err != nil {
+			return nil, fmt.Errorf("error processing tool call: %w", err)
+		}
+	}
+
+	if !usageReported {
+		result.Usage = Usage{
+			InputTokens:  int64(EstimateTokens(messages)),
+			OutputTokens: int64(EstimateTokens([]Message{{Content: result.Reasoning + result.Content}})),
+		}
+	}
+	metrics.TotalDuration = time.Since(start)
+	metrics.TokensPerSecond = tokensPerSecond(result.Usage.OutputTokens, metrics.TotalDuration-metrics.TimeToFirstToken)
+	result.Metrics = metrics
+	return &result, nil
+}
+
+// accumulateMistralToolCalls merges streamed tool calls. Mistral usually sends each call whole,
+// but arguments may also arrive in fragments of the call with the same index.
+func accumulateMistralToolCalls(calls []mistralToolCall, deltas []mistralToolCall) []mistralToolCall {
+	for _, delta := range deltas {
+		index := len(calls)
+		if delta.Index != nil {
+			index = *delta.Index
+		}
+		for len(calls) <= index {
+			calls = append(calls, mistralToolCall{})
+		}
+		if delta.ID != "" {
+			calls[index].ID = delta.ID
+		}
+		calls[index].Function.Name += delta.Function.Name
+		calls[index].Function.Arguments = append(calls[index].Function.Arguments, []byte(mistralArguments(delta.Function.Arguments))...)
+	}
+	return calls
+}
+
+// mistralArguments returns tool call arguments as JSON text; Mistral sends them either as a
+// JSON encoded string or as an object
+func mistralArguments(raw json.RawMessage) string {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text
+	}
+	return string(raw)
+}
+
+// mistralChunks converts message content to chunks. Content is a string, or for reasoning
+// models a list of text and thinking parts.
+func mistralChunks(raw json.RawMessage) []Chunk {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		if text == "" {
+			return nil
+		}
+		return []Chunk{{Content: text}}
+	}
+
+	var parts []struct {
+		Type     string `json:"type"`
+		Text     string `json:"text"`
+		Thinking []struct {
+			Text string `json:"text"`
+		} `json:"thinking"`
+	}
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return nil
+	}
+	var chunks []Chunk
+	for _, part := range parts {
+		switch part.Type {
+		case "thinking":
+			for _, thought := range part.Thinking {
+				if thought.Text != "" {
+					chunks = append(chunks, Chunk{Reasoning: thought.Text})
+				}
+			}
+		case "text":
+			if part.Text != "" {
+				chunks = append(chunks, Chunk{Content: part.Text})
+			}
+		}
+	}
+	return chunks
+}
+
+// GenerateStructured requests a JSON response constrained by the schema using response_format
+func (m *MistralModel) GenerateStructured(ctx context.Context, messages []Message, schema json.RawMessage) (*Response, error) {
+	if err := checkVision(m, messages); err != nil {
+		return nil, err
+	}
+
+	request := m.newRequest(messages)
+	request.Tools = nil
+	request.ResponseFormat = &mistralResponseFormat{Type: "json_schema"}
+	request.ResponseFormat.JSONSchema.Name = structuredOutputName
+	request.ResponseFormat.JSONSchema.Schema = schema
+	return m.complete(ctx, request)
+}
+
+// toMistralMessages converts our messages to Mistral's format, mapping unknown roles to user
+func toMistralMessages(messages []Message) []mistralMessage {
+	mistralMessages := make([]mistralMessage, len(messages))
+	for i, msg := range messages {
+		role := msg.Role
+		if role != "user" && role != "assistant" && role != "system" {
+			role = "user"
+		}
+		if !msg.HasImages() {
+			mistralMessages[i] = mistralMessage{Role: role, Content: msg.Text()}
+			continue
+		}
+
+		// Images are sent as data URLs alongside the text
+		parts := []mistralContentPart{{Type: "text", Text: msg.Text()}}
+		for _, image := range msg.Images() {
+			parts = append(parts, mistralContentPart{
+				Type:     "image_url",
+				ImageURL: fmt.Sprintf("data:%s;base64,%s", image.MIMEType, base64.StdEncoding.EncodeToString(image.Data)),
+			})
+		}
+		mistralMessages[i] = mistralMessage{Role: role, Content: parts}
+	}
+	return mistralMessages
+}
+
+func (m *MistralModel) GetName() string {
+	return fmt.Sprintf("mistral-%s", m.config.ModelName)
+}
+
+func (m *MistralModel) GetMaxTokens() int {
+	return m.config.MaxTokens
+}
+
+func (m *MistralModel) GetContextWindow() int {
+	if m.config.ContextWindow > 0 {
+		return m.config.ContextWindow
+	}
+	return defaultMistralContextWindow
+}
+
+func (m *MistralModel) SupportsVision() bool {
+	return hasAnyPrefix(m.config.ModelName, "pixtral", "mistral-medium", "mistral-small")
+}
+
+func (m *MistralModel) Capabilities() Capabilities {
+	return Capabilities{
+		NativeTools:    true,
+		ParallelTools:  true,
+		Vision:         m.SupportsVision(),
+		StreamingTools: true,
+		SystemRole:     true,
+		JSONMode:       true,
+	}
+}
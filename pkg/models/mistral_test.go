@@ -0,0 +1,162 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newMistralTestModel returns a Mistral model that talks to a stand-in server running handler
+func newMistralTestModel(t *testing.T, handler http.HandlerFunc) *MistralModel {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	model, err := NewMistralModel(ModelConfig{
+		APIKey:    "test-key",
+		ModelName: "mistral-test",
+		BaseURL:   server.URL,
+		MaxTokens: 1024,
+	})
+	if err != nil {
+		t.Fatalf("NewMistralModel: %v", err)
+	}
+	return model
+}
+
+func TestMistralGenerateResponse(t *testing.T) {
+	model := newMistralTestModel(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("path = %q", r.URL.Path)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Bearer test-key" {
+			t.Errorf("Authorization header = %q", auth)
+		}
+		var request mistralRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+		if request.Model != "mistral-test" || request.Stream || len(request.Messages) != 2 {
+			t.Errorf("request = %+v", request)
+		}
+
+		fmt.Fprint(w, `{
+			"choices": [{"message": {
+				"role": "assistant",
+				"content": [
+					{"type": "thinking", "thinking": [{"type": "text", "text": "The user wants a file."}]},
+					{"type": "text", "text": "Reading it."}
+				],
+				"tool_calls": [{"id": "call_1", "function": {"name": "read_file", "arguments": "{\"path\": \"go.mod\"}"}}]
+			}}],
+			"usage": {"prompt_tokens": 30, "completion_tokens": 12}
+		}`)
+	})
+
+	resp, err := model.GenerateResponse(context.Background(), []Message{
+		{Role: "system", Content: "Use tools."},
+		{Role: "user", Content: "Show go.mod"},
+	})
+	if err != nil {
+		t.Fatalf("GenerateResponse: %v", err)
+	}
+	if want := "Reading it." + formatToolCall("read_file", `{"path": "go.mod"}`); resp.Content != want {
+		t.Errorf("content = %q, want %q", resp.Content, want)
+	}
+	if resp.Reasoning != "The user wants a file." {
+		t.Errorf("reasoning = %q", resp.Reasoning)
+	}
+	if want := (Usage{InputTokens: 30, OutputTokens: 12}); resp.Usage != want {
+		t.Errorf("usage = %+v, want %+v", resp.Usage, want)
+	}
+}
+
+func TestMistralStreamResponseToolCall(t *testing.T) {
+	// The arguments of the tool call arrive in two fragments with the same index
+	events := []string{
+		`{"choices": [{"delta": {"role": "assistant", "content": "Let me check"}}]}`,
+		`{"choices": [{"delta": {"content": " the file."}}]}`,
+		`{"choices": [{"delta": {"tool_calls": [{"id": "call_1", "index": 0, "function": {"name": "read_file", "arguments": "{\"path\": "}}]}}]}`,
+		`{"choices": [{"delta": {"tool_calls": [{"index": 0, "function": {"name": "", "arguments": "\"go.mod\"}"}}]}}]}`,
+		`{"choices": [], "usage": {"prompt_tokens": 25, "completion_tokens": 15}}`,
+		`[DONE]`,
+	}
+	model := newMistralTestModel(t, func(w http.ResponseWriter, r *http.Request) {
+		var request mistralRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+		if !request.Stream {
+			t.Error("stream is not set")
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range events {
+			fmt.Fprintf(w, "data: %s\n\n", event)
+		}
+	})
+
+	var chunks []string
+	resp, err := model.StreamResponse(context.Background(), []Message{{Role: "user", Content: "What is the module name?"}}, func(chunk Chunk) error {
+		chunks = append(chunks, chunk.Content)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamResponse: %v", err)
+	}
+
+	toolCall := formatToolCall("read_file", `{"path": "go.mod"}`)
+	if want := "Let me check the file." + toolCall; resp.Content != want {
+		t.Errorf("content = %q, want %q", resp.Content, want)
+	}
+	if len(chunks) != 3 || chunks[2] != toolCall {
+		t.Errorf("chunks = %q", chunks)
+	}
+	if want := (Usage{InputTokens: 25, OutputTokens: 15}); resp.Usage != want {
+		t.Errorf("usage = %+v, want %+v", resp.Usage, want)
+	}
+}
+
+func TestMistralArguments(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{name: "JSON string", raw: `"{\"path\": \"go.mod\"}"`, want: `{"path": "go.mod"}`},
+		{name: "object", raw: `{"path": "go.mod"}`, want: `{"path": "go.mod"}`},
+		{name: "string fragment", raw: `"{\"path\": "`, want: `{"path": `},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mistralArguments(json.RawMessage(tt.raw)); got != tt.want {
+				t.Errorf("mistralArguments(%s) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMistralErrorStatus(t *testing.T) {
+	model := newMistralTestModel(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"message": "Unauthorized", "request_id": "abc"}`)
+	})
+
+	_, err := model.GenerateResponse(context.Background(), []Message{{Role: "user", Content: "Hi"}})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{"failed to create chat completion", "status code 401", "Unauthorized"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+
+	_, err = model.StreamResponse(context.Background(), []Message{{Role: "user", Content: "Hi"}}, func(Chunk) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "status code 401") {
+		t.Errorf("stream error = %v", err)
+	}
+}
@@ -2,33 +2,159 @@ package models
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"llm-agent/pkg/tools"
+	"os"
+	"strings"
+	"time"
 )
 
 // Message represents a chat message
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role    string        `json:"role"`
+	Content string        `json:"content"`
+	Parts   []ContentPart `json:"parts,omitempty"` // Additional multimodal content (images, file references)
+
+	// Reasoning the model produced before an assistant message. It is never sent back as
+	// content; ReasoningBlocks are returned to providers that require them.
+	Reasoning       string           `json:"reasoning,omitempty"`
+	ReasoningBlocks []ReasoningBlock `json:"reasoning_blocks,omitempty"`
+}
+
+// ToolResultPrefix starts the user messages that carry tool results rather than prompts
+const ToolResultPrefix = "<result>"
+
+// IsPrompt reports whether the message is a prompt typed by the user
+func IsPrompt(msg Message) bool {
+	return msg.Role == "user" && !strings.HasPrefix(msg.Content, ToolResultPrefix)
 }
 
-// Usage represents token usage statistics
+// Usage represents token usage statistics. InputTokens excludes the input tokens written to
+// or read from the provider's prompt cache, which are counted separately.
 type Usage struct {
-	InputTokens  int64 `json:"input_tokens"`
-	OutputTokens int64 `json:"output_tokens"`
+	InputTokens              int64   `json:"input_tokens"`
+	OutputTokens             int64   `json:"output_tokens"`
+	CacheCreationInputTokens int64   `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int64   `json:"cache_read_input_tokens,omitempty"`
+	Cost                     float64 `json:"cost,omitempty"` // Cost in US dollars
+}
+
+// Metrics holds latency and throughput measurements of a single response
+type Metrics struct {
+	TimeToFirstToken time.Duration `json:"time_to_first_token_ns,omitempty"`
+	TotalDuration    time.Duration `json:"total_duration_ns,omitempty"`
+	TokensPerSecond  float64       `json:"tokens_per_second,omitempty"`
+
+	// Durations reported by Ollama
+	LoadDuration       time.Duration `json:"load_duration_ns,omitempty"`
+	PromptEvalDuration time.Duration `json:"prompt_eval_duration_ns,omitempty"`
+	EvalDuration       time.Duration `json:"eval_duration_ns,omitempty"`
 }
 
 // Response represents a model's response
 type Response struct {
-	Content string `json:"content"`
-	Usage   Usage  `json:"usage"`
+	Model           string           `json:"model,omitempty"` // Model that served the response when routed, see RouterModel
+	Content         string           `json:"content"`
+	Reasoning       string           `json:"reasoning,omitempty"`
+	ReasoningBlocks []ReasoningBlock `json:"reasoning_blocks,omitempty"`
+	Usage           Usage            `json:"usage"`
+	Metrics         Metrics          `json:"metrics"`
+
+	// Classifier is the response of the routing classifier asked before this call, whose
+	// usage is not included in Usage; nil when the route was chosen without it
+	Classifier *Response `json:"classifier,omitempty"`
 }
 
-// ModelConfig contains configuration for a model
+// Chunk is a piece of a streamed response. Exactly one of Content and Reasoning is set;
+// reasoning chunks are the model's thinking and are not part of the response content.
+type Chunk struct {
+	Content   string
+	Reasoning string
+}
+
+// ModelConfig contains configuration for a model. Generation parameters left at their zero
+// value are not sent, so the provider default applies.
 type ModelConfig struct {
-	APIKey      string
-	ModelName   string
-	MaxTokens   int
-	Temperature float64
+	APIKey        string  `json:"-"`
+	ModelName     string  `json:"model_name,omitempty"`
+	BaseURL       string  `json:"base_url,omitempty"` // API address, e.g. of a local stand-in server; empty uses the provider default
+	MaxTokens     int     `json:"max_tokens,omitempty"`
+	Temperature   float64 `json:"temperature,omitempty"`
+	ContextWindow int     `json:"context_window,omitempty"` // Size of the model's context window in tokens, 0 uses the provider default
+
+	TopP             float64  `json:"top_p,omitempty"`
+	TopK             int      `json:"top_k,omitempty"`
+	StopSequences    []string `json:"stop,omitempty"`
+	Seed             *int     `json:"seed,omitempty"`
+	PresencePenalty  float64  `json:"presence_penalty,omitempty"`
+	FrequencyPenalty float64  `json:"frequency_penalty,omitempty"`
+	NumCtx           int      `json:"num_ctx,omitempty"`         // Ollama context length
+	KeepAlive        string   `json:"keep_alive,omitempty"`      // Ollama model keep-alive, e.g. "10m"
+	ThinkingBudget   int      `json:"thinking_budget,omitempty"` // Reasoning budget in tokens, 0 disables reasoning
+}
+
+// Generation parameter names used in unsupported parameter warnings
+const (
+	ParamTopP             = "top_p"
+	ParamTopK             = "top_k"
+	ParamStop             = "stop"
+	ParamSeed             = "seed"
+	ParamPresencePenalty  = "presence_penalty"
+	ParamFrequencyPenalty = "frequency_penalty"
+	ParamNumCtx           = "num_ctx"
+	ParamKeepAlive        = "keep_alive"
+	ParamThinkingBudget   = "thinking_budget"
+)
+
+// setParameters returns the names of the optional generation parameters set in the config
+func (c ModelConfig) setParameters() []string {
+	var params []string
+	if c.TopP != 0 {
+		params = append(params, ParamTopP)
+	}
+	if c.TopK != 0 {
+		params = append(params, ParamTopK)
+	}
+	if len(c.StopSequences) > 0 {
+		params = append(params, ParamStop)
+	}
+	if c.Seed != nil {
+		params = append(params, ParamSeed)
+	}
+	if c.PresencePenalty != 0 {
+		params = append(params, ParamPresencePenalty)
+	}
+	if c.FrequencyPenalty != 0 {
+		params = append(params, ParamFrequencyPenalty)
+	}
+	if c.NumCtx != 0 {
+		params = append(params, ParamNumCtx)
+	}
+	if c.KeepAlive != "" {
+		params = append(params, ParamKeepAlive)
+	}
+	if c.ThinkingBudget != 0 {
+		params = append(params, ParamThinkingBudget)
+	}
+	return params
+}
+
+// warnUnsupported prints a warning for each generation parameter in the config that the
+// provider does not support; those parameters are ignored
+func warnUnsupported(provider string, config ModelConfig, supported ...string) {
+	for _, param := range config.setParameters() {
+		isSupported := false
+		for _, s := range supported {
+			if s == param {
+				isSupported = true
+				break
+			}
+		}
+		if !isSupported {
+			fmt.Fprintf(os.Stderr, "Warning: %s does not support the %s parameter, ignoring it\n", provider, param)
+		}
+	}
 }
 
 // Model defines the interface for different LLM models
@@ -36,8 +162,13 @@ type Model interface {
 	// GenerateResponse generates a complete response for the given messages
 	GenerateResponse(ctx context.Context, messages []Message) (*Response, error)
 
-	// StreamResponse streams the response for the given messages
-	StreamResponse(ctx context.Context, messages []Message, onChunk func(chunk string) error) error
+	// StreamResponse streams the response and reasoning for the given messages and returns
+	// the complete response with the token usage reported by the provider
+	StreamResponse(ctx context.Context, messages []Message, onChunk func(chunk Chunk) error) (*Response, error)
+
+	// GenerateStructured generates a response whose content is a JSON value matching the given
+	// JSON schema. Use GenerateInto or GenerateValidated to validate and decode the result.
+	GenerateStructured(ctx context.Context, messages []Message, schema json.RawMessage) (*Response, error)
 
 	// GetName returns the name of the model
 	GetName() string
@@ -45,6 +176,59 @@ type Model interface {
 	// GetMaxTokens returns the maximum number of tokens the model can generate
 	GetMaxTokens() int
 
+	// GetContextWindow returns the number of tokens the model can attend to
+	GetContextWindow() int
+
+	// SupportsVision reports whether the model accepts image input
+	SupportsVision() bool
+
+	// Capabilities reports the features the model supports
+	Capabilities() Capabilities
+
 	// SetTools sets the available tools for the model
 	SetTools(tools []tools.Tool) error
 }
+
+// Capabilities describes what a model supports, so callers can pick how to use it
+type Capabilities struct {
+	NativeTools    bool `json:"native_tools"`    // Accepts tool definitions and returns tool calls
+	ParallelTools  bool `json:"parallel_tools"`  // Can request several tool calls in one response
+	Vision         bool `json:"vision"`          // Accepts image input
+	StreamingTools bool `json:"streaming_tools"` // Returns tool calls from StreamResponse
+	SystemRole     bool `json:"system_role"`     // Accepts system messages
+	JSONMode       bool `json:"json_mode"`       // Can constrain its output to a JSON schema
+}
+
+// Default context window sizes used when ModelConfig.ContextWindow is not set
+const (
+	defaultClaudeContextWindow  = 200000
+	defaultChatGPTContextWindow = 16385
+	defaultOllamaContextWindow  = 4096
+	defaultGeminiContextWindow  = 1048576
+	defaultMistralContextWindow = 131072
+)
+
+// formatToolCall formats a native tool call in the readable form the agent parses
+func formatToolCall(name, arguments string) string {
+	return fmt.Sprintf("\n[Tool: %s]\nInput: %s\n", name, arguments)
+}
+
+// tokensPerSecond computes the generation throughput, returning 0 when it cannot be measured
+func tokensPerSecond(tokens int64, d time.Duration) float64 {
+	if tokens <= 0 || d <= 0 {
+		return 0
+	}
+	return float64(tokens) / d.Seconds()
+}
+
+// EstimateTokens provides a rough estimate of token count
+// This is a very basic implementation - in practice, you'd want to use a proper tokenizer
+func EstimateTokens(messages []Message) int {
+	total := 0
+	for _, msg := range messages {
+		// Rough estimate: 1 token ≈ 4 characters
+		total += len(msg.Text()) / 4
+		total += len(msg.Images()) * imageTokenEstimate
+	}
+	return total
+}
@@ -1,28 +1,39 @@
 package models
 
 import (
-	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
+	"time"
 
 	"llm-agent/pkg/tools"
 )
 
+// defaultOllamaURL is the address of the local Ollama server, used unless ModelConfig.BaseURL
+// or OLLAMA_HOST is set
+const defaultOllamaURL = "http://localhost:11434"
+
 type OllamaModel struct {
-	config ModelConfig
-	client *http.Client
-	tools  []tools.Tool
+	config        ModelConfig
+	client        *http.Client
+	tools         []tools.Tool
+	nativeTools   bool // Whether tool definitions are sent with requests
+	contextLength int  // Context length the model was trained with, read by Prepare; 0 if unknown
 }
 
 type ollamaRequest struct {
-	Model    string                 `json:"model"`
-	Messages []message              `json:"messages"`
-	Stream   bool                   `json:"stream"`
-	Tools    []toolParam            `json:"tools,omitempty"`
-	Options  map[string]interface{} `json:"options,omitempty"`
+	Model     string                 `json:"model"`
+	Messages  []message              `json:"messages"`
+	Stream    bool                   `json:"stream"`
+	Tools     []toolParam            `json:"tools,omitempty"`
+	Format    json.RawMessage        `json:"format,omitempty"`
+	Options   map[string]interface{} `json:"options,omitempty"`
+	KeepAlive string                 `json:"keep_alive,omitempty"`
+	Think     bool                   `json:"think,omitempty"`
 }
 
 type toolParam struct {
@@ -39,6 +50,8 @@ type functionParam struct {
 type message struct {
 	Role      string     `json:"role"`
 	Content   string     `json:"content"`
+	Images    []string   `json:"images,omitempty"` // Base64 encoded images for vision models
+	Thinking  string     `json:"thinking,omitempty"`
 	ToolCalls []toolCall `json:"tool_calls,omitempty"`
 }
 
@@ -54,69 +67,53 @@ type functionCall struct {
 }
 
 type ollamaResponse struct {
-	Model           string  `json:"model"`
-	Message         message `json:"message"`
-	Done            bool    `json:"done"`
-	TotalDuration   int64   `json:"total_duration"`
-	LoadDuration    int64   `json:"load_duration"`
-	PromptEvalCount int     `json:"prompt_eval_count"`
-	EvalCount       int     `json:"eval_count"`
-	EvalDuration    int64   `json:"eval_duration"`
+	Model              string  `json:"model"`
+	Message            message `json:"message"`
+	Done               bool    `json:"done"`
+	TotalDuration      int64   `json:"total_duration"`
+	LoadDuration       int64   `json:"load_duration"`
+	PromptEvalCount    int     `json:"prompt_eval_count"`
+	PromptEvalDuration int64   `json:"prompt_eval_duration"`
+	EvalCount          int     `json:"eval_count"`
+	EvalDuration       int64   `json:"eval_duration"`
 }
 
 func NewOllamaModel(config ModelConfig) (*OllamaModel, error) {
 	if config.ModelName == "" {
 		config.ModelName = "llama2" // default model
 	}
+	warnUnsupported("ollama", config, ParamTopP, ParamTopK, ParamStop, ParamSeed, ParamPresencePenalty, ParamFrequencyPenalty, ParamNumCtx, ParamKeepAlive, ParamThinkingBudget)
 
 	return &OllamaModel{
-		config: config,
-		client: &http.Client{},
+		config:      config,
+		client:      &http.Client{},
+		nativeTools: true,
 	}, nil
 }
 
 func (m *OllamaModel) GenerateResponse(ctx context.Context, messages []Message) (*Response, error) {
-	var fullResponse string
-	err := m.StreamResponse(ctx, messages, func(chunk string) error {
-		fullResponse += chunk
+	return m.StreamResponse(ctx, messages, func(chunk Chunk) error {
 		return nil
 	})
-	if err != nil {
+}
+
+func (m *OllamaModel) StreamResponse(ctx context.Context, messages []Message, onChunk func(chunk Chunk) error) (*Response, error) {
+	if err := checkVision(m, messages); err != nil {
 		return nil, err
 	}
 
-	// Estimate token usage (rough approximation)
-	inputTokens := estimateTokens(messages)
-	outputTokens := estimateTokens([]Message{{Content: fullResponse}})
-
-	return &Response{
-		Content: fullResponse,
-		Usage: Usage{
-			InputTokens:  int64(inputTokens),
-			OutputTokens: int64(outputTokens),
-		},
-	}, nil
-}
-
-func (m *OllamaModel) StreamResponse(ctx context.Context, messages []Message, onChunk func(chunk string) error) error {
 	// Convert our messages to Ollama format
-	ollamaMessages := make([]message, len(messages))
-	for i, msg := range messages {
-		ollamaMessages[i] = message{
-			Role:    msg.Role,
-			Content: msg.Content,
-		}
-	}
+	ollamaMessages := toOllamaMessages(messages)
 
 	// Convert tools to Ollama format
 	var ollamaTools []toolParam
-	if m.tools != nil {
+	if m.tools != nil && m.nativeTools {
 		ollamaTools = make([]toolParam, len(m.tools))
 		for i, tool := range m.tools {
 			// Parse the input schema
 			var schema map[string]interface{}
 			if err := json.Unmarshal(tool.GetInputSchema(), &schema); err != nil {
-				return fmt.Errorf("failed to parse tool schema: %w", err)
+				return nil, fmt.Errorf("failed to parse tool schema: %w", err)
 			}
 
 			// Create parameters object in Ollama's format
@@ -141,64 +138,130 @@ func (m *OllamaModel) StreamResponse(ctx context.Context, messages []Message, on
 
 	// Prepare request
 	reqBody := ollamaRequest{
-		Model:    m.config.ModelName,
-		Messages: ollamaMessages,
-		Stream:   true,
-		Tools:    ollamaTools,
-		Options: map[string]interface{}{
-			"temperature": m.config.Temperature,
-			"num_predict": m.config.MaxTokens,
-		},
+		Model:     m.config.ModelName,
+		Messages:  ollamaMessages,
+		Stream:    true,
+		Tools:     ollamaTools,
+		Options:   m.options(),
+		KeepAlive: m.config.KeepAlive,
+		// Ollama cannot limit the amount of thinking, any budget turns it on
+		Think: m.config.ThinkingBudget > 0,
 	}
 
-	jsonData, err := json.Marshal(reqBody)
-	if err != nil {
-		return fmt.Errorf("failed to marshal request: %w", err)
-	}
+	return m.chat(ctx, reqBody, messages, onChunk)
+}
 
-	//
+// GenerateStructured constrains the response to the schema using Ollama's format parameter
+func (m *OllamaModel) GenerateStructured(ctx context.Context, messages []Message, schema json.RawMessage) (*Response, error) {
+	if err := checkVision(m, messages); err != nil {
+		return nil, err
+	}
 
-	// Make request to Ollama
-	req, err := http.NewRequestWithContext(ctx, "POST", "http://localhost:11434/api/chat", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+	reqBody := ollamaRequest{
+		Model:     m.config.ModelName,
+		Messages:  toOllamaMessages(messages),
+		Stream:    true,
+		Format:    schema,
+		Options:   m.options(),
+		KeepAlive: m.config.KeepAlive,
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := m.client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to make request to Ollama: %w", err)
+	return m.chat(ctx, reqBody, messages, func(chunk Chunk) error {
+		return nil
+	})
+}
+
+// options returns the generation options sent with every request
+func (m *OllamaModel) options() map[string]interface{} {
+	options := map[string]interface{}{
+		"temperature": m.config.Temperature,
+		"num_predict": m.config.MaxTokens,
 	}
-	//
-	defer resp.Body.Close()
+	if m.config.TopP != 0 {
+		options["top_p"] = m.config.TopP
+	}
+	if m.config.TopK != 0 {
+		options["top_k"] = m.config.TopK
+	}
+	if len(m.config.StopSequences) > 0 {
+		options["stop"] = m.config.StopSequences
+	}
+	if m.config.Seed != nil {
+		options["seed"] = *m.config.Seed
+	}
+	if m.config.PresencePenalty != 0 {
+		options["presence_penalty"] = m.config.PresencePenalty
+	}
+	if m.config.FrequencyPenalty != 0 {
+		options["frequency_penalty"] = m.config.FrequencyPenalty
+	}
+	if m.config.NumCtx != 0 {
+		options["num_ctx"] = m.config.NumCtx
+	}
+	return options
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("ollama API returned status code: %d", resp.StatusCode)
+// toOllamaMessages converts our messages to Ollama's format
+func toOllamaMessages(messages []Message) []message {
+	ollamaMessages := make([]message, len(messages))
+	for i, msg := range messages {
+		ollamaMessages[i] = message{
+			Role:    msg.Role,
+			Content: msg.Text(),
+		}
+		for _, image := range msg.Images() {
+			ollamaMessages[i].Images = append(ollamaMessages[i].Images, base64.StdEncoding.EncodeToString(image.Data))
+		}
 	}
+	return ollamaMessages
+}
 
-	// Read the raw response for debugging
-	rawBody, err := io.ReadAll(resp.Body)
+// chat sends a chat request to Ollama and streams the response chunks to onChunk. Reasoning
+// arrives in the thinking field, or inside <think> tags for models that predate it.
+func (m *OllamaModel) chat(ctx context.Context, reqBody ollamaRequest, messages []Message, onChunk func(chunk Chunk) error) (*Response, error) {
+	start := time.Now()
+	resp, err := m.request(ctx, "POST", "/api/chat", reqBody)
 	if err != nil {
-		return fmt.Errorf("failed to read response body: %w", err)
+		return nil, err
 	}
+	defer resp.Body.Close()
 
-	// Create a new reader with the raw body
-	reader := bytes.NewReader(rawBody)
-	decoder := json.NewDecoder(reader)
-
+	// Decode the response as it streams in so time to first token can be measured
+	decoder := json.NewDecoder(resp.Body)
+
+	var content, reasoning string
+	var usage Usage
+	var metrics Metrics
+	var parser thinkParser
+	emit := func(chunks []Chunk) error {
+		for _, chunk := range chunks {
+			if metrics.TimeToFirstToken == 0 {
+				metrics.TimeToFirstToken = time.Since(start)
+			}
+			content += chunk.Content
+			reasoning += chunk.Reasoning
+			if err := onChunk(chunk); err != nil {
+				return fmt.Errorf("error processing chunk: %w", err)
+			}
+		}
+		return nil
+	}
 	for {
 		var ollamaResp ollamaResponse
 		if err := decoder.Decode(&ollamaResp); err != nil {
 			if err == io.EOF {
 				break
 			}
-			return fmt.Errorf("failed to decode response: %w", err)
+			return nil, fmt.Errorf("failed to decode response: %w", err)
 		}
 
 		// Handle tool calls if present
 		if len(ollamaResp.Message.ToolCalls) > 0 {
 			toolCall := ollamaResp.Message.ToolCalls[0]
 			// Format the tool call in our XML-like format
+			if metrics.TimeToFirstToken == 0 {
+				metrics.TimeToFirstToken = time.Since(start)
+			}
 			toolCallStr := fmt.Sprintf(`<tool>
 {
   "name": "%s",
@@ -206,23 +269,69 @@ func (m *OllamaModel) StreamResponse(ctx context.Context, messages []Message, on
 }
 </tool>`, toolCall.Function.Name, string(toolCall.Function.Arguments))
 
-			if err := onChunk(toolCallStr); err != nil {
-				return fmt.Errorf("error processing tool call: %w", err)
+			content += toolCallStr
+			if err := onChunk(Chunk{Content: toolCallStr}); err != nil {
+				return nil, fmt.Errorf("error processing tool call: %w", err)
+			}
+		} else {
+			if ollamaResp.Message.Thinking != "" {
+				if err := emit([]Chunk{{Reasoning: ollamaResp.Message.Thinking}}); err != nil {
+					return nil, err
+				}
 			}
-		} else if ollamaResp.Message.Content != "" {
 			// Handle regular message content
-
-			if err := onChunk(ollamaResp.Message.Content); err != nil {
-				return fmt.Errorf("error processing chunk: %w", err)
+			if ollamaResp.Message.Content != "" {
+				if err := emit(parser.feed(ollamaResp.Message.Content)); err != nil {
+					return nil, err
+				}
 			}
 		}
 
 		if ollamaResp.Done {
+			usage.InputTokens = int64(ollamaResp.PromptEvalCount)
+			usage.OutputTokens = int64(ollamaResp.EvalCount)
+			metrics.LoadDuration = time.Duration(ollamaResp.LoadDuration)
+			metrics.PromptEvalDuration = time.Duration(ollamaResp.PromptEvalDuration)
+			metrics.EvalDuration = time.Duration(ollamaResp.EvalDuration)
+			metrics.TokensPerSecond = tokensPerSecond(usage.OutputTokens, metrics.EvalDuration)
 			break
 		}
 	}
+	if err := emit(parser.flush()); err != nil {
+		return nil, err
+	}
 
-	return nil
+	// Fall back to estimates when Ollama does not report counts (e.g. cached prompts)
+	if usage.InputTokens == 0 {
+		usage.InputTokens = int64(EstimateTokens(messages))
+	}
+	if usage.OutputTokens == 0 {
+		usage.OutputTokens = int64(EstimateTokens([]Message{{Content: reasoning + content}}))
+	}
+
+	metrics.TotalDuration = time.Since(start)
+	if metrics.TokensPerSecond == 0 {
+		metrics.TokensPerSecond = tokensPerSecond(usage.OutputTokens, metrics.TotalDuration-metrics.TimeToFirstToken)
+	}
+
+	return &Response{
+		Content:   content,
+		Reasoning: reasoning,
+		Usage:     usage,
+		Metrics:   metrics,
+	}, nil
+}
+
+// Capabilities reports native tool support as found by Prepare; until Prepare has been
+// called the model is assumed to support tools
+func (m *OllamaModel) Capabilities() Capabilities {
+	return Capabilities{
+		NativeTools:    m.nativeTools,
+		Vision:         m.SupportsVision(),
+		StreamingTools: m.nativeTools,
+		SystemRole:     true,
+		JSONMode:       true,
+	}
 }
 
 func (m *OllamaModel) SetTools(tools []tools.Tool) error {
@@ -238,13 +347,27 @@ func (m *OllamaModel) GetMaxTokens() int {
 	return m.config.MaxTokens
 }
 
-// estimateTokens provides a rough estimate of token count
-// This is a very basic implementation - in practice, you'd want to use a proper tokenizer
-func estimateTokens(messages []Message) int {
-	total := 0
-	for _, msg := range messages {
-		// Rough estimate: 1 token ≈ 4 characters
-		total += len(msg.Content) / 4
+// GetContextWindow returns the configured context window, else the context Ollama allocates:
+// num_ctx or the server default, capped at the context length the model was trained with
+func (m *OllamaModel) GetContextWindow() int {
+	if m.config.ContextWindow > 0 {
+		return m.config.ContextWindow
+	}
+	window := defaultOllamaContextWindow
+	if m.config.NumCtx > 0 {
+		window = m.config.NumCtx
+	}
+	if m.contextLength > 0 && m.contextLength < window {
+		return m.contextLength
+	}
+	return window
+}
+
+func (m *OllamaModel) SupportsVision() bool {
+	for _, family := range []string{"llava", "bakllava", "vision", "moondream", "minicpm-v", "gemma3", "qwen2.5vl", "llama4"} {
+		if strings.Contains(m.config.ModelName, family) {
+			return true
+		}
 	}
-	return total
+	return false
 }
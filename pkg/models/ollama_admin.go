@@ -0,0 +1,244 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// OllamaModelDetails describes the format and size of an installed Ollama model
+type OllamaModelDetails struct {
+	Format            string `json:"format"`
+	Family            string `json:"family"`
+	ParameterSize     string `json:"parameter_size"`
+	QuantizationLevel string `json:"quantization_level"`
+}
+
+// OllamaModelInfo is an installed model as listed by /api/tags
+type OllamaModelInfo struct {
+	Name       string             `json:"name"`
+	Size       int64              `json:"size"`
+	Digest     string             `json:"digest"`
+	ModifiedAt time.Time          `json:"modified_at"`
+	Details    OllamaModelDetails `json:"details"`
+}
+
+// OllamaShowInfo is the information about a model returned by /api/show
+type OllamaShowInfo struct {
+	Details      OllamaModelDetails     `json:"details"`
+	Parameters   string                 `json:"parameters"`
+	Template     string                 `json:"template"`
+	ModelInfo    map[string]interface{} `json:"model_info"`
+	Capabilities []string               `json:"capabilities"`
+}
+
+// OllamaPullProgress is a progress update streamed while pulling a model
+type OllamaPullProgress struct {
+	Status    string `json:"status"`
+	Digest    string `json:"digest,omitempty"`
+	Total     int64  `json:"total,omitempty"`
+	Completed int64  `json:"completed,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ContextLength returns the maximum context length the model was trained with, or 0 if unknown
+func (s *OllamaShowInfo) ContextLength() int {
+	for key, value := range s.ModelInfo {
+		if strings.HasSuffix(key, ".context_length") {
+			if length, ok := value.(float64); ok {
+				return int(length)
+			}
+		}
+	}
+	return 0
+}
+
+// SupportsTools reports whether the model supports native tool calls. Servers that predate
+// the capabilities list are asked whether the prompt template renders tools.
+func (s *OllamaShowInfo) SupportsTools() bool {
+	if len(s.Capabilities) > 0 {
+		return s.HasCapability("tools")
+	}
+	return strings.Contains(s.Template, ".Tools")
+}
+
+// HasCapability reports whether the model lists the capability, e.g. "tools" or "vision"
+func (s *OllamaShowInfo) HasCapability(capability string) bool {
+	for _, c := range s.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// ollamaBaseURL returns the Ollama server address: the configured base URL, then the
+// OLLAMA_HOST environment variable, then the local default
+func ollamaBaseURL(config ModelConfig) string {
+	baseURL := config.BaseURL
+	if baseURL == "" {
+		baseURL = os.Getenv("OLLAMA_HOST")
+	}
+	if baseURL == "" {
+		return defaultOllamaURL
+	}
+	if !strings.Contains(baseURL, "://") {
+		baseURL = "http://" + baseURL
+	}
+	return strings.TrimSuffix(baseURL, "/")
+}
+
+// ollamaStatusError builds an error from a failed Ollama response, including the error
+// message from the response body when there is one
+func ollamaStatusError(resp *http.Response) error {
+	var body struct {
+		Error string `json:"error"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if json.Unmarshal(data, &body) == nil && body.Error != "" {
+		return fmt.Errorf("ollama API returned status code %d: %s", resp.StatusCode, body.Error)
+	}
+	return fmt.Errorf("ollama API returned status code: %d", resp.StatusCode)
+}
+
+// ollamaModelName adds the implicit :latest tag to a model name without a tag
+func ollamaModelName(name string) string {
+	if strings.Contains(name, ":") {
+		return name
+	}
+	return name + ":latest"
+}
+
+// request sends a request to the Ollama server and returns the response if it succeeded
+func (m *OllamaModel) request(ctx context.Context, method, path string, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		jsonData, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request: %w", err)
+		}
+		reader = bytes.NewReader(jsonData)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, ollamaBaseURL(m.config)+path, reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request to Ollama at %s: %w", ollamaBaseURL(m.config), err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, ollamaStatusError(resp)
+	}
+	return resp, nil
+}
+
+// ListModels returns the models installed on the Ollama server
+func (m *OllamaModel) ListModels(ctx context.Context) ([]OllamaModelInfo, error) {
+	resp, err := m.request(ctx, "GET", "/api/tags", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var tags struct {
+		Models []OllamaModelInfo `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, fmt.Errorf("failed to decode model list: %w", err)
+	}
+	return tags.Models, nil
+}
+
+// ShowModel returns the details, parameters and capabilities of an installed model
+func (m *OllamaModel) ShowModel(ctx context.Context, name string) (*OllamaShowInfo, error) {
+	resp, err := m.request(ctx, "POST", "/api/show", map[string]string{"model": name})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var info OllamaShowInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("failed to decode model info: %w", err)
+	}
+	return &info, nil
+}
+
+// PullModel downloads a model, reporting progress to onProgress as it streams in
+func (m *OllamaModel) PullModel(ctx context.Context, name string, onProgress func(progress OllamaPullProgress)) error {
+	resp, err := m.request(ctx, "POST", "/api/pull", map[string]interface{}{"model": name, "stream": true})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var progress OllamaPullProgress
+		if err := decoder.Decode(&progress); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("failed to decode pull progress: %w", err)
+		}
+		if progress.Error != "" {
+			return fmt.Errorf("failed to pull %s: %s", name, progress.Error)
+		}
+		if onProgress != nil {
+			onProgress(progress)
+		}
+	}
+}
+
+// HasModel reports whether the model is installed on the Ollama server
+func (m *OllamaModel) HasModel(ctx context.Context, name string) (bool, error) {
+	installed, err := m.ListModels(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, info := range installed {
+		if info.Name == ollamaModelName(name) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Prepare makes sure the configured model is ready to use. A model that is not installed is
+// pulled only when confirmPull agrees; otherwise an error is returned. Prepare then checks
+// whether the model supports native tool calls, and reads the context length it was trained
+// with. Models without tool support are sent no tool definitions, so tools are only available
+// through the XML prompt.
+func (m *OllamaModel) Prepare(ctx context.Context, confirmPull func(name string) bool, onProgress func(progress OllamaPullProgress)) (*OllamaShowInfo, error) {
+	installed, err := m.HasModel(ctx, m.config.ModelName)
+	if err != nil {
+		return nil, err
+	}
+	if !installed {
+		if !confirmPull(m.config.ModelName) {
+			return nil, fmt.Errorf("model %s is not installed", m.config.ModelName)
+		}
+		if err := m.PullModel(ctx, m.config.ModelName, onProgress); err != nil {
+			return nil, err
+		}
+	}
+
+	info, err := m.ShowModel(ctx, m.config.ModelName)
+	if err != nil {
+		return nil, err
+	}
+	m.nativeTools = info.SupportsTools()
+	m.contextLength = info.ContextLength()
+	return info, nil
+}
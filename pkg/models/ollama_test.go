@@ -0,0 +1,108 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newOllamaTestModel returns an Ollama model that talks to a stand-in server on which only
+// the installed models exist, and counts the pull requests it receives
+func newOllamaTestModel(t *testing.T, config ModelConfig, installed ...string) (*OllamaModel, *int) {
+	t.Helper()
+	pulls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			var models []string
+			for _, name := range installed {
+				models = append(models, fmt.Sprintf(`{"name": %q}`, name))
+			}
+			fmt.Fprintf(w, `{"models": [%s]}`, strings.Join(models, ","))
+		case "/api/pull":
+			pulls++
+			fmt.Fprint(w, `{"status": "pulling manifest"}`+"\n"+`{"status": "success"}`+"\n")
+		case "/api/show":
+			fmt.Fprint(w, `{"capabilities": ["completion", "tools"], "model_info": {"llama.context_length": 8192}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	config.BaseURL = server.URL
+	model, err := NewOllamaModel(config)
+	if err != nil {
+		t.Fatalf("NewOllamaModel: %v", err)
+	}
+	return model, &pulls
+}
+
+func TestOllamaPrepare(t *testing.T) {
+	tests := []struct {
+		name      string
+		installed []string
+		confirm   bool
+		asked     bool
+		pulls     int
+		err       string
+	}{
+		{name: "installed", installed: []string{"llama3.2:latest"}},
+		{name: "missing and declined", asked: true, err: "llama3.2 is not installed"},
+		{name: "missing and confirmed", confirm: true, asked: true, pulls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model, pulls := newOllamaTestModel(t, ModelConfig{ModelName: "llama3.2"}, tt.installed...)
+			asked := false
+			info, err := model.Prepare(context.Background(), func(name string) bool {
+				asked = true
+				return tt.confirm
+			}, nil)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Prepare error = %v, want %q", err, tt.err)
+				}
+			} else if err != nil {
+				t.Fatalf("Prepare: %v", err)
+			} else if !info.SupportsTools() || info.ContextLength() != 8192 {
+				t.Errorf("info = %+v", info)
+			}
+			if asked != tt.asked {
+				t.Errorf("asked = %v, want %v", asked, tt.asked)
+			}
+			if *pulls != tt.pulls {
+				t.Errorf("%d pulls, want %d", *pulls, tt.pulls)
+			}
+		})
+	}
+}
+
+func TestOllamaContextWindow(t *testing.T) {
+	tests := []struct {
+		name   string
+		config ModelConfig
+		want   int
+	}{
+		// The model was trained with 8192 tokens
+		{name: "server default", config: ModelConfig{}, want: defaultOllamaContextWindow},
+		{name: "num_ctx", config: ModelConfig{NumCtx: 6000}, want: 6000},
+		{name: "num_ctx above the trained length", config: ModelConfig{NumCtx: 32768}, want: 8192},
+		{name: "configured window", config: ModelConfig{NumCtx: 32768, ContextWindow: 16384}, want: 16384},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.ModelName = "llama3.2"
+			model, _ := newOllamaTestModel(t, tt.config, "llama3.2:latest")
+			if _, err := model.Prepare(context.Background(), func(string) bool { return false }, nil); err != nil {
+				t.Fatalf("Prepare: %v", err)
+			}
+			if got := model.GetContextWindow(); got != tt.want {
+				t.Errorf("GetContextWindow = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Price is the cost of a model in US dollars per million tokens. Cache prices default to the
+// Anthropic multipliers of the input price: 1.25x for writes and 0.1x for reads.
+type Price struct {
+	Input      float64 `json:"input"`
+	Output     float64 `json:"output"`
+	CacheWrite float64 `json:"cache_write,omitempty"`
+	CacheRead  float64 `json:"cache_read,omitempty"`
+}
+
+// PricingTable maps a provider (claude, chatgpt, gemini, mistral, ollama) to model name prefixes and their prices.
+// The special model name "*" matches any model of the provider.
+type PricingTable map[string]map[string]Price
+
+// DefaultPricing returns the built-in pricing table
+func DefaultPricing() PricingTable {
+	return PricingTable{
+		"claude": {
+			"claude-3-opus":     {Input: 15, Output: 75},
+			"claude-3-sonnet":   {Input: 3, Output: 15},
+			"claude-3-haiku":    {Input: 0.25, Output: 1.25},
+			"claude-3-5-sonnet": {Input: 3, Output: 15},
+			"claude-3-5-haiku":  {Input: 0.8, Output: 4},
+			"claude-3-7-sonnet": {Input: 3, Output: 15},
+		},
+		"chatgpt": {
+			"gpt-3.5-turbo": {Input: 0.5, Output: 1.5},
+			"gpt-4":         {Input: 30, Output: 60},
+			"gpt-4-turbo":   {Input: 10, Output: 30},
+			"gpt-4o":        {Input: 2.5, Output: 10},
+			"gpt-4o-mini":   {Input: 0.15, Output: 0.6},
+		},
+		"gemini": {
+			"gemini-1.5-flash": {Input: 0.075, Output: 0.3},
+			"gemini-1.5-pro":   {Input: 1.25, Output: 5},
+			"gemini-2.0-flash": {Input: 0.1, Output: 0.4},
+			"gemini-2.5-flash": {Input: 0.3, Output: 2.5},
+			"gemini-2.5-pro":   {Input: 1.25, Output: 10},
+		},
+		"mistral": {
+			"mistral-large":  {Input: 2, Output: 6},
+			"mistral-medium": {Input: 0.4, Output: 2},
+			"mistral-small":  {Input: 0.1, Output: 0.3},
+			"codestral":      {Input: 0.3, Output: 0.9},
+			"pixtral-large":  {Input: 2, Output: 6},
+			"magistral":      {Input: 2, Output: 5},
+		},
+		"ollama": {
+			"*": {Input: 0, Output: 0},
+		},
+	}
+}
+
+// LoadPricing reads a JSON pricing file and merges it over the default table
+func LoadPricing(path string) (PricingTable, error) {
+	table := DefaultPricing()
+	if path == "" {
+		return table, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pricing file: %w", err)
+	}
+
+	var overrides PricingTable
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("failed to parse pricing file: %w", err)
+	}
+
+	for provider, prices := range overrides {
+		if table[provider] == nil {
+			table[provider] = make(map[string]Price)
+		}
+		for model, price := range prices {
+			table[provider][model] = price
+		}
+	}
+	return table, nil
+}
+
+// Lookup returns the price for a model name as reported by Model.GetName (e.g. "chatgpt-gpt-4o").
+// The longest matching model prefix wins.
+func (t PricingTable) Lookup(name string) (Price, bool) {
+	provider, model, _ := strings.Cut(name, "-")
+	prices, ok := t[provider]
+	if !ok {
+		return Price{}, false
+	}
+
+	var best string
+	var found bool
+	for prefix := range prices {
+		if prefix == "*" || !strings.HasPrefix(model, prefix) {
+			continue
+		}
+		if len(prefix) > len(best) {
+			best = prefix
+			found = true
+		}
+	}
+	if found {
+		return prices[best], true
+	}
+
+	price, ok := prices["*"]
+	return price, ok
+}
+
+// Cost returns the cost in US dollars of the given usage for a model
+func (t PricingTable) Cost(name string, usage Usage) float64 {
+	price, ok := t.Lookup(name)
+	if !ok {
+		return 0
+	}
+
+	cacheWrite := price.CacheWrite
+	if cacheWrite == 0 {
+		cacheWrite = price.Input * 1.25
+	}
+	cacheRead := price.CacheRead
+	if cacheRead == 0 {
+		cacheRead = price.Input * 0.1
+	}
+	return (float64(usage.InputTokens)*price.Input +
+		float64(usage.OutputTokens)*price.Output +
+		float64(usage.CacheCreationInputTokens)*cacheWrite +
+		float64(usage.CacheReadInputTokens)*cacheRead) / 1e6
+}
@@ -0,0 +1,89 @@
+package models
+
+import "strings"
+
+// Tags that wrap the reasoning of models like DeepSeek-R1 in their text output
+const (
+	thinkOpenTag  = "<think>"
+	thinkCloseTag = "</think>"
+)
+
+// ReasoningBlock is a provider reasoning block that has to be sent back verbatim with the
+// assistant message it belongs to, such as a Claude thinking block and its signature
+type ReasoningBlock struct {
+	Text      string `json:"text,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	Redacted  string `json:"redacted,omitempty"` // Encrypted data of a redacted thinking block
+}
+
+// thinkParser splits streamed text into answer and reasoning chunks at <think> tags. Tags may
+// be split across chunks, so a trailing partial tag is held back until the next chunk.
+type thinkParser struct {
+	inThink bool
+	pending string
+}
+
+// feed parses the next piece of streamed text
+func (p *thinkParser) feed(text string) []Chunk {
+	text = p.pending + text
+	p.pending = ""
+
+	var chunks []Chunk
+	for text != "" {
+		tag := thinkOpenTag
+		if p.inThink {
+			tag = thinkCloseTag
+		}
+		if i := strings.Index(text, tag); i >= 0 {
+			chunks = p.emit(chunks, text[:i])
+			text = text[i+len(tag):]
+			p.inThink = !p.inThink
+			continue
+		}
+
+		keep := partialSuffix(text, tag)
+		chunks = p.emit(chunks, text[:len(text)-keep])
+		p.pending = text[len(text)-keep:]
+		break
+	}
+	return chunks
+}
+
+// flush returns the text held back at the end of the stream
+func (p *thinkParser) flush() []Chunk {
+	text := p.pending
+	p.pending = ""
+	return p.emit(nil, text)
+}
+
+func (p *thinkParser) emit(chunks []Chunk, text string) []Chunk {
+	if text == "" {
+		return chunks
+	}
+	if p.inThink {
+		return append(chunks, Chunk{Reasoning: text})
+	}
+	return append(chunks, Chunk{Content: text})
+}
+
+// partialSuffix returns the length of the longest suffix of text that is a prefix of tag
+func partialSuffix(text, tag string) int {
+	for n := len(tag) - 1; n > 0; n-- {
+		if strings.HasSuffix(text, tag[:n]) {
+			return n
+		}
+	}
+	return 0
+}
+
+// reasoningEffort maps a thinking budget in tokens to an OpenAI reasoning effort level
+func reasoningEffort(budget int) string {
+	switch {
+	case budget <= 2048:
+		return "low"
+	case budget <= 16384:
+		return "medium"
+	default:
+		return "high"
+	}
+}
@@ -0,0 +1,365 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"llm-agent/pkg/tools"
+	"os"
+	"strings"
+	"time"
+)
+
+// Route is a model the router can choose
+type Route struct {
+	Name        string // Name used in rules, the classifier and /model
+	Description string // What the model is good at, shown to the classifier
+	Model       Model
+}
+
+// RouteRule selects a route when all of its conditions match. Conditions left at their zero
+// value are ignored.
+type RouteRule struct {
+	Route           string   `json:"route"`
+	MinPromptTokens int      `json:"min_prompt_tokens,omitempty"` // Estimated tokens of the latest user prompt
+	MaxPromptTokens int      `json:"max_prompt_tokens,omitempty"`
+	Keywords        []string `json:"keywords,omitempty"`    // Any of them in the latest user prompt, case-insensitive
+	ToolResult      *bool    `json:"tool_result,omitempty"` // Whether the model is answering a tool result
+	Images          *bool    `json:"images,omitempty"`      // Whether the conversation contains images
+}
+
+// RouterModel chooses among several models for every request. The route is, in order of
+// precedence: the override set with SetOverride, the first matching rule, the answer of the
+// classifier model and finally the default route. Responses name the model that served them.
+// The classifier is asked once per user prompt; the follow-ups to tool results reuse its answer.
+type RouterModel struct {
+	routes       []Route
+	rules        []RouteRule
+	defaultRoute string
+	classifier   Model
+	override     string
+	classified   *classification // Answer of the classifier for the latest prompt
+	prepare      func(route *Route, messages []Message) []Message
+}
+
+// classification is the route the classifier chose for a prompt. It is keyed on the text of
+// the prompt alone, since compaction moves the prompt within the messages.
+type classification struct {
+	prompt string // Text of the prompt
+	route  *Route // nil when the classifier gave no usable answer
+}
+
+// NewRouterModel creates a router over the routes. The default route is used when no rule
+// matches and there is no classifier; it defaults to the first route.
+func NewRouterModel(routes []Route, rules []RouteRule, defaultRoute string) (*RouterModel, error) {
+	if len(routes) == 0 {
+		return nil, fmt.Errorf("router needs at least one route")
+	}
+	r := &RouterModel{routes: routes, rules: rules, defaultRoute: defaultRoute}
+	if r.defaultRoute == "" {
+		r.defaultRoute = routes[0].Name
+	}
+	if r.route(r.defaultRoute) == nil {
+		return nil, fmt.Errorf("unknown default route %q", r.defaultRoute)
+	}
+	for _, rule := range rules {
+		if r.route(rule.Route) == nil {
+			return nil, fmt.Errorf("rule refers to unknown route %q", rule.Route)
+		}
+	}
+	return r, nil
+}
+
+// SetClassifier sets a model that picks the route when no rule matches
+func (r *RouterModel) SetClassifier(classifier Model) {
+	r.classifier = classifier
+}
+
+// SetPrepare sets a function that adapts the messages to the route chosen for them before
+// they are sent, e.g. to show one route what is redacted for the others
+func (r *RouterModel) SetPrepare(prepare func(route *Route, messages []Message) []Message) {
+	r.prepare = prepare
+}
+
+// SetOverride forces every request to the named route; an empty name restores routing
+func (r *RouterModel) SetOverride(name string) error {
+	if name != "" && r.route(name) == nil {
+		return fmt.Errorf("unknown route %q", name)
+	}
+	r.override = name
+	return nil
+}
+
+// Override returns the route forced with SetOverride, empty when routing is automatic
+func (r *RouterModel) Override() string {
+	return r.override
+}
+
+// FixedModel returns the model for internal requests, such as history summaries, that are
+// not classified: the model of the override when one is set and of the default route otherwise
+func (r *RouterModel) FixedModel() Model {
+	if r.override != "" {
+		return r.route(r.override).Model
+	}
+	return r.route(r.defaultRoute).Model
+}
+
+// Routes returns the routes of the router
+func (r *RouterModel) Routes() []Route {
+	return r.routes
+}
+
+func (r *RouterModel) route(name string) *Route {
+	for i := range r.routes {
+		if r.routes[i].Name == name {
+			return &r.routes[i]
+		}
+	}
+	return nil
+}
+
+// Select returns the route for the messages, and the response of the classifier when it was
+// asked, so its usage can be accounted for
+func (r *RouterModel) Select(ctx context.Context, messages []Message) (*Route, *Response) {
+	selected, classifier := r.selectRoute(ctx, messages)
+
+	// Never send images to a model that cannot see them
+	if !selected.Model.SupportsVision() && hasImages(messages) {
+		for i := range r.routes {
+			if r.routes[i].Model.SupportsVision() {
+				return &r.routes[i], classifier
+			}
+		}
+	}
+	return selected, classifier
+}
+
+func (r *RouterModel) selectRoute(ctx context.Context, messages []Message) (*Route, *Response) {
+	if r.override != "" {
+		return r.route(r.override), nil
+	}
+	for _, rule := range r.rules {
+		if rule.matches(messages) {
+			return r.route(rule.Route), nil
+		}
+	}
+	if r.classifier == nil {
+		return r.route(r.defaultRoute), nil
+	}
+
+	// Ask the classifier once per prompt, not again for every tool result that follows it
+	prompt := latestPrompt(messages)
+	var resp *Response
+	if c := r.classified; c == nil || c.prompt != prompt {
+		var route *Route
+		route, resp = r.classify(ctx, prompt)
+		r.classified = &classification{prompt: prompt, route: route}
+	}
+	if r.classified.route != nil {
+		return r.classified.route, resp
+	}
+	return r.route(r.defaultRoute), resp
+}
+
+// matches reports whether all conditions of the rule hold for the messages
+func (rule RouteRule) matches(messages []Message) bool {
+	prompt := latestPrompt(messages)
+	tokens := EstimateTokens([]Message{{Content: prompt}})
+	if rule.MinPromptTokens > 0 && tokens < rule.MinPromptTokens {
+		return false
+	}
+	if rule.MaxPromptTokens > 0 && tokens > rule.MaxPromptTokens {
+		return false
+	}
+	if len(rule.Keywords) > 0 {
+		lower := strings.ToLower(prompt)
+		found := false
+		for _, keyword := range rule.Keywords {
+			if strings.Contains(lower, strings.ToLower(keyword)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if rule.ToolResult != nil && *rule.ToolResult != answeringToolResult(messages) {
+		return false
+	}
+	if rule.Images != nil && *rule.Images != hasImages(messages) {
+		return false
+	}
+	return true
+}
+
+// classify asks the classifier model which route suits the prompt. The route is nil, and a
+// warning is printed, when the classifier fails or names no known route.
+func (r *RouterModel) classify(ctx context.Context, request string) (*Route, *Response) {
+	names := make([]string, len(r.routes))
+	var descriptions strings.Builder
+	for i, route := range r.routes {
+		names[i] = route.Name
+		fmt.Fprintf(&descriptions, "- %s: %s\n", route.Name, route.Description)
+	}
+	schema, _ := json.Marshal(map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"route": map[string]interface{}{"type": "string", "enum": names},
+		},
+		"required": []string{"route"},
+	})
+
+	prompt := fmt.Sprintf("Choose the model that should handle the request below.\n\nModels:\n%s\nRequest:\n%s", descriptions.String(), request)
+	start := time.Now()
+	resp, err := r.classifier.GenerateStructured(ctx, []Message{{Role: "user", Content: prompt}}, schema)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: route classifier failed, using the %s route: %v\n", r.defaultRoute, err)
+		return nil, nil
+	}
+	if resp.Model == "" {
+		resp.Model = r.classifier.GetName()
+	}
+	if resp.Metrics.TotalDuration == 0 {
+		resp.Metrics.TotalDuration = time.Since(start)
+	}
+	var choice struct {
+		Route string `json:"route"`
+	}
+	if err := json.Unmarshal([]byte(resp.Content), &choice); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: route classifier gave an invalid answer, using the %s route: %v\n", r.defaultRoute, err)
+		return nil, resp
+	}
+	route := r.route(choice.Route)
+	if route == nil {
+		fmt.Fprintf(os.Stderr, "Warning: route classifier chose unknown route %q, using the %s route\n", choice.Route, r.defaultRoute)
+	}
+	return route, resp
+}
+
+// latestPrompt returns the text of the latest user message that is not a tool result
+func latestPrompt(messages []Message) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if IsPrompt(messages[i]) {
+			return messages[i].Text()
+		}
+	}
+	return ""
+}
+
+// answeringToolResult reports whether the last message is a tool result
+func answeringToolResult(messages []Message) bool {
+	if len(messages) == 0 {
+		return false
+	}
+	last := messages[len(messages)-1]
+	return last.Role == "user" && strings.HasPrefix(last.Content, ToolResultPrefix)
+}
+
+func hasImages(messages []Message) bool {
+	for _, msg := range messages {
+		if msg.HasImages() {
+			return true
+		}
+	}
+	return false
+}
+
+// served records the model that produced a response and the classifier call that chose it
+func served(resp *Response, model Model, classifier *Response, err error) (*Response, error) {
+	if resp != nil {
+		if resp.Model == "" {
+			resp.Model = model.GetName()
+		}
+		resp.Classifier = classifier
+	}
+	return resp, err
+}
+
+// selectPrepared returns the route for the messages and the messages prepared for it
+func (r *RouterModel) selectPrepared(ctx context.Context, messages []Message) (*Route, []Message, *Response) {
+	route, classifier := r.Select(ctx, messages)
+	if r.prepare != nil {
+		messages = r.prepare(route, messages)
+	}
+	return route, messages, classifier
+}
+
+func (r *RouterModel) GenerateResponse(ctx context.Context, messages []Message) (*Response, error) {
+	route, messages, classifier := r.selectPrepared(ctx, messages)
+	resp, err := route.Model.GenerateResponse(ctx, messages)
+	return served(resp, route.Model, classifier, err)
+}
+
+func (r *RouterModel) StreamResponse(ctx context.Context, messages []Message, onChunk func(chunk Chunk) error) (*Response, error) {
+	route, messages, classifier := r.selectPrepared(ctx, messages)
+	resp, err := route.Model.StreamResponse(ctx, messages, onChunk)
+	return served(resp, route.Model, classifier, err)
+}
+
+func (r *RouterModel) GenerateStructured(ctx context.Context, messages []Message, schema json.RawMessage) (*Response, error) {
+	route, messages, classifier := r.selectPrepared(ctx, messages)
+	resp, err := route.Model.GenerateStructured(ctx, messages, schema)
+	return served(resp, route.Model, classifier, err)
+}
+
+func (r *RouterModel) GetName() string {
+	return "router"
+}
+
+// GetMaxTokens returns the smallest output limit of the routes
+func (r *RouterModel) GetMaxTokens() int {
+	maxTokens := r.routes[0].Model.GetMaxTokens()
+	for _, route := range r.routes[1:] {
+		if tokens := route.Model.GetMaxTokens(); tokens < maxTokens {
+			maxTokens = tokens
+		}
+	}
+	return maxTokens
+}
+
+// GetContextWindow returns the smallest context window of the routes, so the history fits
+// whichever model is chosen
+func (r *RouterModel) GetContextWindow() int {
+	window := r.routes[0].Model.GetContextWindow()
+	for _, route := range r.routes[1:] {
+		if size := route.Model.GetContextWindow(); size < window {
+			window = size
+		}
+	}
+	return window
+}
+
+// SupportsVision reports whether any route accepts images; they are routed to one that does
+func (r *RouterModel) SupportsVision() bool {
+	for _, route := range r.routes {
+		if route.Model.SupportsVision() {
+			return true
+		}
+	}
+	return false
+}
+
+// Capabilities returns what every route supports, so the conversation works with any of them
+func (r *RouterModel) Capabilities() Capabilities {
+	caps := r.routes[0].Model.Capabilities()
+	for _, route := range r.routes[1:] {
+		other := route.Model.Capabilities()
+		caps.NativeTools = caps.NativeTools && other.NativeTools
+		caps.ParallelTools = caps.ParallelTools && other.ParallelTools
+		caps.StreamingTools = caps.StreamingTools && other.StreamingTools
+		caps.SystemRole = caps.SystemRole && other.SystemRole
+		caps.JSONMode = caps.JSONMode && other.JSONMode
+	}
+	caps.Vision = r.SupportsVision()
+	return caps
+}
+
+func (r *RouterModel) SetTools(tools []tools.Tool) error {
+	for _, route := range r.routes {
+		if err := route.Model.SetTools(tools); err != nil {
+			return fmt.Errorf("failed to set tools for route %s: %w", route.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"llm-agent/pkg/tools"
+)
+
+// fakeModel is a model that answers every request with fixed content and counts its calls
+type fakeModel struct {
+	name   string
+	answer string
+	vision bool
+	calls  int
+}
+
+func (m *fakeModel) GenerateResponse(ctx context.Context, messages []Message) (*Response, error) {
+	m.calls++
+	return &Response{Content: m.answer, Usage: Usage{InputTokens: 10, OutputTokens: 1}}, nil
+}
+
+func (m *fakeModel) StreamResponse(ctx context.Context, messages []Message, onChunk func(chunk Chunk) error) (*Response, error) {
+	return m.GenerateResponse(ctx, messages)
+}
+
+func (m *fakeModel) GenerateStructured(ctx context.Context, messages []Message, schema json.RawMessage) (*Response, error) {
+	return m.GenerateResponse(ctx, messages)
+}
+
+func (m *fakeModel) GetName() string                   { return m.name }
+func (m *fakeModel) GetMaxTokens() int                 { return 1024 }
+func (m *fakeModel) GetContextWindow() int             { return 8192 }
+func (m *fakeModel) SupportsVision() bool              { return m.vision }
+func (m *fakeModel) Capabilities() Capabilities        { return Capabilities{Vision: m.vision} }
+func (m *fakeModel) SetTools(tools []tools.Tool) error { return nil }
+
+// newTestRouter returns a router over a fast and a smart route whose classifier answers
+// with the given route name
+func newTestRouter(t *testing.T, rules []RouteRule, classifierRoute string) (*RouterModel, *fakeModel) {
+	t.Helper()
+	router, err := NewRouterModel([]Route{
+		{Name: "fast", Description: "Quick answers", Model: &fakeModel{name: "fast-model"}},
+		{Name: "smart", Description: "Hard problems", Model: &fakeModel{name: "smart-model"}},
+		{Name: "vision", Description: "Images", Model: &fakeModel{name: "vision-model", vision: true}},
+	}, rules, "fast")
+	if err != nil {
+		t.Fatalf("NewRouterModel: %v", err)
+	}
+	var classifier *fakeModel
+	if classifierRoute != "" {
+		classifier = &fakeModel{name: "classifier", answer: fmt.Sprintf(`{"route": %q}`, classifierRoute)}
+		router.SetClassifier(classifier)
+	}
+	return router, classifier
+}
+
+func TestRouterSelect(t *testing.T) {
+	yes := true
+	rules := []RouteRule{
+		{Route: "smart", Keywords: []string{"Refactor"}},
+		{Route: "fast", ToolResult: &yes},
+		{Route: "smart", MinPromptTokens: 50},
+	}
+	image := Message{Role: "user", Content: "What is this?", Parts: []ContentPart{{Type: PartImage}}}
+
+	tests := []struct {
+		name       string
+		rules      []RouteRule
+		classifier string
+		override   string
+		messages   []Message
+		want       string
+		classified bool
+	}{
+		{name: "default route", messages: []Message{{Role: "user", Content: "hi"}}, want: "fast"},
+		{name: "keyword rule ignores case", rules: rules, messages: []Message{{Role: "user", Content: "please refactor this"}}, want: "smart"},
+		{
+			name:  "tool result rule",
+			rules: rules,
+			messages: []Message{
+				{Role: "user", Content: "hi"},
+				{Role: "assistant", Content: "Reading"},
+				{Role: "user", Content: ToolResultPrefix + "output</result>"},
+			},
+			want: "fast",
+		},
+		{name: "prompt length rule", rules: rules, messages: []Message{{Role: "user", Content: fmt.Sprintf("%0300d", 0)}}, want: "smart"},
+		{name: "classifier", rules: rules, classifier: "smart", messages: []Message{{Role: "user", Content: "hi"}}, want: "smart", classified: true},
+		{name: "classifier names an unknown route", classifier: "huge", messages: []Message{{Role: "user", Content: "hi"}}, want: "fast", classified: true},
+		{name: "override wins over rules", rules: rules, override: "fast", messages: []Message{{Role: "user", Content: "refactor"}}, want: "fast"},
+		{name: "images need vision", messages: []Message{image}, want: "vision"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, _ := newTestRouter(t, tt.rules, tt.classifier)
+			if err := router.SetOverride(tt.override); err != nil {
+				t.Fatal(err)
+			}
+			route, classifier := router.Select(context.Background(), tt.messages)
+			if route.Name != tt.want {
+				t.Errorf("route = %s, want %s", route.Name, tt.want)
+			}
+			if (classifier != nil) != tt.classified {
+				t.Errorf("classifier response = %+v, want one: %v", classifier, tt.classified)
+			}
+		})
+	}
+}
+
+func TestRouterClassifiesOncePerPrompt(t *testing.T) {
+	router, classifier := newTestRouter(t, nil, "smart")
+	ctx := context.Background()
+
+	messages := []Message{{Role: "system", Content: "Use tools."}, {Role: "user", Content: "Fix the build"}}
+	resp, err := router.GenerateResponse(ctx, messages)
+	if err != nil {
+		t.Fatalf("GenerateResponse: %v", err)
+	}
+	if resp.Model != "smart-model" || resp.Classifier == nil || resp.Classifier.Model != "classifier" {
+		t.Errorf("response model = %q, classifier = %+v", resp.Model, resp.Classifier)
+	}
+
+	// The follow-up to a tool result reuses the answer, as does the same prompt after
+	// compaction has moved it
+	messages = append(messages, Message{Role: "assistant", Content: "Building"}, Message{Role: "user", Content: ToolResultPrefix + "ok</result>"})
+	if resp, _ := router.GenerateResponse(ctx, messages); resp.Classifier != nil {
+		t.Error("classified the follow-up to a tool result")
+	}
+	compacted := append([]Message{messages[0], {Role: "user", Content: "Summary"}, {Role: "assistant", Content: "Understood"}}, messages[1:]...)
+	if resp, _ := router.GenerateResponse(ctx, compacted); resp.Classifier != nil {
+		t.Error("classified the prompt again after compaction")
+	}
+	if classifier.calls != 1 {
+		t.Errorf("classifier called %d times, want 1", classifier.calls)
+	}
+
+	messages = append(messages, Message{Role: "assistant", Content: "Done"}, Message{Role: "user", Content: "Now run the tests"})
+	if resp, _ := router.GenerateResponse(ctx, messages); resp.Classifier == nil {
+		t.Error("did not classify a new prompt")
+	}
+	if classifier.calls != 2 {
+		t.Errorf("classifier called %d times, want 2", classifier.calls)
+	}
+
+	// History summaries go to the default route without a classifier call
+	if model := router.FixedModel(); model.GetName() != "fast-model" {
+		t.Errorf("fixed model = %s", model.GetName())
+	}
+}
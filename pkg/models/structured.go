@@ -0,0 +1,218 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/invopop/jsonschema"
+)
+
+// maxStructuredRetries is the number of times a structured request is retried after validation fails
+const maxStructuredRetries = 2
+
+// structuredOutputName is the name given to the schema (and to the forced tool for Claude)
+const structuredOutputName = "structured_output"
+
+// SchemaFor returns the JSON schema generated for the Go type T
+func SchemaFor[T any]() json.RawMessage {
+	reflector := jsonschema.Reflector{
+		AllowAdditionalProperties: false,
+		DoNotReference:            true,
+	}
+	var v T
+	schema := reflector.Reflect(v)
+	schemaBytes, _ := json.Marshal(schema)
+	return schemaBytes
+}
+
+// GenerateInto asks the model for a response matching the JSON schema of T and decodes it into out
+func GenerateInto[T any](ctx context.Context, model Model, messages []Message, out *T) (*Response, error) {
+	return GenerateValidated(ctx, model, messages, SchemaFor[T](), out)
+}
+
+// GenerateValidated asks the model for a response matching the schema, validates it and decodes
+// it into out when out is not nil. When the response does not match, the request is retried with
+// the validation error so the model can correct itself. The usage of the returned response
+// covers every attempt, and when all attempts fail the last response is returned with the
+// error, so the cost of the retries can still be accounted for.
+func GenerateValidated(ctx context.Context, model Model, messages []Message, schema json.RawMessage, out interface{}) (*Response, error) {
+	attempt := make([]Message, len(messages))
+	copy(attempt, messages)
+
+	var last *Response
+	var usage Usage
+	var lastErr error
+	for i := 0; i <= maxStructuredRetries; i++ {
+		resp, err := model.GenerateStructured(ctx, attempt, schema)
+		if err != nil {
+			return last, err
+		}
+		usage.InputTokens += resp.Usage.InputTokens
+		usage.OutputTokens += resp.Usage.OutputTokens
+		usage.CacheCreationInputTokens += resp.Usage.CacheCreationInputTokens
+		usage.CacheReadInputTokens += resp.Usage.CacheReadInputTokens
+		usage.Cost += resp.Usage.Cost
+		resp.Usage = usage
+		last = resp
+
+		lastErr = ValidateJSON(schema, []byte(resp.Content))
+		if lastErr == nil && out != nil {
+			decoder := json.NewDecoder(strings.NewReader(resp.Content))
+			decoder.DisallowUnknownFields()
+			lastErr = decoder.Decode(out)
+		}
+		if lastErr == nil {
+			return resp, nil
+		}
+
+		attempt = append(attempt,
+			Message{Role: "assistant", Content: resp.Content},
+			Message{Role: "user", Content: fmt.Sprintf("The response does not match the required JSON schema: %v\nRespond again with only a JSON value that matches the schema.", lastErr)},
+		)
+	}
+	return last, fmt.Errorf("structured response failed validation after %d attempts: %w", maxStructuredRetries+1, lastErr)
+}
+
+// ValidateJSON checks a JSON document against a JSON schema. It supports the subset of JSON
+// schema produced by invopop/jsonschema for Go types: type, properties, required,
+// additionalProperties, items and enum.
+func ValidateJSON(schema json.RawMessage, data []byte) error {
+	var s map[string]interface{}
+	if err := json.Unmarshal(schema, &s); err != nil {
+		return fmt.Errorf("invalid schema: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	return validateValue(s, v, "$")
+}
+
+func validateValue(schema map[string]interface{}, v interface{}, path string) error {
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			if fmt.Sprint(allowed) == fmt.Sprint(v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: value %v is not one of %v", path, v, enum)
+		}
+	}
+
+	types := schemaTypes(schema["type"])
+	if len(types) > 0 {
+		matched := false
+		for _, t := range types {
+			if matchesType(t, v) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s: expected %s, got %s", path, strings.Join(types, " or "), jsonType(v))
+		}
+	}
+
+	switch value := v.(type) {
+	case map[string]interface{}:
+		properties, _ := schema["properties"].(map[string]interface{})
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				key, _ := name.(string)
+				if _, ok := value[key]; !ok {
+					return fmt.Errorf("%s: missing required property %q", path, key)
+				}
+			}
+		}
+
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			propSchema, ok := properties[key].(map[string]interface{})
+			if !ok {
+				if additional, ok := schema["additionalProperties"].(bool); ok && !additional {
+					return fmt.Errorf("%s: unexpected property %q", path, key)
+				}
+				continue
+			}
+			if err := validateValue(propSchema, value[key], path+"."+key); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range value {
+				if err := validateValue(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// schemaTypes returns the allowed types of a schema, which may be a single type or a list
+func schemaTypes(t interface{}) []string {
+	switch value := t.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		types := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				types = append(types, s)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+func matchesType(t string, v interface{}) bool {
+	switch t {
+	case "integer":
+		n, ok := v.(json.Number)
+		if !ok {
+			return false
+		}
+		f, err := n.Float64()
+		return err == nil && f == math.Trunc(f)
+	case "number":
+		_, ok := v.(json.Number)
+		return ok
+	default:
+		return jsonType(v) == t
+	}
+}
+
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
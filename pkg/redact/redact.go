@@ -0,0 +1,243 @@
+package redact
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Detector finds one kind of secret in text
+type Detector struct {
+	Kind string
+	// Pattern matches candidates. When it has a capturing group, only the first group is the
+	// secret and the rest of the match, e.g. a variable name, is kept.
+	Pattern *regexp.Regexp
+	// Check, if set, filters candidates; the entropy detector uses it to skip ordinary words
+	Check func(secret string) bool
+	// Ignore lists prefixes that mark a candidate as something else, such as the "h1:" of a
+	// go.sum hash. A candidate that starts with or directly follows one of them is kept.
+	Ignore []string
+}
+
+// Built-in detectors, in the order they are applied. Specific formats come before the
+// high-entropy fallback, so a key is labeled with its kind.
+var builtinDetectors = []Detector{
+	{
+		Kind:    "private-key",
+		Pattern: regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY(?: BLOCK)?-----[\s\S]*?-----END [A-Z0-9 ]*PRIVATE KEY(?: BLOCK)?-----`),
+	},
+	{
+		Kind:    "jwt",
+		Pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}`),
+	},
+	{
+		Kind:    "aws-access-key",
+		Pattern: regexp.MustCompile(`\b((?:AKIA|ASIA|ABIA|ACCA)[0-9A-Z]{16})\b`),
+	},
+	{
+		Kind:    "aws-secret-key",
+		Pattern: regexp.MustCompile(`(?i)aws_?secret_?(?:access_?)?key["']?\s*[:=]\s*["']?([A-Za-z0-9/+]{40})\b`),
+	},
+	{
+		Kind:    "api-key",
+		Pattern: regexp.MustCompile(`\b(sk-[A-Za-z0-9_-]{20,})`),
+	},
+	{
+		// Only values assigned to a key-like name or sent as a bearer token are candidates, so
+		// long identifiers, paths and base64 test data elsewhere in the text are left alone
+		Kind:    "high-entropy",
+		Pattern: regexp.MustCompile(`(?i)(?:(?:key|token|secret|passw(?:or)?d|pwd|credential|auth)\w*["']?\s*[:=]\s*["']?|\bbearer\s+)([A-Za-z0-9+/_-]{24,}={0,2})`),
+		Check:   isHighEntropy,
+		// Integrity hashes in go.sum, lockfiles and subresource integrity attributes are
+		// base64 digests that look random but are public
+		Ignore: []string{"h1:", "sha1-", "sha256-", "sha384-", "sha512-"},
+	},
+}
+
+// minEntropy is the Shannon entropy, in bits per character, above which a mixed-case
+// alphanumeric token is taken for a secret. Hex digests stay below it (at most 4 bits).
+const minEntropy = 4.2
+
+// DetectorKinds returns the kinds of the built-in detectors
+func DetectorKinds() []string {
+	kinds := make([]string, len(builtinDetectors))
+	for i, d := range builtinDetectors {
+		kinds[i] = d.Kind
+	}
+	return kinds
+}
+
+// Builtin returns the built-in detectors of the given kinds, or all of them when no kind is
+// given
+func Builtin(kinds ...string) ([]Detector, error) {
+	if len(kinds) == 0 {
+		return append([]Detector(nil), builtinDetectors...), nil
+	}
+	wanted := make(map[string]bool, len(kinds))
+	for _, kind := range kinds {
+		wanted[kind] = true
+	}
+	var detectors []Detector
+	for _, d := range builtinDetectors {
+		if wanted[d.Kind] {
+			detectors = append(detectors, d)
+			delete(wanted, d.Kind)
+		}
+	}
+	for kind := range wanted {
+		return nil, fmt.Errorf("unknown secret detector %q (expected one of %s)", kind, strings.Join(DetectorKinds(), ", "))
+	}
+	return detectors, nil
+}
+
+// Pattern returns a detector for a user-supplied regular expression
+func Pattern(kind, expr string) (Detector, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return Detector{}, fmt.Errorf("invalid redaction pattern %q: %w", expr, err)
+	}
+	return Detector{Kind: kind, Pattern: re}, nil
+}
+
+// Finding is one secret replaced by a placeholder
+type Finding struct {
+	Kind        string
+	Placeholder string
+}
+
+// Redactor replaces secrets with placeholders such as [REDACTED:aws-access-key#1]. The
+// placeholder records the kind of secret and numbers distinct secrets of a kind, so the same
+// secret gets the same placeholder everywhere it is redacted by one Redactor. The secret itself
+// is not recorded.
+type Redactor struct {
+	detectors []Detector
+
+	mu           sync.Mutex
+	placeholders map[string]string // Secret to its placeholder
+	counts       map[string]int    // Distinct secrets seen per kind
+}
+
+// New creates a redactor that applies the detectors in order
+func New(detectors ...Detector) *Redactor {
+	return &Redactor{
+		detectors:    detectors,
+		placeholders: make(map[string]string),
+		counts:       make(map[string]int),
+	}
+}
+
+// Redact replaces the secrets in text and reports what was replaced
+func (r *Redactor) Redact(text string) (string, []Finding) {
+	if r == nil || text == "" {
+		return text, nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var findings []Finding
+	for _, d := range r.detectors {
+		matches := d.Pattern.FindAllStringSubmatchIndex(text, -1)
+		if len(matches) == 0 {
+			continue
+		}
+		var b strings.Builder
+		last := 0
+		for _, m := range matches {
+			start, end := m[0], m[1]
+			if len(m) >= 4 && m[2] >= 0 {
+				start, end = m[2], m[3]
+			}
+			secret := text[start:end]
+			if d.ignored(text[:start], secret) || (d.Check != nil && !d.Check(secret)) {
+				continue
+			}
+			placeholder := r.placeholder(d.Kind, secret)
+			b.WriteString(text[last:start])
+			b.WriteString(placeholder)
+			last = end
+			findings = append(findings, Finding{Kind: d.Kind, Placeholder: placeholder})
+		}
+		if last > 0 {
+			b.WriteString(text[last:])
+			text = b.String()
+		}
+	}
+	return text, findings
+}
+
+// ignored reports whether a candidate, or the text before it, carries one of the prefixes the
+// detector ignores
+func (d Detector) ignored(before, candidate string) bool {
+	for _, prefix := range d.Ignore {
+		if strings.HasPrefix(candidate, prefix) || strings.HasSuffix(before, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Text returns text with its secrets replaced. It has the signature of export.Redactor.
+func (r *Redactor) Text(text string) string {
+	text, _ = r.Redact(text)
+	return text
+}
+
+func (r *Redactor) placeholder(kind, secret string) string {
+	if placeholder, ok := r.placeholders[secret]; ok {
+		return placeholder
+	}
+	r.counts[kind]++
+	placeholder := fmt.Sprintf("[REDACTED:%s#%d]", kind, r.counts[kind])
+	r.placeholders[secret] = placeholder
+	return placeholder
+}
+
+// Summary describes findings for the user, e.g. "2 secrets (api-key, jwt)"
+func Summary(findings []Finding) string {
+	kinds := make(map[string]bool)
+	for _, f := range findings {
+		kinds[f.Kind] = true
+	}
+	names := make([]string, 0, len(kinds))
+	for kind := range kinds {
+		names = append(names, kind)
+	}
+	sort.Strings(names)
+	noun := "secrets"
+	if len(findings) == 1 {
+		noun = "secret"
+	}
+	return fmt.Sprintf("%d %s (%s)", len(findings), noun, strings.Join(names, ", "))
+}
+
+// isHighEntropy reports whether a token mixes upper and lower case letters and digits and is
+// random enough to be a key rather than an identifier
+func isHighEntropy(token string) bool {
+	var upper, lower, digit bool
+	counts := make(map[rune]int)
+	for _, r := range token {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		}
+		counts[r]++
+	}
+	if !upper || !lower || !digit {
+		return false
+	}
+	var entropy float64
+	n := float64(len(token))
+	for _, c := range counts {
+		p := float64(c) / n
+		entropy -= p * math.Log2(p)
+	}
+	return entropy >= minEntropy
+}
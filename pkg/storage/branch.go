@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The messages of a conversation form a tree: each message names the one it follows in
+// ParentID. Editing or retrying a message adds a sibling, so earlier answers are kept, and a
+// branch is the path from the first message to a message without followers (a leaf). Messages
+// saved before branching existed have no ParentID and follow the message saved before them.
+
+// RootParentID is the ParentID of a message that starts a tree of its own, such as the first
+// message of a session or a prompt that replaces the first one with /edit. It keeps the legacy
+// rule for an empty ParentID from attaching the message to the one saved before it.
+const RootParentID = "root"
+
+// BranchInfo summarizes a branch of a conversation
+type BranchInfo struct {
+	Leaf       ChatMessage // Last message of the branch, which identifies it
+	Messages   int         // Number of messages from the start of the conversation
+	LastPrompt string      // Most recent prompt typed by the user
+	UpdatedAt  time.Time
+}
+
+// parentIDs returns the parent of each message of a conversation in save order
+func parentIDs(messages []ChatMessage) map[string]string {
+	parents := make(map[string]string, len(messages))
+	for i, msg := range messages {
+		switch {
+		case msg.ParentID == RootParentID:
+			parents[msg.ID] = ""
+		case msg.ParentID != "":
+			parents[msg.ID] = msg.ParentID
+		case i > 0:
+			parents[msg.ID] = messages[i-1].ID
+		default:
+			parents[msg.ID] = ""
+		}
+	}
+	return parents
+}
+
+// Branch returns the messages from the start of a conversation to the message with the
+// given ID, in order
+func Branch(messages []ChatMessage, id string) []ChatMessage {
+	byID := make(map[string]ChatMessage, len(messages))
+	for _, msg := range messages {
+		byID[msg.ID] = msg
+	}
+	parents := parentIDs(messages)
+
+	var path []ChatMessage
+	seen := make(map[string]bool)
+	for id != "" && !seen[id] {
+		msg, ok := byID[id]
+		if !ok {
+			break
+		}
+		seen[id] = true
+		path = append(path, msg)
+		id = parents[id]
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// LatestBranch returns the branch that ends with the most recently saved message
+func LatestBranch(messages []ChatMessage) []ChatMessage {
+	if len(messages) == 0 {
+		return nil
+	}
+	return Branch(messages, messages[len(messages)-1].ID)
+}
+
+// Leaves returns the last message of every branch, in save order
+func Leaves(messages []ChatMessage) []ChatMessage {
+	hasChildren := make(map[string]bool)
+	for _, parent := range parentIDs(messages) {
+		hasChildren[parent] = true
+	}
+	var leaves []ChatMessage
+	for _, msg := range messages {
+		if !hasChildren[msg.ID] {
+			leaves = append(leaves, msg)
+		}
+	}
+	return leaves
+}
+
+// Branches summarizes the branches of a conversation, oldest first
+func Branches(messages []ChatMessage) []BranchInfo {
+	var branches []BranchInfo
+	for _, leaf := range Leaves(messages) {
+		path := Branch(messages, leaf.ID)
+		info := BranchInfo{Leaf: leaf, Messages: len(path), UpdatedAt: leaf.Timestamp}
+		for i := len(path) - 1; i >= 0; i-- {
+			if IsPrompt(path[i]) {
+				info.LastPrompt = path[i].Content
+				break
+			}
+		}
+		branches = append(branches, info)
+	}
+	return branches
+}
+
+// FindBranch returns the branch through the message whose ID is or starts with id; a prefix
+// must be unique. When the message has followers, the branch continues to the most recently
+// saved leaf below it.
+func FindBranch(messages []ChatMessage, id string) ([]ChatMessage, error) {
+	var matches []ChatMessage
+	for _, msg := range messages {
+		if msg.ID == id {
+			matches = []ChatMessage{msg}
+			break
+		}
+		if id != "" && strings.HasPrefix(msg.ID, id) {
+			matches = append(matches, msg)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("message %s not found", id)
+	case 1:
+	default:
+		return nil, fmt.Errorf("message ID %s is ambiguous, it matches %d messages", id, len(matches))
+	}
+
+	target := matches[0].ID
+	leaves := Leaves(messages)
+	for i := len(leaves) - 1; i >= 0; i-- {
+		path := Branch(messages, leaves[i].ID)
+		for _, msg := range path {
+			if msg.ID == target {
+				return path, nil
+			}
+		}
+	}
+	return Branch(messages, target), nil
+}
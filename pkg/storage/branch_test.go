@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// branchIDs returns the IDs of the messages of a branch
+func branchIDs(branch []ChatMessage) []string {
+	ids := make([]string, len(branch))
+	for i, msg := range branch {
+		ids[i] = msg.ID
+	}
+	return ids
+}
+
+// testTree is a conversation in save order:
+//
+//	a1 (legacy, no parent) - a2 (legacy) - b1 - b2
+//	                                     \- c1 (retry of b1)
+//	r1 (new root after editing the first prompt) - r2
+var testTree = []ChatMessage{
+	{ID: "a1", Role: "user", Content: "first"},
+	{ID: "a2", Role: "assistant", Content: "answer"},
+	{ID: "b1", ParentID: "a2", Role: "user", Content: "second"},
+	{ID: "b2", ParentID: "b1", Role: "assistant", Content: "answer"},
+	{ID: "c1", ParentID: "a2", Role: "user", Content: "second, retried"},
+	{ID: "r1", ParentID: RootParentID, Role: "user", Content: "first, edited"},
+	{ID: "r2", ParentID: "r1", Role: "assistant", Content: "answer"},
+}
+
+func TestBranch(t *testing.T) {
+	tests := []struct {
+		id   string
+		want []string
+	}{
+		{id: "b2", want: []string{"a1", "a2", "b1", "b2"}},
+		{id: "c1", want: []string{"a1", "a2", "c1"}},
+		{id: "a2", want: []string{"a1", "a2"}},
+		// A new root does not follow the message saved before it
+		{id: "r2", want: []string{"r1", "r2"}},
+		{id: "missing", want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			if got := branchIDs(Branch(testTree, tt.id)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Branch(%s) = %v, want %v", tt.id, got, tt.want)
+			}
+		})
+	}
+
+	if got := branchIDs(Leaves(testTree)); !reflect.DeepEqual(got, []string{"b2", "c1", "r2"}) {
+		t.Errorf("Leaves = %v", got)
+	}
+	if got := branchIDs(LatestBranch(testTree)); !reflect.DeepEqual(got, []string{"r1", "r2"}) {
+		t.Errorf("LatestBranch = %v", got)
+	}
+}
+
+func TestFindBranch(t *testing.T) {
+	messages := append([]ChatMessage{}, testTree...)
+	messages = append(messages, ChatMessage{ID: "b1x", ParentID: "b2", Role: "user", Content: "third"})
+
+	tests := []struct {
+		id   string
+		want []string
+		err  string
+	}{
+		{id: "c1", want: []string{"a1", "a2", "c1"}},
+		// A message with followers continues to the most recently saved leaf below it
+		{id: "a2", want: []string{"a1", "a2", "b1", "b2", "b1x"}},
+		{id: "r", want: nil, err: "ambiguous"},
+		{id: "r1", want: []string{"r1", "r2"}},
+		// An exact ID wins over a longer ID it is a prefix of
+		{id: "b1", want: []string{"a1", "a2", "b1", "b2", "b1x"}},
+		{id: "b1x", want: []string{"a1", "a2", "b1", "b2", "b1x"}},
+		{id: "zz", err: "not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			branch, err := FindBranch(messages, tt.id)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("FindBranch(%s) error = %v, want %q", tt.id, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FindBranch(%s): %v", tt.id, err)
+			}
+			if got := branchIDs(branch); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FindBranch(%s) = %v, want %v", tt.id, got, tt.want)
+			}
+		})
+	}
+}
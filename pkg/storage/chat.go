@@ -2,81 +2,270 @@ package storage
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"time"
 
 	"llm-agent/pkg/models"
-
-	"github.com/google/uuid"
 )
 
 // ChatMessage represents a stored chat message
 type ChatMessage struct {
-	ID             string    `json:"id"`              // Unique message ID
-	ConversationID string    `json:"conversation_id"` // ID linking related messages
+	ID             string    `json:"id"`                  // Unique message ID
+	ConversationID string    `json:"conversation_id"`     // ID linking related messages
+	ParentID       string    `json:"parent_id,omitempty"` // Message this one follows in its branch
 	Role           string    `json:"role"`
 	Content        string    `json:"content"`
+	Reasoning      string    `json:"reasoning,omitempty"` // Model reasoning, kept apart from the answer
 	Timestamp      time.Time `json:"timestamp"`
 	Model          string    `json:"model"`
 	Usage          struct {
-		InputTokens  int64 `json:"input_tokens"`
-		OutputTokens int64 `json:"output_tokens"`
+		InputTokens              int64   `json:"input_tokens"`
+		OutputTokens             int64   `json:"output_tokens"`
+		CacheCreationInputTokens int64   `json:"cache_creation_input_tokens,omitempty"`
+		CacheReadInputTokens     int64   `json:"cache_read_input_tokens,omitempty"`
+		Cost                     float64 `json:"cost,omitempty"` // Cost in US dollars
 	} `json:"usage"`
+	Metrics     *models.Metrics `json:"metrics,omitempty"`      // Latency and throughput of the response
+	Attachments []string        `json:"attachments,omitempty"`  // Paths of images and files sent with the message
+	ToolCalls   []ToolCall      `json:"tool_calls,omitempty"`   // Tools called by an assistant message
+	ToolCallID  string          `json:"tool_call_id,omitempty"` // Call whose result a user message carries
+}
+
+// ToolCall records a tool call requested by the model and its outcome
+type ToolCall struct {
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+	Result    string          `json:"result,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	Duration  time.Duration   `json:"duration_ns,omitempty"`
+}
+
+// fileMode is the permission of the history files the storage creates. They hold prompts and
+// code, so only the owner can read them.
+const fileMode os.FileMode = 0600
+
+// SyncPolicy controls when appended messages are flushed to disk
+type SyncPolicy string
+
+const (
+	SyncAlways SyncPolicy = "always" // fsync after every message, survives power loss
+	SyncNone   SyncPolicy = "none"   // Leave flushing to the operating system, survives process crashes
+)
+
+// ParseSyncPolicy parses the name of a sync policy
+func ParseSyncPolicy(name string) (SyncPolicy, error) {
+	switch policy := SyncPolicy(name); policy {
+	case SyncAlways, SyncNone:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown sync policy %q (expected always or none)", name)
+	}
 }
 
-// ChatStorage handles saving chat history
+// ChatStorage is the file backend of Store. Messages are appended to a JSON Lines file, one
+// message per line, so saving costs the same however long the history is. Writers take an
+// exclusive file lock, so several agents can share a history file.
 type ChatStorage struct {
 	filePath string
+	sync     SyncPolicy
+	index    searchIndex
 }
 
-// NewChatStorage creates a new chat storage instance
+// NewChatStorage creates a new chat storage instance. History files in the older JSON array
+// format must be converted with MigrateJSONArray first.
 func NewChatStorage(filePath string) (*ChatStorage, error) {
 	// Create file if it doesn't exist
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		if err := os.WriteFile(filePath, []byte("[]"), 0644); err != nil {
-			return nil, fmt.Errorf("failed to create chat history file: %w", err)
-		}
+	file, err := os.OpenFile(filePath, os.O_RDONLY|os.O_CREATE, fileMode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create chat history file: %w", err)
+	}
+	defer file.Close()
+
+	legacy, err := isJSONArray(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chat history: %w", err)
 	}
-	return &ChatStorage{filePath: filePath}, nil
+	if legacy {
+		return nil, fmt.Errorf("%s uses the old JSON array format, convert it with: llm-agent history migrate %s", filePath, filePath)
+	}
+	return &ChatStorage{filePath: filePath, sync: SyncAlways}, nil
+}
+
+// SetSyncPolicy sets when appended messages are flushed to disk
+func (s *ChatStorage) SetSyncPolicy(policy SyncPolicy) {
+	s.sync = policy
 }
 
 // SaveMessage saves a chat message to the storage file
-func (s *ChatStorage) SaveMessage(msg models.Message, modelName string, usage models.Usage, conversationID string) error {
-	chatMsg := ChatMessage{
-		ID:             uuid.New().String(),
-		ConversationID: conversationID,
-		Role:           msg.Role,
-		Content:        msg.Content,
-		Timestamp:      time.Now(),
-		Model:          modelName,
-	}
-	chatMsg.Usage.InputTokens = usage.InputTokens
-	chatMsg.Usage.OutputTokens = usage.OutputTokens
-
-	// Read existing messages
-	data, err := os.ReadFile(s.filePath)
+func (s *ChatStorage) SaveMessage(msg ChatMessage) error {
+	return s.append(msg)
+}
+
+// append writes a message as a new line at the end of the history file
+func (s *ChatStorage) append(chatMsg ChatMessage) error {
+	line, err := json.Marshal(chatMsg)
 	if err != nil {
-		return fmt.Errorf("failed to read chat history: %w", err)
+		return fmt.Errorf("failed to marshal chat message: %w", err)
 	}
+	line = append(line, '\n')
 
-	var messages []ChatMessage
-	if err := json.Unmarshal(data, &messages); err != nil {
-		return fmt.Errorf("failed to parse chat history: %w", err)
+	file, err := s.openLocked()
+	if err != nil {
+		return err
 	}
+	defer file.Close()
+	defer unlockFile(file)
+
+	if err := repairTail(file); err != nil {
+		return fmt.Errorf("failed to repair chat history: %w", err)
+	}
+	if _, err := file.Write(line); err != nil {
+		return fmt.Errorf("failed to write chat history: %w", err)
+	}
+	if s.sync == SyncAlways {
+		if err := file.Sync(); err != nil {
+			return fmt.Errorf("failed to sync chat history: %w", err)
+		}
+	}
+	return nil
+}
 
-	// Append new message
-	messages = append(messages, chatMsg)
+// openLocked opens the history file for appending and takes an exclusive lock on it. Pruning
+// replaces the file, so a file that was replaced while waiting for the lock is opened again.
+func (s *ChatStorage) openLocked() (*os.File, error) {
+	for {
+		file, err := os.OpenFile(s.filePath, os.O_RDWR|os.O_CREATE|os.O_APPEND, fileMode)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open chat history: %w", err)
+		}
+		if err := lockFile(file, true); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to lock chat history: %w", err)
+		}
+
+		opened, err := file.Stat()
+		if err != nil {
+			unlockFile(file)
+			file.Close()
+			return nil, fmt.Errorf("failed to read chat history: %w", err)
+		}
+		current, err := os.Stat(s.filePath)
+		if err == nil && os.SameFile(opened, current) {
+			return file, nil
+		}
+		unlockFile(file)
+		file.Close()
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read chat history: %w", err)
+		}
+	}
+}
 
-	// Write back to file
-	newData, err := json.MarshalIndent(messages, "", "  ")
+// Messages returns all stored messages in the order they were saved
+func (s *ChatStorage) Messages() ([]ChatMessage, error) {
+	file, err := os.Open(s.filePath)
 	if err != nil {
-		return fmt.Errorf("failed to marshal chat history: %w", err)
+		return nil, fmt.Errorf("failed to read chat history: %w", err)
 	}
+	defer file.Close()
 
-	if err := os.WriteFile(s.filePath, newData, 0644); err != nil {
-		return fmt.Errorf("failed to write chat history: %w", err)
+	if err := lockFile(file, false); err != nil {
+		return nil, fmt.Errorf("failed to lock chat history: %w", err)
+	}
+	defer unlockFile(file)
+
+	return readMessages(file)
+}
+
+// TotalCost returns the cumulative cost in US dollars of all stored messages
+func (s *ChatStorage) TotalCost() (float64, error) {
+	messages, err := s.Messages()
+	if err != nil {
+		return 0, err
+	}
+
+	var total float64
+	for _, msg := range messages {
+		total += msg.Usage.Cost
+	}
+	return total, nil
+}
+
+// LoadConversation returns the messages of a conversation in the order they were saved
+func (s *ChatStorage) LoadConversation(conversationID string) ([]ChatMessage, error) {
+	messages, err := s.Messages()
+	if err != nil {
+		return nil, err
+	}
+
+	var conversation []ChatMessage
+	for _, msg := range messages {
+		if msg.ConversationID == conversationID {
+			conversation = append(conversation, msg)
+		}
+	}
+	return conversation, nil
+}
+
+// ListSessions returns the stored conversations, most recently active first
+func (s *ChatStorage) ListSessions() ([]Session, error) {
+	messages, err := s.Messages()
+	if err != nil {
+		return nil, err
+	}
+	return summarizeSessions(messages), nil
+}
+
+// Search returns the messages matching a full-text query, best matches first. The index is
+// kept in memory and catches up with the lines appended since the previous search.
+func (s *ChatStorage) Search(query SearchQuery) ([]SearchResult, error) {
+	terms, err := searchTerms(query.Text)
+	if err != nil {
+		return nil, err
+	}
+
+	s.index.mu.Lock()
+	defer s.index.mu.Unlock()
+	if err := s.index.update(s.filePath); err != nil {
+		return nil, err
+	}
+	return s.index.search(query, terms), nil
+}
+
+// DeleteSessions removes the conversations with the given IDs. The remaining lines are
+// written to a new file that replaces the history, so a crash leaves either the old or the
+// new history in place.
+func (s *ChatStorage) DeleteSessions(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	remove := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		remove[id] = true
+	}
+
+	file, err := s.openLocked()
+	if err != nil {
+		return err
 	}
+	defer file.Close()
+	defer unlockFile(file)
+
+	return rewriteLines(file, s.filePath, func(line []byte) (bool, error) {
+		var msg struct {
+			ConversationID string `json:"conversation_id"`
+		}
+		if err := json.Unmarshal(line, &msg); err != nil {
+			return false, err
+		}
+		return !remove[msg.ConversationID], nil
+	})
+}
 
+// Close releases the storage; the file is only open while reading or writing
+func (s *ChatStorage) Close() error {
 	return nil
 }
@@ -0,0 +1,388 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// encryptedPrefix marks an encrypted field value: base64 of the nonce followed by the AES-GCM
+// ciphertext
+const encryptedPrefix = "enc:v1:"
+
+// keyCheck is encrypted into the key info file to tell a wrong passphrase from corrupt data
+const keyCheck = "llm-agent chat history"
+
+// scrypt parameters for new keys, the interactive-login values recommended by the scrypt paper
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// KeyInfo describes how the key of an encrypted history is derived. It is stored next to the
+// history in a file with the .keyinfo suffix; the passphrase or key file itself is not stored.
+type KeyInfo struct {
+	Version int    `json:"version"`
+	KDF     string `json:"kdf"`
+	Salt    []byte `json:"salt"`
+	N       int    `json:"n"`
+	R       int    `json:"r"`
+	P       int    `json:"p"`
+	Check   string `json:"check"` // keyCheck encrypted with the derived key
+}
+
+// KeyInfoPath returns the path of the key info file of the history at path
+func KeyInfoPath(path string) string {
+	return path + ".keyinfo"
+}
+
+// IsEncrypted reports whether the history at path is encrypted, that is has a key info file
+func IsEncrypted(path string) (bool, error) {
+	_, err := os.Stat(KeyInfoPath(path))
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to check for key info: %w", err)
+}
+
+// Cipher encrypts and decrypts history fields with AES-256-GCM
+type Cipher struct {
+	aead cipher.AEAD
+	info KeyInfo
+}
+
+// NewCipher derives a key from a passphrase or the contents of a key file with a new random
+// salt. Reencrypt records the salt next to the history, so the key can be derived again.
+func NewCipher(secret []byte) (*Cipher, error) {
+	info := KeyInfo{Version: 1, KDF: "scrypt", Salt: make([]byte, 16), N: scryptN, R: scryptR, P: scryptP}
+	if _, err := rand.Read(info.Salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	c, err := deriveCipher(secret, info)
+	if err != nil {
+		return nil, err
+	}
+	if c.info.Check, err = c.encrypt(keyCheck, "check"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// OpenCipher derives the key of the encrypted history at path from a passphrase or the
+// contents of a key file, and checks that it is the right one
+func OpenCipher(path string, secret []byte) (*Cipher, error) {
+	data, err := os.ReadFile(KeyInfoPath(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s is not encrypted", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key info: %w", err)
+	}
+	var info KeyInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse key info: %w", err)
+	}
+	if info.Version != 1 || info.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported key info version %d (%s)", info.Version, info.KDF)
+	}
+	c, err := deriveCipher(secret, info)
+	if err != nil {
+		return nil, err
+	}
+	if check, err := c.decrypt(info.Check, "check"); err != nil || check != keyCheck {
+		return nil, fmt.Errorf("wrong passphrase or key file for %s", path)
+	}
+	return c, nil
+}
+
+func deriveCipher(secret []byte, info KeyInfo) (*Cipher, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("the passphrase is empty")
+	}
+	key, err := scrypt.Key(secret, info.Salt, info.N, info.R, info.P, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return &Cipher{aead: aead, info: info}, nil
+}
+
+// writeKeyInfo saves the key derivation parameters to file, readable only by the owner
+func (c *Cipher) writeKeyInfo(file string) error {
+	data, err := json.MarshalIndent(c.info, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal key info: %w", err)
+	}
+	if err := os.WriteFile(file, append(data, '\n'), fileMode); err != nil {
+		return fmt.Errorf("failed to write key info: %w", err)
+	}
+	return nil
+}
+
+// encrypt seals a field value, binding it to the message it belongs to. Empty values stay
+// empty, so optional fields are still left out of the history.
+func (c *Cipher) encrypt(value, messageID string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := c.aead.Seal(nonce, nonce, []byte(value), []byte(messageID))
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt opens a field value sealed by encrypt. Empty values stay empty; any other value
+// without the encrypted prefix is rejected, so plain text written into an encrypted history is
+// not read back as if it had been sealed with the key. Plain histories are read without a
+// cipher, see Reencrypt.
+func (c *Cipher) decrypt(value, messageID string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	encoded, ok := strings.CutPrefix(value, encryptedPrefix)
+	if !ok {
+		return "", fmt.Errorf("unencrypted value in message %s of an encrypted chat history", messageID)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < c.aead.NonceSize() {
+		return "", fmt.Errorf("malformed encrypted value in message %s", messageID)
+	}
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	plain, err := c.aead.Open(nil, nonce, ciphertext, []byte(messageID))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt message %s: %w", messageID, err)
+	}
+	return string(plain), nil
+}
+
+// sealMessage encrypts the text of a message: its content, reasoning, attachments and the
+// arguments, results and errors of its tool calls. IDs, roles, models, timestamps and usage
+// stay readable, so sessions can be listed, pruned and counted without the key.
+func (c *Cipher) sealMessage(msg ChatMessage) (ChatMessage, error) {
+	return TransformMessage(msg, func(value string) (string, error) {
+		return c.encrypt(value, msg.ID)
+	})
+}
+
+// openMessage decrypts a message sealed by sealMessage
+func (c *Cipher) openMessage(msg ChatMessage) (ChatMessage, error) {
+	return TransformMessage(msg, func(value string) (string, error) {
+		return c.decrypt(value, msg.ID)
+	})
+}
+
+// EncryptedStore encrypts the text of messages before they reach a backend and decrypts them
+// when they are read. Search decrypts the whole history into an in-memory index, since the
+// backend only sees ciphertext.
+type EncryptedStore struct {
+	store  Store
+	cipher *Cipher
+}
+
+// NewEncryptedStore wraps a backend so messages are encrypted with the cipher
+func NewEncryptedStore(store Store, c *Cipher) *EncryptedStore {
+	return &EncryptedStore{store: store, cipher: c}
+}
+
+// SaveMessage encrypts a message and saves it to the backend
+func (s *EncryptedStore) SaveMessage(msg ChatMessage) error {
+	sealed, err := s.cipher.sealMessage(msg)
+	if err != nil {
+		return err
+	}
+	return s.store.SaveMessage(sealed)
+}
+
+// Messages returns all stored messages, decrypted, in the order they were saved
+func (s *EncryptedStore) Messages() ([]ChatMessage, error) {
+	messages, err := s.store.Messages()
+	if err != nil {
+		return nil, err
+	}
+	return s.open(messages)
+}
+
+// LoadConversation returns the decrypted messages of a conversation
+func (s *EncryptedStore) LoadConversation(conversationID string) ([]ChatMessage, error) {
+	messages, err := s.store.LoadConversation(conversationID)
+	if err != nil {
+		return nil, err
+	}
+	return s.open(messages)
+}
+
+// ListSessions returns the stored conversations, most recently active first. Sessions are
+// summarized here because the backend cannot read the prompts.
+func (s *EncryptedStore) ListSessions() ([]Session, error) {
+	messages, err := s.Messages()
+	if err != nil {
+		return nil, err
+	}
+	return summarizeSessions(messages), nil
+}
+
+// Search returns the messages matching a full-text query, best matches first
+func (s *EncryptedStore) Search(query SearchQuery) ([]SearchResult, error) {
+	terms, err := searchTerms(query.Text)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := s.Messages()
+	if err != nil {
+		return nil, err
+	}
+
+	var index searchIndex
+	index.reset(nil)
+	for _, msg := range messages {
+		index.add(msg)
+	}
+	return index.search(query, terms), nil
+}
+
+// TotalCost returns the cumulative cost in US dollars of all stored messages
+func (s *EncryptedStore) TotalCost() (float64, error) {
+	return s.store.TotalCost()
+}
+
+// DeleteSessions removes the conversations with the given IDs and their messages
+func (s *EncryptedStore) DeleteSessions(ids []string) error {
+	return s.store.DeleteSessions(ids)
+}
+
+// Close closes the backend
+func (s *EncryptedStore) Close() error {
+	return s.store.Close()
+}
+
+func (s *EncryptedStore) open(messages []ChatMessage) ([]ChatMessage, error) {
+	opened := make([]ChatMessage, len(messages))
+	for i, msg := range messages {
+		var err error
+		if opened[i], err = s.cipher.openMessage(msg); err != nil {
+			return nil, err
+		}
+	}
+	return opened, nil
+}
+
+// Copy saves every message of src to dst in the order they were saved, keeping their IDs.
+// Copying between stores with different ciphers, or with and without one, encrypts, decrypts
+// or re-encrypts a history.
+func Copy(dst, src Store) (int, error) {
+	messages, err := src.Messages()
+	if err != nil {
+		return 0, err
+	}
+	for i, msg := range messages {
+		if err := dst.SaveMessage(msg); err != nil {
+			return i, err
+		}
+	}
+	return len(messages), nil
+}
+
+// OpenWith opens the chat history at path like Open, decrypting it with c unless c is nil
+func OpenWith(path string, sync SyncPolicy, c *Cipher) (Store, error) {
+	store, err := Open(path, sync)
+	if err != nil || c == nil {
+		return store, err
+	}
+	return NewEncryptedStore(store, c), nil
+}
+
+// Reencrypt replaces the history at path with a copy read with the cipher from and written
+// with the cipher to, and returns the number of messages. A nil from reads a plain history and
+// a nil to writes one, so Reencrypt also encrypts and decrypts histories. No agent may use the
+// history meanwhile.
+//
+// The copy replaces the history by a rename, but the key info file is replaced separately. If
+// Reencrypt is interrupted between the two, the new key info is left in a file with the
+// .keyinfo.new suffix, which must then be renamed to the .keyinfo file.
+func Reencrypt(path string, from, to *Cipher) (int, error) {
+	src, err := OpenWith(path, SyncNone, from)
+	if err != nil {
+		return 0, err
+	}
+	// Closed before the copy replaces the history, so SQLite is done with its write-ahead log
+	defer src.Close()
+
+	// The copy keeps the extension, so it is opened with the same backend
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*"+filepath.Ext(path))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create chat history: %w", err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	dst, err := OpenWith(tmp.Name(), SyncNone, to)
+	if err != nil {
+		return 0, err
+	}
+	n, err := Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return n, err
+	}
+	if err := src.Close(); err != nil {
+		return n, err
+	}
+	if err := syncFile(tmp.Name()); err != nil {
+		return n, err
+	}
+
+	newKeyInfo := KeyInfoPath(path) + ".new"
+	if to != nil {
+		if err := to.writeKeyInfo(newKeyInfo); err != nil {
+			return n, err
+		}
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return n, fmt.Errorf("failed to replace chat history: %w", err)
+	}
+	if to != nil {
+		if err := os.Rename(newKeyInfo, KeyInfoPath(path)); err != nil {
+			return n, fmt.Errorf("failed to replace key info: %w", err)
+		}
+	} else if err := os.Remove(KeyInfoPath(path)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return n, fmt.Errorf("failed to remove key info: %w", err)
+	}
+	return n, nil
+}
+
+// syncFile flushes a closed file to disk
+func syncFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to sync chat history: %w", err)
+	}
+	defer file.Close()
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync chat history: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestCipher returns a cipher with a new key derived from a test passphrase
+func newTestCipher(t *testing.T) *Cipher {
+	t.Helper()
+	c, err := NewCipher([]byte("correct horse"))
+	if err != nil {
+		t.Fatalf("NewCipher: %v", err)
+	}
+	return c
+}
+
+func TestCipherRoundTrip(t *testing.T) {
+	c := newTestCipher(t)
+	msg := ChatMessage{
+		ID:          "m1",
+		Role:        "assistant",
+		Content:     "The key is in config.yaml",
+		Reasoning:   "The user asked about the key",
+		Model:       "claude",
+		Attachments: []string{"/tmp/screenshot.png"},
+		ToolCalls: []ToolCall{
+			{ID: "call_1", Name: "read_file", Arguments: json.RawMessage(`{"path":"config.yaml"}`), Result: "key: value"},
+			{ID: "call_2", Name: "run", Error: "exit status 1"},
+		},
+	}
+
+	sealed, err := c.sealMessage(msg)
+	if err != nil {
+		t.Fatalf("sealMessage: %v", err)
+	}
+	for name, value := range map[string]string{
+		"content":    sealed.Content,
+		"reasoning":  sealed.Reasoning,
+		"attachment": sealed.Attachments[0],
+		"result":     sealed.ToolCalls[0].Result,
+		"error":      sealed.ToolCalls[1].Error,
+	} {
+		if !strings.HasPrefix(value, encryptedPrefix) {
+			t.Errorf("%s is not encrypted: %q", name, value)
+		}
+	}
+	if strings.Contains(string(sealed.ToolCalls[0].Arguments), "config.yaml") {
+		t.Errorf("arguments are not encrypted: %s", sealed.ToolCalls[0].Arguments)
+	}
+	if !json.Valid(sealed.ToolCalls[0].Arguments) {
+		t.Errorf("sealed arguments are not JSON: %s", sealed.ToolCalls[0].Arguments)
+	}
+	// Metadata stays readable, and empty fields stay empty
+	if sealed.ID != "m1" || sealed.Role != "assistant" || sealed.Model != "claude" || sealed.ToolCalls[1].Result != "" {
+		t.Errorf("sealed metadata = %+v", sealed)
+	}
+
+	opened, err := c.openMessage(sealed)
+	if err != nil {
+		t.Fatalf("openMessage: %v", err)
+	}
+	if !reflect.DeepEqual(opened, msg) {
+		t.Errorf("opened = %+v, want %+v", opened, msg)
+	}
+
+	// Every encryption uses a new nonce
+	again, err := c.sealMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Content == sealed.Content {
+		t.Error("the same value encrypted twice gives the same ciphertext")
+	}
+}
+
+func TestCipherRejectsTampering(t *testing.T) {
+	c := newTestCipher(t)
+	sealed, err := c.encrypt("secret text", "m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, encryptedPrefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw[len(raw)-1] ^= 1
+	flipped := encryptedPrefix + base64.StdEncoding.EncodeToString(raw)
+
+	other, err := NewCipher([]byte("another passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		cipher *Cipher
+		value  string
+		id     string
+		err    string
+	}{
+		{name: "flipped bit", cipher: c, value: flipped, id: "m1", err: "failed to decrypt"},
+		{name: "moved to another message", cipher: c, value: sealed, id: "m2", err: "failed to decrypt"},
+		{name: "other key", cipher: other, value: sealed, id: "m1", err: "failed to decrypt"},
+		{name: "unencrypted value", cipher: c, value: "injected text", id: "m1", err: "unencrypted value"},
+		{name: "malformed value", cipher: c, value: encryptedPrefix + "not base64!", id: "m1", err: "malformed"},
+		{name: "truncated value", cipher: c, value: encryptedPrefix + "AAAA", id: "m1", err: "malformed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.cipher.decrypt(tt.value, tt.id)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("decrypt error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+
+	if plain, err := c.decrypt(sealed, "m1"); err != nil || plain != "secret text" {
+		t.Errorf("decrypt = %q, %v", plain, err)
+	}
+}
+
+func TestOpenCipher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	if _, err := OpenCipher(path, []byte("correct horse")); err == nil || !strings.Contains(err.Error(), "not encrypted") {
+		t.Errorf("OpenCipher without key info error = %v", err)
+	}
+
+	c := newTestCipher(t)
+	if err := c.writeKeyInfo(KeyInfoPath(path)); err != nil {
+		t.Fatal(err)
+	}
+	if encrypted, err := IsEncrypted(path); err != nil || !encrypted {
+		t.Errorf("IsEncrypted = %v, %v", encrypted, err)
+	}
+
+	if _, err := OpenCipher(path, []byte("wrong horse")); err == nil || !strings.Contains(err.Error(), "wrong passphrase") {
+		t.Errorf("OpenCipher with the wrong passphrase error = %v", err)
+	}
+	opened, err := OpenCipher(path, []byte("correct horse"))
+	if err != nil {
+		t.Fatalf("OpenCipher: %v", err)
+	}
+	sealed, err := c.encrypt("hello", "m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain, err := opened.decrypt(sealed, "m1"); err != nil || plain != "hello" {
+		t.Errorf("decrypt with the reopened cipher = %q, %v", plain, err)
+	}
+}
@@ -0,0 +1,241 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// tailBlockSize is how much of the file is read at a time when looking for the last line
+const tailBlockSize = 4096
+
+// readMessages reads a JSON Lines history. A last line without a newline that does not parse
+// is the remainder of an interrupted write and is skipped.
+func readMessages(r io.Reader) ([]ChatMessage, error) {
+	reader := bufio.NewReader(r)
+	var messages []ChatMessage
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to read chat history: %w", err)
+		}
+		complete := err == nil
+
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			var msg ChatMessage
+			if jsonErr := json.Unmarshal(trimmed, &msg); jsonErr != nil {
+				if !complete {
+					break
+				}
+				return nil, fmt.Errorf("failed to parse chat history line %d: %w", lineNumber, jsonErr)
+			}
+			messages = append(messages, msg)
+		}
+		if !complete {
+			break
+		}
+	}
+	return messages, nil
+}
+
+// repairTail makes sure the file ends with a complete line before a message is appended. A
+// partial line left by a crash mid-write is removed; a complete message that only lacks its
+// newline, e.g. after editing the file by hand, is kept.
+func repairTail(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	if size == 0 {
+		return nil
+	}
+
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, size-1); err != nil {
+		return err
+	}
+	if last[0] == '\n' {
+		return nil
+	}
+
+	start, err := lastLineStart(file, size)
+	if err != nil {
+		return err
+	}
+	tail := make([]byte, size-start)
+	if _, err := file.ReadAt(tail, start); err != nil {
+		return err
+	}
+	if json.Valid(bytes.TrimSpace(tail)) {
+		_, err := file.Write([]byte{'\n'})
+		return err
+	}
+	return file.Truncate(start)
+}
+
+// lastLineStart returns the offset just after the last newline before size, or 0
+func lastLineStart(file *os.File, size int64) (int64, error) {
+	block := make([]byte, tailBlockSize)
+	for end := size; end > 0; {
+		start := end - tailBlockSize
+		if start < 0 {
+			start = 0
+		}
+		n, err := file.ReadAt(block[:end-start], start)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(block[:n], '\n'); i >= 0 {
+			return start + int64(i) + 1, nil
+		}
+		end = start
+	}
+	return 0, nil
+}
+
+// isJSONArray reports whether a history file uses the old format of a single JSON array
+func isJSONArray(r io.Reader) (bool, error) {
+	reader := bufio.NewReader(r)
+	for {
+		b, err := reader.ReadByte()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		default:
+			return b == '[', nil
+		}
+	}
+}
+
+// rewriteLines replaces the history at path, open and locked as file, with the complete lines
+// that keep accepts. The new file keeps the permissions of the old one.
+func rewriteLines(file *os.File, path string, keep func(line []byte) (bool, error)) error {
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to read chat history: %w", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read chat history: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create chat history: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	reader := bufio.NewReader(file)
+	writer := bufio.NewWriter(tmp)
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break // A partial last line is the remainder of an interrupted write
+		}
+		if err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to read chat history: %w", err)
+		}
+		trimmed := bytes.TrimSpace(line)
+		if len(trimmed) == 0 {
+			continue
+		}
+		ok, err := keep(trimmed)
+		if err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to parse chat history line %d: %w", lineNumber, err)
+		}
+		if ok {
+			writer.Write(trimmed)
+			writer.WriteByte('\n')
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write chat history: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync chat history: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write chat history: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to set permissions of chat history: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace chat history: %w", err)
+	}
+	return nil
+}
+
+// MigrateJSONArray converts a history file in the old JSON array format to a JSON Lines file
+// at dst and returns the number of messages. The source file is left untouched and dst must
+// not exist yet.
+func MigrateJSONArray(src, dst string) (int, error) {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read chat history: %w", err)
+	}
+	if legacy, _ := isJSONArray(bytes.NewReader(data)); !legacy {
+		return 0, fmt.Errorf("%s is not a JSON array chat history", src)
+	}
+	var messages []ChatMessage
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return 0, fmt.Errorf("failed to parse chat history: %w", err)
+	}
+
+	if _, err := os.Stat(dst); err == nil {
+		return 0, fmt.Errorf("%s already exists", dst)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return 0, fmt.Errorf("failed to check %s: %w", dst, err)
+	}
+
+	// Write to a temporary file and rename it, so dst is either complete or absent
+	tmp, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create migrated history: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	writer := bufio.NewWriter(tmp)
+	for _, msg := range messages {
+		line, err := json.Marshal(msg)
+		if err != nil {
+			tmp.Close()
+			return 0, fmt.Errorf("failed to marshal chat message: %w", err)
+		}
+		writer.Write(line)
+		writer.WriteByte('\n')
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return 0, fmt.Errorf("failed to write migrated history: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return 0, fmt.Errorf("failed to sync migrated history: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, fmt.Errorf("failed to write migrated history: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), fileMode); err != nil {
+		return 0, fmt.Errorf("failed to set permissions of migrated history: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return 0, fmt.Errorf("failed to move migrated history into place: %w", err)
+	}
+	return len(messages), nil
+}
@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	line1 = `{"id":"m1","role":"user","content":"hi"}`
+	line2 = `{"id":"m2","role":"assistant","content":"hello"}`
+)
+
+func TestReadMessages(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+		err     string
+	}{
+		{name: "empty", content: "", want: nil},
+		{name: "complete lines", content: line1 + "\n" + line2 + "\n", want: []string{"m1", "m2"}},
+		{name: "blank lines", content: "\n" + line1 + "\n\n  \n" + line2 + "\n", want: []string{"m1", "m2"}},
+		{name: "partial last line is skipped", content: line1 + "\n" + line2[:20], want: []string{"m1"}},
+		{name: "complete last line without newline", content: line1 + "\n" + line2, want: []string{"m1", "m2"}},
+		{name: "corrupt complete line", content: line1[:20] + "\n" + line2 + "\n", err: "line 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, err := readMessages(strings.NewReader(tt.content))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readMessages: %v", err)
+			}
+			var got []string
+			for _, msg := range messages {
+				got = append(got, msg.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("messages = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRepairTail(t *testing.T) {
+	// A partial line longer than a block makes lastLineStart read more than one block
+	long := `{"id":"m3","content":"` + strings.Repeat("x", 2*tailBlockSize)
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{name: "empty", content: "", want: ""},
+		{name: "complete", content: line1 + "\n", want: line1 + "\n"},
+		{name: "partial line is removed", content: line1 + "\n" + line2[:20], want: line1 + "\n"},
+		{name: "long partial line is removed", content: line1 + "\n" + long, want: line1 + "\n"},
+		{name: "partial first line is removed", content: line2[:20], want: ""},
+		{name: "missing newline is added", content: line1 + "\n" + line2, want: line1 + "\n" + line2 + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "history.jsonl")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = repairTail(file)
+			file.Close()
+			if err != nil {
+				t.Fatalf("repairTail: %v", err)
+			}
+
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("file = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSaveAfterInterruptedWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	if err := os.WriteFile(path, []byte(line1+"\n"+line2[:20]), 0600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewChatStorage(path)
+	if err != nil {
+		t.Fatalf("NewChatStorage: %v", err)
+	}
+	if err := store.SaveMessage(ChatMessage{ID: "m3", Role: "user", Content: "again"}); err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+
+	messages, err := store.Messages()
+	if err != nil {
+		t.Fatalf("Messages: %v", err)
+	}
+	if got := branchIDs(messages); !reflect.DeepEqual(got, []string{"m1", "m3"}) {
+		t.Errorf("messages = %v", got)
+	}
+}
+
+func TestIsJSONArray(t *testing.T) {
+	tests := []struct {
+		content string
+		want    bool
+	}{
+		{content: "", want: false},
+		{content: " \n\t[{}]", want: true},
+		{content: line1 + "\n", want: false},
+	}
+	for _, tt := range tests {
+		got, err := isJSONArray(strings.NewReader(tt.content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("isJSONArray(%q) = %v, want %v", tt.content, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package storage
+
+import "os"
+
+// lockFile is a no-op where flock is unavailable. Each message is still written with a
+// single append, which keeps concurrent writers from interleaving within a line on most
+// file systems.
+func lockFile(file *os.File, exclusive bool) error {
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// RetentionPolicy limits how much chat history is kept. Limits left at zero are not applied.
+// Whole sessions are pruned, least recently active first.
+type RetentionPolicy struct {
+	MaxAge      time.Duration // Prune sessions without messages for this long
+	MaxSessions int           // Keep at most this many sessions
+	MaxBytes    int64         // Keep at most this many bytes of history, measured as JSON Lines
+}
+
+// IsZero reports whether the policy sets no limit
+func (p RetentionPolicy) IsZero() bool {
+	return p.MaxAge <= 0 && p.MaxSessions <= 0 && p.MaxBytes <= 0
+}
+
+// SessionSize is a session with the size of its messages in JSON Lines
+type SessionSize struct {
+	Session
+	Bytes int64
+}
+
+// PlanPrune returns the sessions the policy removes at the given time, most recently active
+// first. Sessions listed in keep, such as the one being resumed, are never pruned.
+func PlanPrune(store Store, policy RetentionPolicy, now time.Time, keep ...string) ([]SessionSize, error) {
+	if policy.IsZero() {
+		return nil, nil
+	}
+	sessions, err := store.ListSessions()
+	if err != nil {
+		return nil, err
+	}
+	messages, err := store.Messages()
+	if err != nil {
+		return nil, err
+	}
+	sizes, err := sessionSizes(messages)
+	if err != nil {
+		return nil, err
+	}
+	protected := make(map[string]bool, len(keep))
+	for _, id := range keep {
+		protected[id] = true
+	}
+
+	// Sessions are listed most recently active first, so every limit is a single cutoff: once
+	// a session is over the session or byte limit, so is every older one
+	var prune []SessionSize
+	var kept int
+	var keptBytes int64
+	var overBytes bool
+	for _, session := range sessions {
+		size := SessionSize{Session: session, Bytes: sizes[session.ID]}
+		if policy.MaxBytes > 0 && keptBytes+size.Bytes > policy.MaxBytes {
+			overBytes = true
+		}
+		expired := (policy.MaxAge > 0 && now.Sub(session.UpdatedAt) > policy.MaxAge) ||
+			(policy.MaxSessions > 0 && kept >= policy.MaxSessions) ||
+			overBytes
+		if expired && !protected[session.ID] {
+			prune = append(prune, size)
+			continue
+		}
+		kept++
+		keptBytes += size.Bytes
+	}
+	return prune, nil
+}
+
+// sessionSizes returns the size in JSON Lines of the messages of each session
+func sessionSizes(messages []ChatMessage) (map[string]int64, error) {
+	sizes := make(map[string]int64)
+	for _, msg := range messages {
+		line, err := json.Marshal(msg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal chat message: %w", err)
+		}
+		sizes[msg.ConversationID] += int64(len(line)) + 1
+	}
+	return sizes, nil
+}
+
+// Prune deletes sessions from the store. With an archive directory, their messages are first
+// written to a gzip-compressed JSON Lines file there, which is returned; decompressed, it can
+// be used as a history file again. Messages of an encrypted store stay encrypted in the
+// archive, with a copy of the key info next to it.
+func Prune(store Store, ids []string, archiveDir string) (string, error) {
+	if len(ids) == 0 {
+		return "", nil
+	}
+
+	var archive string
+	if archiveDir != "" {
+		var err error
+		if archive, err = archiveSessions(store, ids, archiveDir); err != nil {
+			return "", err
+		}
+	}
+	if err := store.DeleteSessions(ids); err != nil {
+		return archive, err
+	}
+	return archive, nil
+}
+
+// archiveSessions writes the messages of sessions to a new gzip file in dir
+func archiveSessions(store Store, ids []string, dir string) (string, error) {
+	selected := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		selected[id] = true
+	}
+	var cipher *Cipher
+	if encrypted, ok := store.(*EncryptedStore); ok {
+		store, cipher = encrypted.store, encrypted.cipher
+	}
+	messages, err := store.Messages()
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	path := filepath.Join(dir, "sessions-"+time.Now().UTC().Format("20060102-150405")+".jsonl.gz")
+	if _, err := os.Stat(path); err == nil {
+		return "", fmt.Errorf("archive %s already exists", path)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("failed to check %s: %w", path, err)
+	}
+
+	// Write to a temporary file and rename it, so the archive is either complete or absent
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("failed to create archive: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	compressed := gzip.NewWriter(tmp)
+	writer := bufio.NewWriter(compressed)
+	for _, msg := range messages {
+		if !selected[msg.ConversationID] {
+			continue
+		}
+		line, err := json.Marshal(msg)
+		if err != nil {
+			tmp.Close()
+			return "", fmt.Errorf("failed to marshal chat message: %w", err)
+		}
+		writer.Write(line)
+		writer.WriteByte('\n')
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := compressed.Close(); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to sync archive: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), fileMode); err != nil {
+		return "", fmt.Errorf("failed to set permissions of archive: %w", err)
+	}
+	if cipher != nil {
+		if err := cipher.writeKeyInfo(KeyInfoPath(strings.TrimSuffix(path, ".gz"))); err != nil {
+			return "", err
+		}
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("failed to move archive into place: %w", err)
+	}
+	return path, nil
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestStore returns a JSON Lines store in a temporary directory holding the messages
+func newTestStore(t *testing.T, messages ...ChatMessage) *ChatStorage {
+	t.Helper()
+	store, err := NewChatStorage(filepath.Join(t.TempDir(), "history.jsonl"))
+	if err != nil {
+		t.Fatalf("NewChatStorage: %v", err)
+	}
+	for _, msg := range messages {
+		if err := store.SaveMessage(msg); err != nil {
+			t.Fatalf("SaveMessage: %v", err)
+		}
+	}
+	return store
+}
+
+func TestPlanPrune(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	session := func(id string, age time.Duration, content string) ChatMessage {
+		return ChatMessage{ID: id + "-1", ConversationID: id, Role: "user", Content: content, Timestamp: now.Add(-age)}
+	}
+	// From the most recently active: a small session, a large one and two small ones
+	store := newTestStore(t,
+		session("oldest", 72*time.Hour, "hi"),
+		session("old", 48*time.Hour, "hi"),
+		session("large", 24*time.Hour, strings.Repeat("x", 1000)),
+		session("recent", time.Hour, "hi"),
+	)
+	messages, err := store.Messages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizes, err := sessionSizes(messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	small := sizes["recent"]
+
+	tests := []struct {
+		name   string
+		policy RetentionPolicy
+		keep   []string
+		want   []string
+	}{
+		{name: "no limits", policy: RetentionPolicy{}, want: nil},
+		{name: "max age", policy: RetentionPolicy{MaxAge: 36 * time.Hour}, want: []string{"old", "oldest"}},
+		{name: "max sessions", policy: RetentionPolicy{MaxSessions: 2}, want: []string{"old", "oldest"}},
+		{
+			// The large session does not fit, so it and every older session are pruned even
+			// though the older ones would fit on their own
+			name:   "max bytes is a single cutoff",
+			policy: RetentionPolicy{MaxBytes: 3 * small},
+			want:   []string{"large", "old", "oldest"},
+		},
+		{name: "max bytes fits everything", policy: RetentionPolicy{MaxBytes: 3*small + sizes["large"]}, want: nil},
+		{
+			name:   "kept sessions count toward max bytes",
+			policy: RetentionPolicy{MaxBytes: 3 * small},
+			keep:   []string{"large"},
+			want:   []string{"old", "oldest"},
+		},
+		{
+			name:   "kept session over the limit",
+			policy: RetentionPolicy{MaxSessions: 1},
+			keep:   []string{"oldest"},
+			want:   []string{"large", "old"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prune, err := PlanPrune(store, tt.policy, now, tt.keep...)
+			if err != nil {
+				t.Fatalf("PlanPrune: %v", err)
+			}
+			var got []string
+			for _, session := range prune {
+				got = append(got, session.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pruned %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,354 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// SearchQuery is a full-text search over the chat history. Messages match when their content
+// or the names and arguments of their tool calls contain every term of Text. The filters are
+// ignored when left at their zero value.
+type SearchQuery struct {
+	Text  string
+	Model string    // Messages from a model whose name contains this
+	Since time.Time // Messages saved at or after this time
+	Until time.Time // Messages saved before this time
+	Roles []string  // Messages with one of these roles
+	Limit int       // Maximum number of results, 0 for all
+}
+
+// SearchResult is a message matching a search, best matches first
+type SearchResult struct {
+	Message ChatMessage
+	Score   float64  // BM25 relevance, higher is better
+	Snippet string   // The part of the message with the most query terms, on one line
+	Matches [][2]int // Byte ranges of the query terms in Snippet
+}
+
+// Highlight returns the snippet with the query terms wrapped in start and end
+func (r SearchResult) Highlight(start, end string) string {
+	var b strings.Builder
+	last := 0
+	for _, match := range r.Matches {
+		b.WriteString(r.Snippet[last:match[0]])
+		b.WriteString(start)
+		b.WriteString(r.Snippet[match[0]:match[1]])
+		b.WriteString(end)
+		last = match[1]
+	}
+	b.WriteString(r.Snippet[last:])
+	return b.String()
+}
+
+// BM25 parameters, the defaults of SQLite FTS5
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// snippetWords is the number of words shown around the matches of a search result
+const snippetWords = 24
+
+// searchTerms splits a query into its distinct terms
+func searchTerms(query string) ([]string, error) {
+	var terms []string
+	seen := make(map[string]bool)
+	for _, term := range tokenize(query) {
+		if !seen[term.text] {
+			seen[term.text] = true
+			terms = append(terms, term.text)
+		}
+	}
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("search query has no words")
+	}
+	return terms, nil
+}
+
+// token is a word of a text and its byte offsets
+type token struct {
+	text       string // Lowercase word
+	start, end int
+}
+
+// tokenize splits text into lowercase words of letters and digits, like the unicode61
+// tokenizer of SQLite FTS5
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWord && start < 0 {
+			start = i
+		} else if !isWord && start >= 0 {
+			tokens = append(tokens, token{strings.ToLower(text[start:i]), start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{strings.ToLower(text[start:]), start, len(text)})
+	}
+	return tokens
+}
+
+// toolCallText returns the searchable text of tool calls: their names and arguments
+func toolCallText(calls []ToolCall) string {
+	var lines []string
+	for _, call := range calls {
+		lines = append(lines, call.Name+" "+string(call.Arguments))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// searchText returns the searchable text of a message
+func searchText(msg ChatMessage) string {
+	if len(msg.ToolCalls) == 0 {
+		return msg.Content
+	}
+	return msg.Content + "\n" + toolCallText(msg.ToolCalls)
+}
+
+// matchesFilters reports whether a message passes the filters of a query
+func (q SearchQuery) matchesFilters(msg ChatMessage) bool {
+	if q.Model != "" && !strings.Contains(msg.Model, q.Model) {
+		return false
+	}
+	if !q.Since.IsZero() && msg.Timestamp.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !msg.Timestamp.Before(q.Until) {
+		return false
+	}
+	if len(q.Roles) > 0 {
+		for _, role := range q.Roles {
+			if msg.Role == role {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// newSearchResult builds the snippet of a matching message
+func newSearchResult(msg ChatMessage, score float64, terms []string) SearchResult {
+	result := SearchResult{Message: msg, Score: score}
+	text := searchText(msg)
+	tokens := tokenize(text)
+	if len(tokens) == 0 {
+		return result
+	}
+
+	wanted := make(map[string]bool, len(terms))
+	for _, term := range terms {
+		wanted[term] = true
+	}
+
+	// Pick the window of words with the most distinct terms, then the most matches
+	first, bestDistinct, bestCount := 0, -1, -1
+	for i := range tokens {
+		if !wanted[tokens[i].text] {
+			continue
+		}
+		start := max(0, i-snippetWords/4)
+		end := min(len(tokens), start+snippetWords)
+		distinct := make(map[string]bool)
+		count := 0
+		for _, tok := range tokens[start:end] {
+			if wanted[tok.text] {
+				distinct[tok.text] = true
+				count++
+			}
+		}
+		if len(distinct) > bestDistinct || (len(distinct) == bestDistinct && count > bestCount) {
+			first, bestDistinct, bestCount = start, len(distinct), count
+		}
+	}
+	last := min(len(tokens), first+snippetWords) - 1
+
+	begin, finish := tokens[first].start, tokens[last].end
+	if first == 0 {
+		begin = 0
+	}
+	if last == len(tokens)-1 {
+		finish = len(text)
+	}
+	var b strings.Builder
+	if begin > 0 {
+		b.WriteString("…")
+	}
+	offset := b.Len() - begin
+	b.WriteString(flattenLines(text[begin:finish]))
+	if finish < len(text) {
+		b.WriteString("…")
+	}
+	result.Snippet = b.String()
+
+	for _, tok := range tokens[first : last+1] {
+		if wanted[tok.text] {
+			result.Matches = append(result.Matches, [2]int{tok.start + offset, tok.end + offset})
+		}
+	}
+	return result
+}
+
+// flattenLines replaces line breaks and tabs with spaces, keeping the byte offsets of the text
+func flattenLines(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\r' || r == '\t' {
+			return ' '
+		}
+		return r
+	}, text)
+}
+
+// sortResults orders search results by score, then newest first, and applies the limit
+func sortResults(results []SearchResult, limit int) []SearchResult {
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Message.Timestamp.After(results[j].Message.Timestamp)
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// searchIndex is an in-memory inverted index over a JSON Lines history. It is built on the
+// first search and then only reads the lines appended since, by this process or others.
+type searchIndex struct {
+	mu       sync.Mutex
+	file     os.FileInfo // The indexed file, to notice when it is replaced
+	offset   int64       // Bytes of the file indexed so far
+	messages []ChatMessage
+	lengths  []int // Number of words of each message
+	total    int   // Number of words of all messages
+	postings map[string][]posting
+}
+
+// posting records how often a term occurs in a message
+type posting struct {
+	message int
+	count   int
+}
+
+// reset empties the index
+func (idx *searchIndex) reset(file os.FileInfo) {
+	idx.file = file
+	idx.offset = 0
+	idx.messages = nil
+	idx.lengths = nil
+	idx.total = 0
+	idx.postings = make(map[string][]posting)
+}
+
+// update indexes the complete lines added to the history file since the last update. The
+// index is rebuilt when the file has been replaced or has shrunk.
+func (idx *searchIndex) update(filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to read chat history: %w", err)
+	}
+	defer file.Close()
+
+	if err := lockFile(file, false); err != nil {
+		return fmt.Errorf("failed to lock chat history: %w", err)
+	}
+	defer unlockFile(file)
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to read chat history: %w", err)
+	}
+	if idx.file == nil || !os.SameFile(idx.file, info) || info.Size() < idx.offset {
+		idx.reset(info)
+	}
+	if _, err := file.Seek(idx.offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read chat history: %w", err)
+	}
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return nil // A line without a newline is still being written
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read chat history: %w", err)
+		}
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			var msg ChatMessage
+			if err := json.Unmarshal(trimmed, &msg); err != nil {
+				return fmt.Errorf("failed to parse chat history at byte %d: %w", idx.offset, err)
+			}
+			idx.add(msg)
+		}
+		idx.offset += int64(len(line))
+	}
+}
+
+// add indexes a message
+func (idx *searchIndex) add(msg ChatMessage) {
+	id := len(idx.messages)
+	counts := make(map[string]int)
+	tokens := tokenize(searchText(msg))
+	for _, tok := range tokens {
+		counts[tok.text]++
+	}
+	for term, count := range counts {
+		idx.postings[term] = append(idx.postings[term], posting{message: id, count: count})
+	}
+	idx.messages = append(idx.messages, msg)
+	idx.lengths = append(idx.lengths, len(tokens))
+	idx.total += len(tokens)
+}
+
+// search returns the messages containing every term, scored with BM25
+func (idx *searchIndex) search(query SearchQuery, terms []string) []SearchResult {
+	n := float64(len(idx.messages))
+	if n == 0 {
+		return nil
+	}
+	averageLength := float64(idx.total) / n
+
+	scores := make(map[int]float64)
+	for i, term := range terms {
+		postings := idx.postings[term]
+		idf := math.Log(1 + (n-float64(len(postings))+0.5)/(float64(len(postings))+0.5))
+		next := make(map[int]float64)
+		for _, p := range postings {
+			previous, ok := scores[p.message]
+			if i > 0 && !ok {
+				continue // Every term must match
+			}
+			tf := float64(p.count)
+			norm := 1 - bm25B + bm25B*float64(idx.lengths[p.message])/averageLength
+			next[p.message] = previous + idf*tf*(bm25K1+1)/(tf+bm25K1*norm)
+		}
+		scores = next
+	}
+
+	var results []SearchResult
+	for id, score := range scores {
+		if query.matchesFilters(idx.messages[id]) {
+			results = append(results, SearchResult{Message: idx.messages[id], Score: score})
+		}
+	}
+	results = sortResults(results, query.Limit)
+	for i := range results {
+		results[i] = newSearchResult(results[i].Message, results[i].Score, terms)
+	}
+	return results
+}
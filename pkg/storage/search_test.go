@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSearch(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	store := newTestStore(t,
+		ChatMessage{ID: "short", Role: "user", Content: "Update the config file", Timestamp: now},
+		ChatMessage{ID: "long", Role: "assistant", Content: "The config file " + strings.Repeat("and more words ", 20), Timestamp: now.Add(time.Minute)},
+		ChatMessage{ID: "repeated", Role: "assistant", Content: "config, config and config file", Timestamp: now.Add(2 * time.Minute)},
+		ChatMessage{ID: "other", Role: "user", Content: "Run the tests", Timestamp: now.Add(3 * time.Minute)},
+		ChatMessage{ID: "tool", Role: "assistant", Content: "Reading", Timestamp: now.Add(4 * time.Minute), ToolCalls: []ToolCall{
+			{Name: "read_file", Arguments: json.RawMessage(`{"path": "deploy.yaml"}`)},
+		}},
+	)
+
+	tests := []struct {
+		name  string
+		query SearchQuery
+		want  []string
+	}{
+		// More occurrences rank higher, and a short message above a long one
+		{name: "BM25 ranking", query: SearchQuery{Text: "config"}, want: []string{"repeated", "short", "long"}},
+		{name: "every term must match", query: SearchQuery{Text: "config update"}, want: []string{"short"}},
+		{name: "case and punctuation are ignored", query: SearchQuery{Text: "RUN, tests!"}, want: []string{"other"}},
+		{name: "tool call arguments", query: SearchQuery{Text: "deploy yaml"}, want: []string{"tool"}},
+		{name: "role filter", query: SearchQuery{Text: "config", Roles: []string{"user"}}, want: []string{"short"}},
+		{name: "time filter", query: SearchQuery{Text: "config", Since: now.Add(time.Minute), Until: now.Add(2 * time.Minute)}, want: []string{"long"}},
+		{name: "limit", query: SearchQuery{Text: "config", Limit: 1}, want: []string{"repeated"}},
+		{name: "no match", query: SearchQuery{Text: "missing"}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := store.Search(tt.query)
+			if err != nil {
+				t.Fatalf("Search: %v", err)
+			}
+			var got []string
+			for _, result := range results {
+				got = append(got, result.Message.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("results = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := store.Search(SearchQuery{Text: "?!"}); err == nil {
+		t.Error("expected an error for a query without words")
+	}
+
+	// Messages saved after the first search are indexed by the next one
+	if err := store.SaveMessage(ChatMessage{ID: "new", Role: "user", Content: "Run the tests again", Timestamp: now.Add(5 * time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	results, err := store.Search(SearchQuery{Text: "again"})
+	if err != nil || len(results) != 1 || results[0].Message.ID != "new" {
+		t.Errorf("results after saving = %+v, %v", results, err)
+	}
+}
+
+func TestSearchSnippet(t *testing.T) {
+	filler := strings.Repeat("word ", 40)
+	tests := []struct {
+		name    string
+		content string
+		terms   []string
+		want    string
+	}{
+		{name: "whole short message", content: "Fix the\nbuild", terms: []string{"build"}, want: "Fix the [build]"},
+		{
+			// A quarter of the words come before the first match
+			name:    "window around the match",
+			content: filler + "the flaky test fails " + filler,
+			terms:   []string{"flaky"},
+			want:    "…word word word word word the [flaky] test fails word word word word word word word word word word word word word word word…",
+		},
+		{
+			name:    "window with the most distinct terms",
+			content: "cache " + filler + filler + "cache cache miss " + filler,
+			terms:   []string{"cache", "miss"},
+			want:    "…word word word word word word [cache] [cache] [miss] word word word word word word word word word word word word word word word…",
+		},
+		{name: "multi-byte text", content: "Größe der Datei prüfen", terms: []string{"datei"}, want: "Größe der [Datei] prüfen"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := newSearchResult(ChatMessage{Content: tt.content}, 1, tt.terms)
+			if got := result.Highlight("[", "]"); got != tt.want {
+				t.Errorf("snippet = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
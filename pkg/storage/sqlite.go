@@ -0,0 +1,523 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"llm-agent/pkg/models"
+
+	_ "modernc.org/sqlite" // Pure Go SQLite driver
+)
+
+// sqliteMigrations create and upgrade the tables of the SQLite backend; the database's
+// user_version is the number of migrations applied. Sessions own messages, and each message
+// has its token usage and the tool calls it made in separate tables, so the history can be
+// queried directly, e.g. with Datasette. messages_fts is an FTS5 index of message content and
+// tool call arguments, keyed by the rowid of the message. parent_id links each message to the
+// one it follows, so edited and retried messages form a tree.
+var sqliteMigrations = []string{`
+CREATE TABLE IF NOT EXISTS sessions (
+	id         TEXT PRIMARY KEY,
+	started_at TEXT NOT NULL,
+	updated_at TEXT NOT NULL,
+	model      TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS messages (
+	id          TEXT PRIMARY KEY,
+	session_id  TEXT NOT NULL REFERENCES sessions(id),
+	role        TEXT NOT NULL,
+	content     TEXT NOT NULL,
+	reasoning   TEXT NOT NULL DEFAULT '',
+	model       TEXT NOT NULL DEFAULT '',
+	created_at  TEXT NOT NULL,
+	metrics     TEXT, -- JSON object
+	attachments TEXT  -- JSON array of paths
+);
+CREATE INDEX IF NOT EXISTS messages_session_id ON messages(session_id);
+
+CREATE TABLE IF NOT EXISTS usage (
+	message_id                  TEXT PRIMARY KEY REFERENCES messages(id),
+	input_tokens                INTEGER NOT NULL DEFAULT 0,
+	output_tokens               INTEGER NOT NULL DEFAULT 0,
+	cache_creation_input_tokens INTEGER NOT NULL DEFAULT 0,
+	cache_read_input_tokens     INTEGER NOT NULL DEFAULT 0,
+	cost                        REAL NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS tool_calls (
+	id          INTEGER PRIMARY KEY,
+	message_id  TEXT NOT NULL REFERENCES messages(id),
+	name        TEXT NOT NULL,
+	arguments   TEXT NOT NULL DEFAULT '',
+	result      TEXT NOT NULL DEFAULT '',
+	error       TEXT NOT NULL DEFAULT '',
+	duration_ms INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS tool_calls_message_id ON tool_calls(message_id);
+`, `
+ALTER TABLE messages ADD COLUMN tool_call_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE tool_calls ADD COLUMN call_id TEXT NOT NULL DEFAULT '';
+`, `
+CREATE VIRTUAL TABLE messages_fts USING fts5(content, tool_calls);
+INSERT INTO messages_fts (rowid, content, tool_calls)
+	SELECT m.rowid, m.content,
+		COALESCE((SELECT group_concat(name || ' ' || arguments, char(10)) FROM tool_calls WHERE message_id = m.id), '')
+	FROM messages m;
+`, `
+ALTER TABLE messages ADD COLUMN parent_id TEXT NOT NULL DEFAULT '';
+`}
+
+// messageColumns selects a message with its usage, in the order scanMessage reads them
+const messageColumns = `m.id, m.session_id, m.parent_id, m.role, m.content, m.reasoning, m.model, m.created_at, m.metrics, m.attachments, m.tool_call_id,
+	COALESCE(u.input_tokens, 0), COALESCE(u.output_tokens, 0), COALESCE(u.cache_creation_input_tokens, 0),
+	COALESCE(u.cache_read_input_tokens, 0), COALESCE(u.cost, 0)
+	FROM messages m LEFT JOIN usage u ON u.message_id = m.id`
+
+// sqliteTimeFormat stores timestamps in UTC with a fixed width, so they sort as text
+const sqliteTimeFormat = "2006-01-02T15:04:05.000000000Z07:00"
+
+// SQLiteStorage stores chat history in a SQLite database
+type SQLiteStorage struct {
+	db *sql.DB
+}
+
+// NewSQLiteStorage opens or creates a SQLite chat history database. The database uses
+// write-ahead logging so several agents can share it; SyncAlways makes every commit durable
+// against power loss.
+func NewSQLiteStorage(path string, sync SyncPolicy) (*SQLiteStorage, error) {
+	// Create the database file first so it gets fileMode; SQLite gives the write-ahead log
+	// the same permissions
+	file, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, fileMode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create chat history database: %w", err)
+	}
+	file.Close()
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open chat history database: %w", err)
+	}
+	// Pragmas apply per connection, so keep a single one
+	db.SetMaxOpenConns(1)
+
+	synchronous := "FULL"
+	if sync == SyncNone {
+		synchronous = "NORMAL"
+	}
+	pragmas := []string{
+		"PRAGMA journal_mode = WAL",
+		"PRAGMA busy_timeout = 5000",
+		"PRAGMA foreign_keys = ON",
+		"PRAGMA synchronous = " + synchronous,
+	}
+	for _, pragma := range pragmas {
+		if _, err := db.Exec(pragma); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to initialize chat history database: %w", err)
+		}
+	}
+	if err := migrateSQLite(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteStorage{db: db}, nil
+}
+
+// migrateSQLite applies the migrations the database does not have yet
+func migrateSQLite(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("failed to read chat history schema version: %w", err)
+	}
+	for ; version < len(sqliteMigrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to begin schema migration: %w", err)
+		}
+		if _, err := tx.Exec(sqliteMigrations[version]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to migrate chat history schema to version %d: %w", version+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to migrate chat history schema to version %d: %w", version+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit schema migration: %w", err)
+		}
+	}
+	return nil
+}
+
+// SaveMessage saves a chat message with its session, usage and tool calls in one transaction
+func (s *SQLiteStorage) SaveMessage(chatMsg ChatMessage) error {
+	timestamp := chatMsg.Timestamp.UTC().Format(sqliteTimeFormat)
+
+	metricsJSON, err := nullableJSON(chatMsg.Metrics, chatMsg.Metrics == nil)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metrics: %w", err)
+	}
+	attachmentsJSON, err := nullableJSON(chatMsg.Attachments, len(chatMsg.Attachments) == 0)
+	if err != nil {
+		return fmt.Errorf("failed to marshal attachments: %w", err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`INSERT INTO sessions (id, started_at, updated_at, model) VALUES (?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET updated_at = excluded.updated_at,
+			model = CASE WHEN excluded.model != '' THEN excluded.model ELSE sessions.model END`,
+		chatMsg.ConversationID, timestamp, timestamp, chatMsg.Model); err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+	result, err := tx.Exec(`INSERT INTO messages (id, session_id, parent_id, role, content, reasoning, model, created_at, metrics, attachments, tool_call_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		chatMsg.ID, chatMsg.ConversationID, chatMsg.ParentID, chatMsg.Role, chatMsg.Content, chatMsg.Reasoning, chatMsg.Model,
+		timestamp, metricsJSON, attachmentsJSON, chatMsg.ToolCallID)
+	if err != nil {
+		return fmt.Errorf("failed to save message: %w", err)
+	}
+	rowID, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to save message: %w", err)
+	}
+	if _, err := tx.Exec(`INSERT INTO usage (message_id, input_tokens, output_tokens, cache_creation_input_tokens, cache_read_input_tokens, cost)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		chatMsg.ID, chatMsg.Usage.InputTokens, chatMsg.Usage.OutputTokens, chatMsg.Usage.CacheCreationInputTokens,
+		chatMsg.Usage.CacheReadInputTokens, chatMsg.Usage.Cost); err != nil {
+		return fmt.Errorf("failed to save usage: %w", err)
+	}
+	for _, call := range chatMsg.ToolCalls {
+		if _, err := tx.Exec(`INSERT INTO tool_calls (message_id, call_id, name, arguments, result, error, duration_ms)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			chatMsg.ID, call.ID, call.Name, string(call.Arguments), call.Result, call.Error, call.Duration.Milliseconds()); err != nil {
+			return fmt.Errorf("failed to save tool call: %w", err)
+		}
+	}
+	if _, err := tx.Exec(`INSERT INTO messages_fts (rowid, content, tool_calls) VALUES (?, ?, ?)`,
+		rowID, chatMsg.Content, toolCallText(chatMsg.ToolCalls)); err != nil {
+		return fmt.Errorf("failed to index message: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit message: %w", err)
+	}
+	return nil
+}
+
+// Messages returns all stored messages in the order they were saved
+func (s *SQLiteStorage) Messages() ([]ChatMessage, error) {
+	return s.queryMessages(`SELECT ` + messageColumns + ` ORDER BY m.rowid`)
+}
+
+// LoadConversation returns the messages of a conversation in the order they were saved
+func (s *SQLiteStorage) LoadConversation(conversationID string) ([]ChatMessage, error) {
+	return s.queryMessages(`SELECT `+messageColumns+` WHERE m.session_id = ? ORDER BY m.rowid`, conversationID)
+}
+
+// ListSessions returns the stored conversations, most recently active first
+func (s *SQLiteStorage) ListSessions() ([]Session, error) {
+	rows, err := s.db.Query(`SELECT s.id, s.started_at, s.updated_at, s.model,
+		(SELECT COUNT(*) FROM messages WHERE session_id = s.id),
+		(SELECT COUNT(*) FROM messages WHERE session_id = s.id AND role = 'user' AND content NOT LIKE '<result>%'),
+		COALESCE((SELECT content FROM messages WHERE session_id = s.id AND role = 'user' AND content NOT LIKE '<result>%' ORDER BY rowid LIMIT 1), '')
+		FROM sessions s ORDER BY s.updated_at DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var session Session
+		var startedAt, updatedAt string
+		if err := rows.Scan(&session.ID, &startedAt, &updatedAt, &session.Model, &session.Messages, &session.Turns, &session.FirstPrompt); err != nil {
+			return nil, fmt.Errorf("failed to read session: %w", err)
+		}
+		session.StartedAt, _ = time.Parse(sqliteTimeFormat, startedAt)
+		session.UpdatedAt, _ = time.Parse(sqliteTimeFormat, updatedAt)
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// Search returns the messages matching a full-text query, best matches first. Matching and
+// ranking use the FTS5 index; the snippets are built like those of the JSON Lines backend.
+func (s *SQLiteStorage) Search(query SearchQuery) ([]SearchResult, error) {
+	terms, err := searchTerms(query.Text)
+	if err != nil {
+		return nil, err
+	}
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + term + `"` // Terms only hold letters and digits, so quoting is enough
+	}
+
+	conditions := []string{"messages_fts MATCH ?"}
+	args := []interface{}{strings.Join(quoted, " ")}
+	if query.Model != "" {
+		conditions = append(conditions, `m.model LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+likeEscaper.Replace(query.Model)+"%")
+	}
+	if !query.Since.IsZero() {
+		conditions = append(conditions, "m.created_at >= ?")
+		args = append(args, query.Since.UTC().Format(sqliteTimeFormat))
+	}
+	if !query.Until.IsZero() {
+		conditions = append(conditions, "m.created_at < ?")
+		args = append(args, query.Until.UTC().Format(sqliteTimeFormat))
+	}
+	if len(query.Roles) > 0 {
+		conditions = append(conditions, "m.role IN ("+strings.TrimSuffix(strings.Repeat("?,", len(query.Roles)), ",")+")")
+		for _, role := range query.Roles {
+			args = append(args, role)
+		}
+	}
+	limit := ""
+	if query.Limit > 0 {
+		limit = fmt.Sprintf(" LIMIT %d", query.Limit)
+	}
+
+	// bm25() is lower for better matches
+	rows, err := s.db.Query(`SELECT m.id, -bm25(messages_fts) FROM messages_fts JOIN messages m ON m.rowid = messages_fts.rowid
+		WHERE `+strings.Join(conditions, " AND ")+` ORDER BY bm25(messages_fts), m.created_at DESC`+limit, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages: %w", err)
+	}
+	var ids []string
+	scores := make(map[string]float64)
+	for rows.Next() {
+		var id string
+		var score float64
+		if err := rows.Scan(&id, &score); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to read search result: %w", err)
+		}
+		ids = append(ids, id)
+		scores[id] = score
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages: %w", err)
+	}
+
+	messages, err := s.messagesByID(ids)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]SearchResult, 0, len(ids))
+	for _, id := range ids {
+		if msg, ok := messages[id]; ok {
+			results = append(results, newSearchResult(msg, scores[id], terms))
+		}
+	}
+	return results, nil
+}
+
+// messagesByID loads messages with their tool calls
+func (s *SQLiteStorage) messagesByID(ids []string) (map[string]ChatMessage, error) {
+	messages := make(map[string]ChatMessage, len(ids))
+	for start := 0; start < len(ids); start += toolCallBatchSize {
+		end := min(len(ids), start+toolCallBatchSize)
+		args := make([]interface{}, 0, end-start)
+		for _, id := range ids[start:end] {
+			args = append(args, id)
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(args)), ",")
+		batch, err := s.queryMessages(`SELECT `+messageColumns+` WHERE m.id IN (`+placeholders+`)`, args...)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range batch {
+			messages[msg.ID] = msg
+		}
+	}
+	return messages, nil
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// TotalCost returns the cumulative cost in US dollars of all stored messages
+func (s *SQLiteStorage) TotalCost() (float64, error) {
+	var total float64
+	if err := s.db.QueryRow(`SELECT COALESCE(SUM(cost), 0) FROM usage`).Scan(&total); err != nil {
+		return 0, fmt.Errorf("failed to sum costs: %w", err)
+	}
+	return total, nil
+}
+
+// DeleteSessions removes the conversations with the given IDs with their messages, usage,
+// tool calls and search index entries, then vacuums the database to release the space
+func (s *SQLiteStorage) DeleteSessions(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		`DELETE FROM messages_fts WHERE rowid IN (SELECT rowid FROM messages WHERE session_id IN (%s))`,
+		`DELETE FROM tool_calls WHERE message_id IN (SELECT id FROM messages WHERE session_id IN (%s))`,
+		`DELETE FROM usage WHERE message_id IN (SELECT id FROM messages WHERE session_id IN (%s))`,
+		`DELETE FROM messages WHERE session_id IN (%s)`,
+		`DELETE FROM sessions WHERE id IN (%s)`,
+	}
+	for start := 0; start < len(ids); start += toolCallBatchSize {
+		end := min(len(ids), start+toolCallBatchSize)
+		args := make([]interface{}, 0, end-start)
+		for _, id := range ids[start:end] {
+			args = append(args, id)
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(args)), ",")
+		for _, statement := range statements {
+			if _, err := tx.Exec(fmt.Sprintf(statement, placeholders), args...); err != nil {
+				return fmt.Errorf("failed to delete sessions: %w", err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit deletion: %w", err)
+	}
+
+	if _, err := s.db.Exec("VACUUM"); err != nil {
+		return fmt.Errorf("failed to vacuum chat history database: %w", err)
+	}
+	return nil
+}
+
+// Close closes the database
+func (s *SQLiteStorage) Close() error {
+	return s.db.Close()
+}
+
+// queryMessages runs a query selecting messageColumns
+func (s *SQLiteStorage) queryMessages(query string, args ...interface{}) ([]ChatMessage, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []ChatMessage
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query messages: %w", err)
+	}
+	rows.Close()
+
+	if err := s.loadToolCalls(messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// toolCallBatchSize limits the message IDs per query, below SQLite's variable limit
+const toolCallBatchSize = 500
+
+// loadToolCalls fills in the tool calls of the messages
+func (s *SQLiteStorage) loadToolCalls(messages []ChatMessage) error {
+	index := make(map[string]int, len(messages))
+	for i, msg := range messages {
+		index[msg.ID] = i
+	}
+
+	for start := 0; start < len(messages); start += toolCallBatchSize {
+		end := start + toolCallBatchSize
+		if end > len(messages) {
+			end = len(messages)
+		}
+		args := make([]interface{}, 0, end-start)
+		for _, msg := range messages[start:end] {
+			args = append(args, msg.ID)
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(args)), ",")
+
+		rows, err := s.db.Query(`SELECT message_id, call_id, name, arguments, result, error, duration_ms
+			FROM tool_calls WHERE message_id IN (`+placeholders+`) ORDER BY id`, args...)
+		if err != nil {
+			return fmt.Errorf("failed to query tool calls: %w", err)
+		}
+		for rows.Next() {
+			var messageID, arguments string
+			var durationMS int64
+			var call ToolCall
+			if err := rows.Scan(&messageID, &call.ID, &call.Name, &arguments, &call.Result, &call.Error, &durationMS); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to read tool call: %w", err)
+			}
+			if arguments != "" {
+				call.Arguments = json.RawMessage(arguments)
+			}
+			call.Duration = time.Duration(durationMS) * time.Millisecond
+			msg := &messages[index[messageID]]
+			msg.ToolCalls = append(msg.ToolCalls, call)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return fmt.Errorf("failed to query tool calls: %w", err)
+		}
+	}
+	return nil
+}
+
+// scanMessage reads a row selected with messageColumns
+func scanMessage(rows *sql.Rows) (ChatMessage, error) {
+	var msg ChatMessage
+	var createdAt string
+	var metrics, attachments sql.NullString
+	if err := rows.Scan(&msg.ID, &msg.ConversationID, &msg.ParentID, &msg.Role, &msg.Content, &msg.Reasoning, &msg.Model, &createdAt,
+		&metrics, &attachments, &msg.ToolCallID, &msg.Usage.InputTokens, &msg.Usage.OutputTokens, &msg.Usage.CacheCreationInputTokens,
+		&msg.Usage.CacheReadInputTokens, &msg.Usage.Cost); err != nil {
+		return msg, fmt.Errorf("failed to read message: %w", err)
+	}
+	msg.Timestamp, _ = time.Parse(sqliteTimeFormat, createdAt)
+	if metrics.Valid {
+		msg.Metrics = &models.Metrics{}
+		if err := json.Unmarshal([]byte(metrics.String), msg.Metrics); err != nil {
+			return msg, fmt.Errorf("failed to parse metrics of message %s: %w", msg.ID, err)
+		}
+	}
+	if attachments.Valid {
+		if err := json.Unmarshal([]byte(attachments.String), &msg.Attachments); err != nil {
+			return msg, fmt.Errorf("failed to parse attachments of message %s: %w", msg.ID, err)
+		}
+	}
+	return msg, nil
+}
+
+// nullableJSON marshals a value, or returns NULL when empty is set
+func nullableJSON(value interface{}, empty bool) (interface{}, error) {
+	if empty {
+		return nil, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// StatsPeriod is the length of the periods history statistics are grouped by
+type StatsPeriod string
+
+const (
+	PeriodDay   StatsPeriod = "day"
+	PeriodWeek  StatsPeriod = "week" // Weeks start on Monday
+	PeriodMonth StatsPeriod = "month"
+)
+
+// ParseStatsPeriod parses the name of a statistics period
+func ParseStatsPeriod(name string) (StatsPeriod, error) {
+	switch period := StatsPeriod(name); period {
+	case PeriodDay, PeriodWeek, PeriodMonth:
+		return period, nil
+	default:
+		return "", fmt.Errorf("unknown period %q (expected day, week or month)", name)
+	}
+}
+
+// start returns the local start of the period containing t
+func (p StatsPeriod) start(t time.Time) time.Time {
+	t = t.Local()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+	switch p {
+	case PeriodWeek:
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case PeriodMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.Local)
+	default:
+		return day
+	}
+}
+
+// UsageTotals adds up the usage of model responses. Only assistant messages are counted: they
+// carry the token counts reported by the provider.
+type UsageTotals struct {
+	Responses    int
+	InputTokens  int64
+	OutputTokens int64
+	Cost         float64
+}
+
+func (u *UsageTotals) add(msg ChatMessage) {
+	u.Responses++
+	u.InputTokens += msg.Usage.InputTokens
+	u.OutputTokens += msg.Usage.OutputTokens
+	u.Cost += msg.Usage.Cost
+}
+
+// ModelStats is the usage of one model
+type ModelStats struct {
+	Model string
+	UsageTotals
+}
+
+// PeriodStats is the activity in one period
+type PeriodStats struct {
+	Start    time.Time
+	Sessions int // Sessions with messages in the period
+	Messages int
+	UsageTotals
+}
+
+// HistoryStats summarizes a chat history
+type HistoryStats struct {
+	Sessions int
+	Messages int
+	First    time.Time // Time of the oldest message
+	Last     time.Time // Time of the newest message
+	Total    UsageTotals
+	Models   []ModelStats  // Most expensive first
+	Periods  []PeriodStats // Oldest first
+}
+
+// ComputeStats summarizes messages by model and by period
+func ComputeStats(messages []ChatMessage, period StatsPeriod) HistoryStats {
+	var stats HistoryStats
+	sessions := make(map[string]bool)
+	byModel := make(map[string]*ModelStats)
+	byPeriod := make(map[time.Time]*PeriodStats)
+	periodSessions := make(map[time.Time]map[string]bool)
+
+	for _, msg := range messages {
+		stats.Messages++
+		sessions[msg.ConversationID] = true
+		if stats.First.IsZero() || msg.Timestamp.Before(stats.First) {
+			stats.First = msg.Timestamp
+		}
+		if msg.Timestamp.After(stats.Last) {
+			stats.Last = msg.Timestamp
+		}
+
+		start := period.start(msg.Timestamp)
+		p, ok := byPeriod[start]
+		if !ok {
+			p = &PeriodStats{Start: start}
+			byPeriod[start] = p
+			periodSessions[start] = make(map[string]bool)
+		}
+		p.Messages++
+		periodSessions[start][msg.ConversationID] = true
+
+		if msg.Role != "assistant" {
+			continue
+		}
+		stats.Total.add(msg)
+		p.add(msg)
+		m, ok := byModel[msg.Model]
+		if !ok {
+			m = &ModelStats{Model: msg.Model}
+			byModel[msg.Model] = m
+		}
+		m.add(msg)
+	}
+	stats.Sessions = len(sessions)
+
+	for _, m := range byModel {
+		stats.Models = append(stats.Models, *m)
+	}
+	sort.Slice(stats.Models, func(i, j int) bool {
+		if stats.Models[i].Cost != stats.Models[j].Cost {
+			return stats.Models[i].Cost > stats.Models[j].Cost
+		}
+		return stats.Models[i].Model < stats.Models[j].Model
+	})
+	for start, p := range byPeriod {
+		p.Sessions = len(periodSessions[start])
+		stats.Periods = append(stats.Periods, *p)
+	}
+	sort.Slice(stats.Periods, func(i, j int) bool {
+		return stats.Periods[i].Start.Before(stats.Periods[j].Start)
+	})
+	return stats
+}
@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"llm-agent/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+// Store is a chat history backend
+type Store interface {
+	// SaveMessage records a message of a conversation
+	SaveMessage(msg ChatMessage) error
+	// Messages returns all stored messages in the order they were saved
+	Messages() ([]ChatMessage, error)
+	// LoadConversation returns the messages of a conversation in the order they were saved
+	LoadConversation(conversationID string) ([]ChatMessage, error)
+	// ListSessions returns the stored conversations, most recently active first
+	ListSessions() ([]Session, error)
+	// Search returns the messages matching a full-text query, best matches first
+	Search(query SearchQuery) ([]SearchResult, error)
+	// TotalCost returns the cumulative cost in US dollars of all stored messages
+	TotalCost() (float64, error)
+	// DeleteSessions removes the conversations with the given IDs and their messages
+	DeleteSessions(ids []string) error
+	Close() error
+}
+
+// Session summarizes a stored conversation
+type Session struct {
+	ID          string    `json:"id"`
+	StartedAt   time.Time `json:"started_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Model       string    `json:"model"` // Model of the latest message
+	Messages    int       `json:"messages"`
+	Turns       int       `json:"turns"` // Prompts typed by the user
+	FirstPrompt string    `json:"first_prompt"`
+}
+
+// IsPrompt reports whether a stored message is a prompt typed by the user
+func IsPrompt(msg ChatMessage) bool {
+	return models.IsPrompt(models.Message{Role: msg.Role, Content: msg.Content})
+}
+
+// CompactionRecordPrefix starts the system messages that record a compaction of the history
+const CompactionRecordPrefix = "[Context compacted:"
+
+// IsCompactionRecord reports whether a stored message records a compaction of the history
+func IsCompactionRecord(msg ChatMessage) bool {
+	return msg.Role == "system" && strings.HasPrefix(msg.Content, CompactionRecordPrefix)
+}
+
+// Open opens the chat history at path. Files ending in .db, .sqlite or .sqlite3 use the
+// SQLite backend and any other file the JSON Lines backend.
+func Open(path string, sync SyncPolicy) (Store, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".db", ".sqlite", ".sqlite3":
+		return NewSQLiteStorage(path, sync)
+	default:
+		store, err := NewChatStorage(path)
+		if err != nil {
+			return nil, err
+		}
+		store.SetSyncPolicy(sync)
+		return store, nil
+	}
+}
+
+// NewChatMessage builds the stored form of a message with a new ID
+func NewChatMessage(msg models.Message, modelName string, usage models.Usage, metrics *models.Metrics, conversationID string) ChatMessage {
+	chatMsg := ChatMessage{
+		ID:             uuid.New().String(),
+		ConversationID: conversationID,
+		Role:           msg.Role,
+		Content:        msg.Content,
+		Reasoning:      msg.Reasoning,
+		Timestamp:      time.Now(),
+		Model:          modelName,
+		Metrics:        metrics,
+	}
+	for _, part := range msg.Parts {
+		if part.Path != "" {
+			chatMsg.Attachments = append(chatMsg.Attachments, part.Path)
+		}
+	}
+	chatMsg.Usage.InputTokens = usage.InputTokens
+	chatMsg.Usage.OutputTokens = usage.OutputTokens
+	chatMsg.Usage.CacheCreationInputTokens = usage.CacheCreationInputTokens
+	chatMsg.Usage.CacheReadInputTokens = usage.CacheReadInputTokens
+	chatMsg.Usage.Cost = usage.Cost
+	return chatMsg
+}
+
+// TransformMessage applies a function to the text fields of a message: its content, reasoning,
+// attachments and the arguments, results and errors of its tool calls. Encryption and secret
+// redaction both go through it. Tool call arguments are JSON, so they are transformed as text
+// and stored as a JSON string when the result is not JSON.
+func TransformMessage(msg ChatMessage, apply func(string) (string, error)) (ChatMessage, error) {
+	var err error
+	if msg.Content, err = apply(msg.Content); err != nil {
+		return msg, err
+	}
+	if msg.Reasoning, err = apply(msg.Reasoning); err != nil {
+		return msg, err
+	}
+	if len(msg.Attachments) > 0 {
+		attachments := make([]string, len(msg.Attachments))
+		for i, path := range msg.Attachments {
+			if attachments[i], err = apply(path); err != nil {
+				return msg, err
+			}
+		}
+		msg.Attachments = attachments
+	}
+	if len(msg.ToolCalls) == 0 {
+		return msg, nil
+	}
+
+	calls := make([]ToolCall, len(msg.ToolCalls))
+	for i, call := range msg.ToolCalls {
+		if call.Result, err = apply(call.Result); err != nil {
+			return msg, err
+		}
+		if call.Error, err = apply(call.Error); err != nil {
+			return msg, err
+		}
+		if len(call.Arguments) > 0 {
+			arguments := string(call.Arguments)
+			var encoded string
+			if json.Unmarshal(call.Arguments, &encoded) == nil && strings.HasPrefix(encoded, encryptedPrefix) {
+				arguments = encoded
+			}
+			if arguments, err = apply(arguments); err != nil {
+				return msg, err
+			}
+			if json.Valid([]byte(arguments)) {
+				call.Arguments = json.RawMessage(arguments)
+			} else {
+				call.Arguments, _ = json.Marshal(arguments)
+			}
+		}
+		calls[i] = call
+	}
+	msg.ToolCalls = calls
+	return msg, nil
+}
+
+// summarizeSessions groups messages into sessions, most recently active first
+func summarizeSessions(messages []ChatMessage) []Session {
+	byID := make(map[string]*Session)
+	var sessions []*Session
+	for _, msg := range messages {
+		session, ok := byID[msg.ConversationID]
+		if !ok {
+			session = &Session{ID: msg.ConversationID, StartedAt: msg.Timestamp}
+			byID[msg.ConversationID] = session
+			sessions = append(sessions, session)
+		}
+		session.UpdatedAt = msg.Timestamp
+		session.Messages++
+		if msg.Model != "" {
+			session.Model = msg.Model
+		}
+		if IsPrompt(msg) {
+			session.Turns++
+			if session.FirstPrompt == "" {
+				session.FirstPrompt = msg.Content
+			}
+		}
+	}
+
+	result := make([]Session, len(sessions))
+	for i, session := range sessions {
+		result[i] = *session
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].UpdatedAt.After(result[j].UpdatedAt)
+	})
+	return result
+}
+
+// FindSession returns the session whose ID is or starts with id; a prefix must be unique
+func FindSession(store Store, id string) (Session, error) {
+	sessions, err := store.ListSessions()
+	if err != nil {
+		return Session{}, err
+	}
+
+	var matches []Session
+	for _, session := range sessions {
+		if session.ID == id {
+			return session, nil
+		}
+		if id != "" && strings.HasPrefix(session.ID, id) {
+			matches = append(matches, session)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return Session{}, fmt.Errorf("session %s not found", id)
+	case 1:
+		return matches[0], nil
+	default:
+		return Session{}, fmt.Errorf("session ID %s is ambiguous, it matches %d sessions", id, len(matches))
+	}
+}
+
+// LatestSession returns the most recently active session
+func LatestSession(store Store) (Session, error) {
+	sessions, err := store.ListSessions()
+	if err != nil {
+		return Session{}, err
+	}
+	if len(sessions) == 0 {
+		return Session{}, fmt.Errorf("there are no stored sessions")
+	}
+	return sessions[0], nil
+}
@@ -14,29 +14,50 @@ import (
 // ReadFileTool implements the file reading tool
 type ReadFileTool struct {
 	BaseTool
+	allowImages bool
 }
 
 func NewReadFileTool() *ReadFileTool {
-	return &ReadFileTool{
+	t := &ReadFileTool{
 		BaseTool: BaseTool{
 			Name:        "read_file",
 			Description: "Read the contents of a given relative file path. Use this when you want to see what's inside a file. Do not use this with directory names.",
 			InputSchema: generateSchema[ReadFileInput](),
-			ExecuteFn:   readFile,
 		},
 	}
+	t.ExecuteFn = t.readFile
+	return t
+}
+
+// SetAllowImages controls whether image files are returned as images for vision models.
+// When disabled, reading an image file is an error.
+func (t *ReadFileTool) SetAllowImages(allow bool) {
+	t.allowImages = allow
+	if allow {
+		t.Description = "Read the contents of a given relative file path. Use this when you want to see what's inside a file, including images (png, jpg, gif, webp) which are shown to you directly. Do not use this with directory names."
+	}
 }
 
 type ReadFileInput struct {
 	Path string `json:"path" jsonschema_description:"The relative path of a file in the working directory."`
 }
 
-func readFile(input json.RawMessage) (string, error) {
+func (t *ReadFileTool) readFile(input json.RawMessage) (string, error) {
 	var readFileInput ReadFileInput
 	if err := json.Unmarshal(input, &readFileInput); err != nil {
 		return "", err
 	}
-	//
+
+	if isImageFile(readFileInput.Path) {
+		if !t.allowImages {
+			return "", fmt.Errorf("%s is an image and the current model does not support image input", readFileInput.Path)
+		}
+		if _, err := os.Stat(readFileInput.Path); err != nil {
+			return "", err
+		}
+		return ImageResult(readFileInput.Path), nil
+	}
+
 	content, err := os.ReadFile(readFileInput.Path)
 	if err != nil {
 		return "", err
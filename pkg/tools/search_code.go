@@ -0,0 +1,79 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// defaultSearchCodeLimit is the number of chunks returned when the model does not ask for a limit
+const defaultSearchCodeLimit = 5
+
+// CodeSearchResult is a chunk of source code matching a search query
+type CodeSearchResult struct {
+	Path      string
+	StartLine int
+	EndLine   int
+	Score     float64
+	Content   string
+}
+
+// CodeSearcher finds code relevant to a natural language or keyword query
+type CodeSearcher interface {
+	Search(ctx context.Context, query string, limit int) ([]CodeSearchResult, error)
+}
+
+// SearchCodeTool implements semantic search over the workspace
+type SearchCodeTool struct {
+	BaseTool
+	searcher CodeSearcher
+}
+
+func NewSearchCodeTool(searcher CodeSearcher) *SearchCodeTool {
+	t := &SearchCodeTool{
+		BaseTool: BaseTool{
+			Name:        "search_code",
+			Description: "Search the workspace for code relevant to a natural language description or keywords. Returns the best matching chunks with their file paths and line ranges. Use this to find where something is implemented before reading files.",
+			InputSchema: generateSchema[SearchCodeInput](),
+		},
+		searcher: searcher,
+	}
+	t.ExecuteFn = t.searchCode
+	return t
+}
+
+type SearchCodeInput struct {
+	Query string `json:"query" jsonschema_description:"What to look for, e.g. 'where chat messages are written to disk'"`
+	Limit int    `json:"limit,omitempty" jsonschema_description:"Maximum number of results to return (defaults to 5)"`
+}
+
+func (t *SearchCodeTool) searchCode(input json.RawMessage) (string, error) {
+	var searchInput SearchCodeInput
+	if err := json.Unmarshal(input, &searchInput); err != nil {
+		return "", err
+	}
+
+	if searchInput.Query == "" {
+		return "", fmt.Errorf("query is required")
+	}
+	if searchInput.Limit <= 0 {
+		searchInput.Limit = defaultSearchCodeLimit
+	}
+
+	results, err := t.searcher.Search(context.Background(), searchInput.Query, searchInput.Limit)
+	if err != nil {
+		return "", fmt.Errorf("failed to search code: %w", err)
+	}
+
+	if len(results) == 0 {
+		return "No matching code found", nil
+	}
+
+	var output strings.Builder
+	output.WriteString(fmt.Sprintf("Found %d matching chunks:\n", len(results)))
+	for _, result := range results {
+		output.WriteString(fmt.Sprintf("\n%s:%d-%d (score %.3f)\n%s\n", result.Path, result.StartLine, result.EndLine, result.Score, result.Content))
+	}
+	return output.String(), nil
+}
@@ -2,6 +2,8 @@ package tools
 
 import (
 	"encoding/json"
+	"path/filepath"
+	"strings"
 )
 
 // Tool defines the interface that all tools must implement
@@ -42,3 +44,28 @@ func (t *BaseTool) GetInputSchema() json.RawMessage {
 func (t *BaseTool) Execute(input json.RawMessage) (string, error) {
 	return t.ExecuteFn(input)
 }
+
+// imageResultPrefix marks a tool result that refers to an image the agent should attach to the conversation
+const imageResultPrefix = "[image] "
+
+// ImageResult returns a tool result asking the agent to attach the image at path
+func ImageResult(path string) string {
+	return imageResultPrefix + path
+}
+
+// ParseImageResult returns the image path of a result created with ImageResult
+func ParseImageResult(result string) (string, bool) {
+	if !strings.HasPrefix(result, imageResultPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(result, imageResultPrefix), true
+}
+
+// isImageFile reports whether the path has an image extension
+func isImageFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png", ".jpg", ".jpeg", ".gif", ".webp":
+		return true
+	}
+	return false
+}